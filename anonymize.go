@@ -0,0 +1,46 @@
+//go:build !purego
+
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	anonymizeBlur     = "blur"
+	anonymizePixelate = "pixelate"
+)
+
+func validateAnonymizeMethod(method string) error {
+	switch method {
+	case "", anonymizeBlur, anonymizePixelate:
+		return nil
+	}
+	return fmt.Errorf("unknown anonymize method %q (want blur or pixelate)", method)
+}
+
+// redactRegions destroys the content of every rect in img in place.
+func redactRegions(img *gocv.Mat, rects []image.Rectangle, method string) {
+	bounds := image.Rect(0, 0, img.Cols(), img.Rows())
+	for _, r := range rects {
+		r = r.Intersect(bounds)
+		if r.Empty() {
+			continue
+		}
+		region := img.Region(r)
+		switch method {
+		case anonymizePixelate:
+			small := gocv.NewMat()
+			gocv.Resize(region, &small, image.Pt(max(1, r.Dx()/16), max(1, r.Dy()/16)), 0, 0, gocv.InterpolationArea)
+			gocv.Resize(small, &region, image.Pt(r.Dx(), r.Dy()), 0, 0, gocv.InterpolationNearestNeighbor)
+			small.Close()
+		default:
+			k := max(r.Dx(), r.Dy())/2 | 1
+			gocv.GaussianBlur(region, &region, image.Pt(k, k), 0, 0, gocv.BorderDefault)
+		}
+		region.Close()
+	}
+}
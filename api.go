@@ -0,0 +1,60 @@
+//go:build !purego
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+
+	"gocv.io/x/gocv"
+)
+
+// DetectBytes finds faces in an encoded image held in memory, such as an
+// HTTP upload, without going through a temporary file. Input limits,
+// resizing and tiling follow opts; the returned rectangles are in the
+// coordinates of the decoded image.
+func (d *detectors) DetectBytes(data []byte, opts *options) ([]image.Rectangle, error) {
+	readFlag, err := checkHeader(bytes.NewReader(data), int64(len(data)), &opts.limits)
+	if err != nil {
+		return nil, fmt.Errorf("rejected input: %w", err)
+	}
+
+	img, err := gocv.IMDecode(data, readFlag)
+	if err != nil || img.Empty() {
+		img.Close()
+		return nil, errUnreadable
+	}
+	defer img.Close()
+
+	resized := gocv.NewMat()
+	defer resized.Close()
+	resizeForDetection(img, &resized, opts)
+
+	found, err := d.detectAll(img, resized, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sx := float64(img.Cols()) / float64(resized.Cols())
+	sy := float64(img.Rows()) / float64(resized.Rows())
+	faces := make([]image.Rectangle, len(found.faces))
+	for i, face := range found.faces {
+		faces[i] = scaleRect(face, sx, sy)
+	}
+	return faces, nil
+}
+
+// DetectReader is DetectBytes for a stream. At most the configured maximum
+// file size is read, so an endless body cannot exhaust memory.
+func (d *detectors) DetectReader(r io.Reader, opts *options) ([]image.Rectangle, error) {
+	if opts.limits.maxFileSize > 0 {
+		r = io.LimitReader(r, opts.limits.maxFileSize+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %v", err)
+	}
+	return d.DetectBytes(data, opts)
+}
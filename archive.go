@@ -0,0 +1,94 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxZipEntries is the most entries an uploaded archive may have.
+const maxZipEntries = 100000
+
+var errZipTooLarge = errors.New("archive extracts to more than the allowed size")
+
+// extractZip unpacks the regular files of a zip archive into dir, flattening
+// directories, since inputs are read from a single directory. Entries are
+// written with sanitized base names so an archive cannot escape dir, and
+// entries over maxSize bytes (when positive) are skipped. Extraction fails
+// once more than maxTotal bytes (when positive) have been written, whatever
+// sizes the archive declares.
+func extractZip(archive, dir string, maxSize, maxTotal int64) error {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer r.Close()
+	if len(r.File) > maxZipEntries {
+		return fmt.Errorf("archive has more than %d entries", maxZipEntries)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	var total int64
+	for _, f := range r.File {
+		if !f.Mode().IsRegular() || (maxSize > 0 && f.UncompressedSize64 > uint64(maxSize)) {
+			continue
+		}
+		name := filepath.Base(filepath.FromSlash(f.Name))
+		if name == "." || name == ".." || strings.HasPrefix(name, ".") {
+			continue
+		}
+		limit := int64(-1)
+		if maxSize > 0 {
+			limit = maxSize
+		}
+		overall := maxTotal > 0 && (limit < 0 || maxTotal-total <= limit)
+		if overall {
+			limit = maxTotal - total
+		}
+		n, err := extractZipFile(f, freePath(filepath.Join(dir, name)), limit)
+		if errors.Is(err, errZipTooLarge) && !overall {
+			// The entry was larger than its header said; skip it like any
+			// other oversized entry.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %v", f.Name, err)
+		}
+		total += n
+	}
+	return nil
+}
+
+// extractZipFile writes f to dst and returns the bytes written. Past limit
+// bytes (when not negative) it removes dst and returns errZipTooLarge.
+func extractZipFile(f *zip.File, dst string, limit int64) (int64, error) {
+	src, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	var r io.Reader = src
+	if limit >= 0 {
+		r = io.LimitReader(src, limit+1)
+	}
+	n, err := io.Copy(out, r)
+	if err == nil && limit >= 0 && n > limit {
+		err = errZipTooLarge
+	}
+	if err != nil {
+		out.Close()
+		os.Remove(dst)
+		return n, err
+	}
+	return n, out.Close()
+}
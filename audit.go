@@ -0,0 +1,166 @@
+//go:build !purego
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditTail is how much of an existing audit log is read to find its last
+// line when appending.
+const auditTail = 1 << 20
+
+// auditLog appends a line of JSON per event to --audit-log, as compliance
+// evidence: the start of a run with every parameter, each input with the
+// faces found and how they were redacted, and the end of the run. Each line
+// carries the SHA-256 of the line before it, so an edited or removed line
+// breaks the chain.
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+	prev string
+}
+
+type auditEntry struct {
+	Event      string            `json:"event"`
+	Time       time.Time         `json:"time"`
+	Prev       string            `json:"prev,omitempty"`
+	Version    string            `json:"version,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	File       *auditFile        `json:"file,omitempty"`
+	Summary    *batchSummary     `json:"summary,omitempty"`
+}
+
+// auditFile is one processed input. Overrides are the settings a file in
+// its directory changed; Redaction is the method applied to the Redacted
+// faces and plates in the annotated output.
+type auditFile struct {
+	Input     string            `json:"input"`
+	SHA256    string            `json:"sha256,omitempty"`
+	Overrides map[string]string `json:"overrides,omitempty"`
+	Faces     int               `json:"faces"`
+	Plates    int               `json:"plates,omitempty"`
+	Redaction string            `json:"redaction,omitempty"`
+	Redacted  int               `json:"redacted,omitempty"`
+	Outputs   []string          `json:"outputs,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// openAuditLog opens the log at path for appending, continuing the hash
+// chain from its last line.
+func openAuditLog(path string, mode os.FileMode) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, mode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	last, err := lastLine(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	l := &auditLog{file: f}
+	if last != nil {
+		l.prev = lineDigest(last)
+	}
+	return l, nil
+}
+
+func lastLine(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	start := max(info.Size()-auditTail, 0)
+	data := make([]byte, info.Size()-start)
+	if _, err := f.ReadAt(data, start); err != nil && err != io.EOF {
+		return nil, err
+	}
+	data = bytes.TrimRight(data, "\n")
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return data[bytes.LastIndexByte(data, '\n')+1:], nil
+}
+
+func lineDigest(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+func (l *auditLog) write(e auditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.Time = time.Now().UTC()
+	e.Prev = l.prev
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	l.prev = lineDigest(data)
+	return nil
+}
+
+// start records the build and every flag value of the run.
+func (l *auditLog) start(fs *flag.FlagSet) error {
+	params := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		params[f.Name] = f.Value.String()
+	})
+	return l.write(auditEntry{Event: "start", Version: toolVersion(), Parameters: params})
+}
+
+// input records one input, processed with opts.
+func (l *auditLog) input(p imageProgress, opts *options) error {
+	af := &auditFile{Input: p.Input, Overrides: opts.dirSettings, Faces: p.Faces, Error: p.Error}
+	if digest, err := fileSHA256(p.Input); err == nil {
+		af.SHA256 = digest
+	}
+	if p.Error == "" {
+		r := p.result
+		af.Plates = r.Plates
+		af.Outputs = nonEmpty(r.outputs())
+		if r.Annotated != "" {
+			af.Redacted = r.Plates
+			if opts.anonymize != "" {
+				af.Redacted += r.Faces
+			}
+			if af.Redacted > 0 {
+				af.Redaction = opts.anonymize
+				if af.Redaction == "" {
+					af.Redaction = anonymizeBlur
+				}
+			}
+		}
+	}
+	return l.write(auditEntry{Event: "file", File: af})
+}
+
+func (l *auditLog) end(summary batchSummary) error {
+	return l.write(auditEntry{Event: "end", Summary: &summary})
+}
+
+func (l *auditLog) Close() error {
+	return l.file.Close()
+}
+
+func nonEmpty(paths []string) []string {
+	var out []string
+	for _, p := range paths {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+//go:build !purego
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"math/rand"
+	"path/filepath"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	augmentFlip       = "flip"
+	augmentRotate     = "rotate"
+	augmentBrightness = "brightness"
+
+	augmentMaxAngle  = 10.0 // degrees either way
+	augmentMaxJitter = 0.2  // brightness factor range around 1
+)
+
+// augmenter writes augmented copies of exported crops. Random parameters
+// come from the seed and the crop's name, so a rerun with the same seed
+// reproduces every file whatever order images finish in.
+type augmenter struct {
+	kinds []string
+	seed  int64
+	opts  *options
+}
+
+func parseAugmentations(list string, seed int64, opts *options) (*augmenter, error) {
+	if list == "" {
+		return nil, nil
+	}
+	a := &augmenter{seed: seed, opts: opts}
+	for _, kind := range strings.Split(list, ",") {
+		switch kind = strings.TrimSpace(kind); kind {
+		case augmentFlip, augmentRotate, augmentBrightness:
+			a.kinds = append(a.kinds, kind)
+		default:
+			return nil, fmt.Errorf("unknown augmentation %q (want flip, rotate or brightness)", kind)
+		}
+	}
+	return a, nil
+}
+
+// apply writes one variant of the crop at path per configured augmentation,
+// next to it as <stem>_<kind>.webp.
+func (a *augmenter) apply(path string) ([]augmentedCrop, error) {
+	crop := gocv.IMRead(path, gocv.IMReadUnchanged)
+	if crop.Empty() {
+		return nil, errUnreadable
+	}
+	defer crop.Close()
+
+	h := fnv.New64a()
+	h.Write([]byte(filepath.Base(path)))
+	rng := rand.New(rand.NewSource(a.seed ^ int64(h.Sum64())))
+
+	stem := strings.TrimSuffix(path, filepath.Ext(path))
+	var out []augmentedCrop
+	for _, kind := range a.kinds {
+		variant := gocv.NewMat()
+		switch kind {
+		case augmentFlip:
+			gocv.Flip(crop, &variant, 1)
+		case augmentRotate:
+			angle := (rng.Float64()*2 - 1) * augmentMaxAngle
+			center := image.Pt(crop.Cols()/2, crop.Rows()/2)
+			m := gocv.GetRotationMatrix2D(center, angle, 1)
+			gocv.WarpAffineWithParams(crop, &variant, m, image.Pt(crop.Cols(), crop.Rows()),
+				gocv.InterpolationLinear, gocv.BorderReplicate, color.RGBA{})
+			m.Close()
+		case augmentBrightness:
+			factor := 1 + (rng.Float64()*2-1)*augmentMaxJitter
+			scaleBrightness(crop, &variant, float32(factor))
+		}
+		dst := stem + "_" + kind + ".webp"
+		err := saveMatAsWebP(variant, dst, a.opts.fileMode)
+		variant.Close()
+		if err != nil {
+			return out, err
+		}
+		out = append(out, augmentedCrop{path: dst, kind: kind})
+	}
+	return out, nil
+}
+
+// scaleBrightness multiplies the colour channels of src by factor, leaving
+// any alpha channel alone.
+func scaleBrightness(src gocv.Mat, dst *gocv.Mat, factor float32) {
+	if src.Channels() != 4 {
+		src.ConvertToWithParams(dst, src.Type(), factor, 0)
+		return
+	}
+	channels := gocv.Split(src)
+	defer func() {
+		for _, c := range channels {
+			c.Close()
+		}
+	}()
+	for _, c := range channels[:3] {
+		c.ConvertToWithParams(&c, c.Type(), factor, 0)
+	}
+	gocv.Merge(channels, dst)
+}
@@ -0,0 +1,90 @@
+//go:build !purego
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// loadAPIKeys collects the accepted keys from FACE_DETECTOR_API_KEYS
+// (comma-separated) and, when set, a file with one key per line. Blank
+// lines and lines starting with # are ignored.
+func loadAPIKeys(file string) ([]string, error) {
+	var keys []string
+	for _, k := range strings.Split(os.Getenv("FACE_DETECTOR_API_KEYS"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	if file == "" {
+		return keys, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys: %v", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		k := strings.TrimSpace(scanner.Text())
+		if k != "" && !strings.HasPrefix(k, "#") {
+			keys = append(keys, k)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read API keys: %v", err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no API keys in %s", file)
+	}
+	return keys, nil
+}
+
+// apiKeyContext is the context key of the API key a request was
+// authenticated with.
+type apiKeyContext struct{}
+
+// requireAPIKey rejects requests that carry none of keys, either as
+// "Authorization: Bearer <key>" or "X-API-Key: <key>", and passes the
+// accepted key on in the request context. With no keys configured every
+// request is let through.
+func requireAPIKey(keys []string, next http.Handler) http.Handler {
+	if len(keys) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := r.Header.Get("X-API-Key")
+		if auth := r.Header.Get("Authorization"); given == "" && strings.HasPrefix(auth, "Bearer ") {
+			given = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if !validKey(keys, given) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="face-detector"`)
+			writeJSON(w, http.StatusUnauthorized, errorResponse{"missing or invalid API key"})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContext{}, given)))
+	})
+}
+
+// authenticatedKey returns the key requireAPIKey accepted for r, if any.
+func authenticatedKey(r *http.Request) (string, bool) {
+	key, ok := r.Context().Value(apiKeyContext{}).(string)
+	return key, ok
+}
+
+// validKey compares in constant time against every key so the response time
+// does not reveal which, or how much of one, matched.
+func validKey(keys []string, given string) bool {
+	ok := 0
+	for _, k := range keys {
+		ok |= subtle.ConstantTimeCompare([]byte(k), []byte(given))
+	}
+	return given != "" && ok == 1
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"os"
+	"sort"
+	"time"
+)
+
+// Crops from two shots are of the same face when the box barely moved and
+// the crops look alike.
+const (
+	burstIoU      = 0.5
+	burstDistance = 10
+)
+
+// burstCrop is one face crop with what is needed to spot its repeats.
+type burstCrop struct {
+	path      string
+	taken     time.Time
+	box       [4]float64 // normalized x0, y0, x1, y1
+	hash      uint64
+	sharpness float64
+}
+
+// burstGroup reports one set of repeated crops and the one kept.
+type burstGroup struct {
+	Kept    string   `json:"kept"`
+	Removed []string `json:"removed"`
+}
+
+// burstFilter finds face crops repeated across burst shots: photos taken
+// within window of each other with a face in the same place that looks the
+// same. Of each such set only the sharpest crop is kept. Crops of images
+// without a capture time are never treated as repeats.
+type burstFilter struct {
+	window time.Duration
+	crops  []burstCrop
+}
+
+func (f *burstFilter) add(r imageResult) {
+	taken, ok := captureTime(r)
+	if !ok || len(r.Boxes) != len(r.Crops) || r.Width == 0 || r.Height == 0 {
+		return
+	}
+	w, h := float64(r.Width), float64(r.Height)
+	for i, path := range r.Crops {
+		img, err := readWebP(path)
+		if err != nil {
+			fmt.Printf("Error reading %s: %v\n", path, err)
+			continue
+		}
+		gray := grayImage(img)
+		b := r.Boxes[i]
+		f.crops = append(f.crops, burstCrop{
+			path:      path,
+			taken:     taken,
+			box:       [4]float64{float64(b.X) / w, float64(b.Y) / h, float64(b.X+b.W) / w, float64(b.Y+b.H) / h},
+			hash:      grayDHash(gray),
+			sharpness: laplacianVariance(gray),
+		})
+	}
+}
+
+func (f *burstFilter) repeats(a, b burstCrop) bool {
+	return b.taken.Sub(a.taken) <= f.window &&
+		boxIoU(a.box, b.box) >= burstIoU &&
+		bits.OnesCount64(a.hash^b.hash) <= burstDistance
+}
+
+// suppress deletes all but the sharpest crop of each set of repeats and
+// writes the sets to reportPath.
+func (f *burstFilter) suppress(reportPath string, mode os.FileMode) (int, error) {
+	sort.SliceStable(f.crops, func(i, j int) bool { return f.crops[i].taken.Before(f.crops[j].taken) })
+
+	// Union the repeats; crops are in time order, so each only needs
+	// comparing with those up to window later.
+	parent := make([]int, len(f.crops))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range f.crops {
+		for j := i + 1; j < len(f.crops) && f.crops[j].taken.Sub(f.crops[i].taken) <= f.window; j++ {
+			if f.repeats(f.crops[i], f.crops[j]) {
+				parent[root(j)] = root(i)
+			}
+		}
+	}
+
+	sets := map[int][]burstCrop{}
+	var roots []int
+	for i, c := range f.crops {
+		r := root(i)
+		if len(sets[r]) == 0 {
+			roots = append(roots, r)
+		}
+		sets[r] = append(sets[r], c)
+	}
+
+	var groups []burstGroup
+	removed := 0
+	for _, r := range roots {
+		set := sets[r]
+		if len(set) < 2 {
+			continue
+		}
+		best := 0
+		for i, c := range set {
+			if c.sharpness > set[best].sharpness {
+				best = i
+			}
+		}
+		g := burstGroup{Kept: set[best].path}
+		for i, c := range set {
+			if i == best {
+				continue
+			}
+			if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+				return removed, err
+			}
+			g.Removed = append(g.Removed, c.path)
+			removed++
+		}
+		groups = append(groups, g)
+	}
+	if len(groups) == 0 {
+		return 0, nil
+	}
+
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return removed, err
+	}
+	return removed, writeFileAtomic(reportPath, append(data, '\n'), mode)
+}
+
+func boxIoU(a, b [4]float64) float64 {
+	ix := min(a[2], b[2]) - max(a[0], b[0])
+	iy := min(a[3], b[3]) - max(a[1], b[1])
+	if ix <= 0 || iy <= 0 {
+		return 0
+	}
+	inter := ix * iy
+	union := (a[2]-a[0])*(a[3]-a[1]) + (b[2]-b[0])*(b[3]-b[1]) - inter
+	return inter / union
+}
+
+func grayImage(img image.Image) *image.Gray {
+	b := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			gray.Set(x, y, color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)))
+		}
+	}
+	return gray
+}
+
+// grayDHash is dHash for a decoded crop, shrinking it to 9x8 by averaging.
+func grayDHash(gray *image.Gray) uint64 {
+	w, h := gray.Rect.Dx(), gray.Rect.Dy()
+	var cells [8][9]float64
+	for cy := range 8 {
+		for cx := range 9 {
+			x0, x1 := cx*w/9, max((cx+1)*w/9, cx*w/9+1)
+			y0, y1 := cy*h/8, max((cy+1)*h/8, cy*h/8+1)
+			sum, n := 0, 0
+			for y := y0; y < min(y1, h); y++ {
+				for x := x0; x < min(x1, w); x++ {
+					sum += int(gray.GrayAt(x, y).Y)
+					n++
+				}
+			}
+			if n > 0 {
+				cells[cy][cx] = float64(sum) / float64(n)
+			}
+		}
+	}
+	var hash uint64
+	for y := range 8 {
+		for x := range 8 {
+			hash <<= 1
+			if cells[y][x] < cells[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// laplacianVariance measures focus: blur flattens the second derivative, so
+// sharper crops have a larger variance of the Laplacian.
+func laplacianVariance(gray *image.Gray) float64 {
+	w, h := gray.Rect.Dx(), gray.Rect.Dy()
+	var sum, sumSq float64
+	n := 0
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			v := float64(gray.GrayAt(x-1, y).Y) + float64(gray.GrayAt(x+1, y).Y) +
+				float64(gray.GrayAt(x, y-1).Y) + float64(gray.GrayAt(x, y+1).Y) -
+				4*float64(gray.GrayAt(x, y).Y)
+			sum += v
+			sumSq += v * v
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	mean := sum / float64(n)
+	return sumSq/float64(n) - mean*mean
+}
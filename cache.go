@@ -0,0 +1,182 @@
+//go:build !purego
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"gocv.io/x/gocv"
+)
+
+var cacheBucket = []byte("detections")
+
+// detectionCache stores detection results keyed by the SHA-256 of the input
+// file and a fingerprint of every setting that affects detection, so runs
+// that only change crop or output settings skip the detectors.
+type detectionCache struct {
+	db          *bolt.DB
+	fingerprint string
+}
+
+type cachedDetections struct {
+	Faces  []image.Rectangle `json:"faces"`
+	Plates []image.Rectangle `json:"plates,omitempty"`
+	People []image.Rectangle `json:"people,omitempty"`
+}
+
+func openDetectionCache(path string, settings ...interface{}) (*detectionCache, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(cacheBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open cache: %v", err)
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%#v", settings)))
+	return &detectionCache{db: db, fingerprint: hex.EncodeToString(sum[:8])}, nil
+}
+
+// inputFiles lists the files whose content decides what cfg detects: the
+// cascades and models it loads, those of a --verify detector, and each
+// plugin's executable along with any file named in its arguments, such as
+// a script. Their digests go into the cache fingerprint, so a model
+// retrained in place is not answered from stale results.
+func (cfg detectorConfig) inputFiles() ([]string, error) {
+	paths, err := cfg.modelFiles()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.verify != "" && cfg.verify != verifyEyes {
+		v, err := parseDetectorSpec(cfg.verify, cfg)
+		if err != nil {
+			return nil, err
+		}
+		v.verify, v.fallbacks = "", nil
+		more, err := v.inputFiles()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, more...)
+	}
+	more, err := cfg.pluginFiles()
+	if err != nil {
+		return nil, err
+	}
+	return append(paths, more...), nil
+}
+
+func (cfg detectorConfig) pluginFiles() ([]string, error) {
+	var paths []string
+	for _, command := range cfg.plugins {
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			continue
+		}
+		exe, err := exec.LookPath(args[0])
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, exe)
+		for _, arg := range args[1:] {
+			if info, err := os.Stat(arg); err == nil && info.Mode().IsRegular() {
+				paths = append(paths, arg)
+			}
+		}
+	}
+	for _, fb := range cfg.fallbacks {
+		more, err := fb.pluginFiles()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, more...)
+	}
+	return paths, nil
+}
+
+// fileDigests returns the SHA-256 of each file, in order.
+func fileDigests(paths []string) ([]string, error) {
+	digests := make([]string, len(paths))
+	for i, p := range paths {
+		digest, err := fileSHA256(p)
+		if err != nil {
+			return nil, err
+		}
+		digests[i] = digest
+	}
+	return digests, nil
+}
+
+func (c *detectionCache) key(path string) ([]byte, error) {
+	digest, err := fileSHA256(path)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(digest + ":" + c.fingerprint), nil
+}
+
+func (c *detectionCache) get(key []byte) (sceneDetections, bool) {
+	var data []byte
+	c.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(cacheBucket).Get(key); v != nil {
+			data = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	var cached cachedDetections
+	if data == nil || json.Unmarshal(data, &cached) != nil {
+		return sceneDetections{}, false
+	}
+	return sceneDetections{faces: cached.Faces, plates: cached.Plates, people: cached.People}, true
+}
+
+func (c *detectionCache) put(key []byte, found sceneDetections) error {
+	data, err := json.Marshal(cachedDetections{Faces: found.faces, Plates: found.plates, People: found.people})
+	if err != nil {
+		return err
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(cacheBucket).Put(key, data)
+	})
+}
+
+func (c *detectionCache) Close() error {
+	return c.db.Close()
+}
+
+// detectCached is detectAll behind the cache, when one is configured.
+// Images with settings overridden per directory bypass it, as the cache
+// key only covers the global settings.
+func (d *detectors) detectCached(path string, img, resized gocv.Mat, opts *options, batched []Detection) (sceneDetections, error) {
+	if d.cache == nil || len(opts.dirSettings) > 0 {
+		return d.detectAll(img, resized, opts, batched)
+	}
+	key, err := d.cache.key(path)
+	if err != nil {
+		return d.detectAll(img, resized, opts, batched)
+	}
+	if found, ok := d.cache.get(key); ok {
+		return found, nil
+	}
+	found, err := d.detectAll(img, resized, opts, batched)
+	if err != nil {
+		return found, err
+	}
+	if err := d.cache.put(key, found); err != nil {
+		fmt.Printf("Error caching detections for %s: %v\n", path, err)
+	}
+	return found, nil
+}
@@ -0,0 +1,225 @@
+//go:build !purego
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// openCamera opens a local device by index ("0") or a stream URL such as
+// rtsp://host/path.
+func openCamera(source string) (*gocv.VideoCapture, error) {
+	if index, err := strconv.Atoi(source); err == nil {
+		return gocv.VideoCaptureDevice(index)
+	}
+	return gocv.VideoCaptureFile(source)
+}
+
+// runCamera detects and tracks faces in a live feed until ctx is cancelled,
+// saving a crop of each newly appearing face to the output directory and
+// logging each face's entry and exit to dwell.jsonl there.
+// With --stream-addr the annotated frames are served as MJPEG at
+// /stream.mjpg, detection events are pushed over WebSocket at /events, and
+// rolling face counts are served as JSON at /counts and for Prometheus at
+// /metrics.
+//
+// With --private nothing but redacted frames and counts leaves the process:
+// faces are detected and redacted in every published frame, and snapshots,
+// the event stream, the dwell log and MQTT track ids are off.
+//
+// With --retention, this camera's snapshots older than the policy are
+// deleted while it runs.
+func runCamera(ctx context.Context, opts *options, det *detectors) error {
+	capture, err := openCamera(opts.camera)
+	if err != nil {
+		return fmt.Errorf("failed to open camera %s: %v", opts.camera, err)
+	}
+	defer capture.Close()
+
+	var stream *mjpegStream
+	var events *eventStream
+	var counts *occupancy
+	if opts.streamAddr != "" {
+		stream = newMJPEGStream()
+		counts = newOccupancy(opts.cameraName, opts.countWindows)
+		mux := http.NewServeMux()
+		mux.Handle("/stream.mjpg", stream)
+		if !opts.private {
+			events = newEventStream(opts.allowedOrigins)
+			mux.Handle("/events", events)
+		}
+		mux.HandleFunc("GET /counts", counts.handleCounts)
+		mux.HandleFunc("GET /metrics", counts.handleMetrics)
+		srv := &http.Server{Addr: opts.streamAddr, Handler: mux}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fmt.Printf("Stream server failed: %v\n", err)
+			}
+		}()
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(shutdownCtx)
+		}()
+		if opts.private {
+			fmt.Printf("Streaming redacted frames at http://%s/stream.mjpg\n", opts.streamAddr)
+		} else {
+			fmt.Printf("Streaming annotated frames at http://%s/stream.mjpg and events at ws://%s/events\n", opts.streamAddr, opts.streamAddr)
+		}
+	}
+
+	var publisher *mqttPublisher
+	if opts.mqttBroker != "" {
+		publisher, err = newMQTTPublisher(opts.mqttBroker, opts.mqttTopic, opts.cameraName, opts.mqttDiscovery)
+		if err != nil {
+			return err
+		}
+		defer publisher.Close()
+		publisher.countOnly = opts.private
+	}
+
+	notifiers, err := newNotifiers(opts.slackChannel, opts.telegramChat)
+	if err != nil {
+		return err
+	}
+	var alerts *alerter
+	if len(notifiers) > 0 {
+		alerts = &alerter{notifiers: notifiers, interval: opts.notifyInterval}
+		defer alerts.wait()
+	}
+
+	frame := gocv.NewMat()
+	defer frame.Close()
+	resized := gocv.NewMat()
+	defer resized.Close()
+
+	// In private mode every frame is redacted from a detection of that very
+	// frame: tracking in between, or skipping detection while nothing moves,
+	// would leave a face that just came into view uncovered.
+	detectEvery := opts.detectEvery
+	if opts.private {
+		detectEvery = 1
+	}
+	tracker := &videoTracker{det: det, opts: opts, detectEvery: detectEvery, sceneCut: opts.sceneCut, hist: gocv.NewMat()}
+	defer tracker.Close()
+
+	var gate *motionGate
+	if opts.motionThreshold > 0 && !opts.private {
+		gate = newMotionGate(opts.motionThreshold)
+		defer gate.Close()
+	}
+
+	snapshots := newSnapshotPolicy(opts.cooldown)
+	if opts.retention > 0 {
+		go enforceRetention(ctx, opts.outputDir, opts.cameraName, opts.retention)
+	}
+
+	var dwell *dwellLog
+	if !opts.private {
+		if dwell, err = openDwellLog(opts.outputDir, opts.cameraName, opts.fileMode); err != nil {
+			return err
+		}
+		defer func() {
+			if err := dwell.Close(); err != nil {
+				fmt.Printf("Error writing dwell log: %v\n", err)
+			}
+		}()
+	}
+
+	hadFaces := false
+	lastID := 0
+	for ctx.Err() == nil {
+		if !capture.Read(&frame) {
+			return fmt.Errorf("camera %s stopped delivering frames", opts.camera)
+		}
+		if frame.Empty() {
+			continue
+		}
+		resizeForDetection(frame, &resized, opts)
+
+		// Motion is checked on every frame to keep the reference current,
+		// but only gates detection while no face is being tracked.
+		var tracks []*track
+		if moved := gate == nil || gate.moved(resized); moved || len(tracker.tracks) > 0 {
+			if tracks, err = tracker.step(frame, resized); err != nil {
+				return fmt.Errorf("frame %d: %v", tracker.frame, err)
+			}
+		} else {
+			tracker.skip()
+		}
+
+		now := time.Now()
+		ids := make([]int, len(tracks))
+		for i, t := range tracks {
+			ids[i] = t.id
+		}
+		if counts != nil {
+			counts.observe(tracks, now)
+		}
+		if dwell != nil {
+			if err := dwell.update(ids, now); err != nil {
+				fmt.Printf("Error writing dwell log: %v\n", err)
+			}
+		}
+		if !opts.private {
+			for _, t := range snapshots.due(tracks, now) {
+				path, err := saveSnapshot(frame, resized, t, now, opts)
+				if err != nil {
+					fmt.Printf("Error saving snapshot: %v\n", err)
+					continue
+				}
+				fmt.Printf("Saved %s\n", path)
+			}
+		}
+
+		// Send every frame with faces, and one empty event when they leave.
+		if events != nil && (len(tracks) > 0 || hadFaces) {
+			sx := float64(frame.Cols()) / float64(resized.Cols())
+			sy := float64(frame.Rows()) / float64(resized.Rows())
+			event := detectionEvent{Source: opts.cameraName, Time: now, Frame: tracker.frame, Faces: []eventFace{}}
+			for _, t := range tracks {
+				event.Faces = append(event.Faces, newEventFace(t.id, scaleRect(t.rect, sx, sy)))
+			}
+			events.send(event)
+		}
+		hadFaces = len(tracks) > 0
+
+		if publisher != nil {
+			if err := publisher.update(opts.cameraName, ids); err != nil {
+				fmt.Printf("Error publishing to MQTT: %v\n", err)
+			}
+		}
+
+		// A new track is what triggers an alert.
+		newFace := tracker.nextID > lastID
+		lastID = tracker.nextID
+		alert := alerts != nil && newFace
+		if alert || (stream != nil && stream.watched()) {
+			if opts.private {
+				redactTracks(&resized, tracks, opts.anonymize)
+			} else {
+				drawTracks(&resized, tracks)
+			}
+			buf, err := gocv.IMEncode(gocv.JPEGFileExt, resized)
+			if err != nil {
+				return fmt.Errorf("error encoding frame: %v", err)
+			}
+			jpeg := append([]byte(nil), buf.GetBytes()...)
+			buf.Close()
+			if stream != nil {
+				stream.publish(jpeg)
+			}
+			if alert {
+				alerts.alert(fmt.Sprintf("Face detected at %s", opts.cameraName), jpeg)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+// Package client is a Go client for the face-detector HTTP API, as described
+// by the OpenAPI document the server publishes at /openapi.json. It is a
+// module of its own so programs can depend on it without the detector and
+// its OpenCV bindings.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Face is a detected face in the coordinates of the uploaded image.
+type Face struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// DetectResponse is the body of a successful POST /detect.
+type DetectResponse struct {
+	Faces []Face `json:"faces"`
+}
+
+// ReadyResponse is the body of GET /readyz.
+type ReadyResponse struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Job is the state of an asynchronous batch job.
+type Job struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Input    string `json:"input"`
+	Output   string `json:"output"`
+	Progress struct {
+		Input string `json:"input"`
+		Faces int    `json:"faces"`
+		Error string `json:"error,omitempty"`
+		Done  int    `json:"done"`
+		Total int    `json:"total"`
+	} `json:"progress"`
+	Summary *struct {
+		Total     int `json:"total"`
+		Processed int `json:"processed"`
+		Faces     int `json:"faces"`
+		Failed    int `json:"failed"`
+	} `json:"summary,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Outputs []string `json:"outputs,omitempty"`
+}
+
+// Error is returned for non-2xx responses.
+type Error struct {
+	StatusCode int
+	Message    string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("face-detector: %d: %s", e.StatusCode, e.Message)
+}
+
+// Client talks to one server. HTTPClient defaults to http.DefaultClient;
+// set it to configure timeouts or TLS client certificates.
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+// New returns a client for the server at baseURL, e.g. http://localhost:8080.
+func New(baseURL, apiKey string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), APIKey: apiKey}
+}
+
+// Detect uploads an encoded image and returns the faces found in it.
+func (c *Client) Detect(ctx context.Context, image io.Reader) ([]Face, error) {
+	var resp DetectResponse
+	if err := c.send(ctx, http.MethodPost, "/detect", "application/octet-stream", image, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Faces, nil
+}
+
+// SubmitDir starts a job over a directory relative to the server's
+// --input-dir. Progress is streamed at /jobs/{id}/events.
+func (c *Client) SubmitDir(ctx context.Context, dir string) (*Job, error) {
+	body, err := json.Marshal(map[string]string{"dir": dir})
+	if err != nil {
+		return nil, err
+	}
+	var j Job
+	if err := c.send(ctx, http.MethodPost, "/jobs", "application/json", bytes.NewReader(body), &j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// SubmitZip starts a job over the images in a zip archive.
+func (c *Client) SubmitZip(ctx context.Context, archive io.Reader) (*Job, error) {
+	var j Job
+	if err := c.send(ctx, http.MethodPost, "/jobs", "application/zip", archive, &j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// Job fetches a job's state. Outputs lists its files once it has finished.
+func (c *Client) Job(ctx context.Context, id string) (*Job, error) {
+	var j Job
+	if err := c.send(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), "", nil, &j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// Output downloads one of the files listed in a finished job's Outputs. The
+// caller closes the returned reader.
+func (c *Client) Output(ctx context.Context, id, name string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id)+"/outputs/"+url.PathEscape(name), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Event is one server-sent event of a job: "status" on connect, "progress"
+// per image and a final "summary".
+type Event struct {
+	Type string
+	Job  Job
+}
+
+// Events streams a job's progress, calling fn for each event. It returns
+// nil after the summary event, or the first error from fn, the connection
+// or ctx.
+func (c *Client) Events(ctx context.Context, id string, fn func(Event) error) error {
+	resp, err := c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id)+"/events", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(nil, 16<<20)
+	var e Event
+	var data []byte
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			e.Type = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = append(data, strings.TrimPrefix(line, "data: ")...)
+		case line == "" && data != nil:
+			if err := json.Unmarshal(data, &e.Job); err != nil {
+				return err
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+			if e.Type == "summary" {
+				return nil
+			}
+			e, data = Event{}, nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// Ready reports the server's readiness checks. A server that is up but not
+// ready returns the checks without an error.
+func (c *Client) Ready(ctx context.Context) (*ReadyResponse, error) {
+	var resp ReadyResponse
+	if err := c.send(ctx, http.MethodGet, "/readyz", "", nil, &resp, http.StatusServiceUnavailable); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// send makes a request and decodes a JSON response into out. Statuses other
+// than 2xx and those listed in accept become an *Error.
+func (c *Client) send(ctx context.Context, method, path, contentType string, body io.Reader, out interface{}, accept ...int) error {
+	resp, err := c.do(ctx, method, path, contentType, body, accept...)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// do makes a request and returns the response for the caller to read and
+// close. Statuses other than 2xx and those listed in accept become an
+// *Error.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body io.Reader, accept ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 && !slices.Contains(accept, resp.StatusCode) {
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		e := &Error{StatusCode: resp.StatusCode}
+		if json.Unmarshal(data, e) != nil || e.Message == "" {
+			e.Message = strings.TrimSpace(string(data))
+		}
+		return nil, e
+	}
+	return resp, nil
+}
@@ -0,0 +1,3 @@
+module github.com/Zavr22/face-detector/client
+
+go 1.23.0
@@ -0,0 +1,373 @@
+//go:build !purego
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The coordinator splits the input listing into tasks that workers lease
+// over HTTP. A task whose lease runs out, because its worker died or hung,
+// is handed to the next worker that asks; workers renew their lease while
+// they process a task so a slow task is not handed out twice. Inputs are
+// passed by path, so workers need the input directory mounted at the same
+// location. Output names are planned once over the whole listing and sent
+// with each task, so tasks never write to the same files.
+
+type clusterTask struct {
+	ID     int           `json:"id"`
+	Inputs []string      `json:"inputs"`
+	Names  []clusterName `json:"names"`
+
+	worker   string
+	deadline time.Time
+	done     bool
+}
+
+// clusterName is the output names of one task input, relative to the
+// worker's output directory.
+type clusterName struct {
+	Annotated string `json:"annotated"`
+	Stem      string `json:"stem"`
+}
+
+type leaseRequest struct {
+	Worker string `json:"worker"`
+}
+
+type leaseResponse struct {
+	Task     *clusterTask `json:"task,omitempty"`
+	Finished bool         `json:"finished"`
+	// LeaseMillis is how long the lease lasts unless renewed.
+	LeaseMillis int64 `json:"lease_ms,omitempty"`
+}
+
+type renewRequest struct {
+	Worker string `json:"worker"`
+	Task   int    `json:"task"`
+}
+
+type clusterFailure struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+type completeRequest struct {
+	Worker   string           `json:"worker"`
+	Task     int              `json:"task"`
+	Summary  batchSummary     `json:"summary"`
+	Failures []clusterFailure `json:"failures"`
+}
+
+type coordinator struct {
+	leaseTimeout time.Duration
+
+	mu        sync.Mutex
+	tasks     []*clusterTask
+	remaining int
+	summary   batchSummary
+	failures  []failure
+	finished  chan struct{}
+}
+
+func newCoordinator(inputs []string, taskSize int, leaseTimeout time.Duration) *coordinator {
+	c := &coordinator{leaseTimeout: leaseTimeout, finished: make(chan struct{})}
+	names := planOutputNames(inputs, "")
+	for start := 0; start < len(inputs); start += taskSize {
+		t := &clusterTask{ID: len(c.tasks), Inputs: inputs[start:min(start+taskSize, len(inputs))]}
+		for _, in := range t.Inputs {
+			t.Names = append(t.Names, clusterName{Annotated: names[in].annotated, Stem: names[in].stem})
+		}
+		c.tasks = append(c.tasks, t)
+	}
+	c.remaining = len(c.tasks)
+	c.summary.Total = len(inputs)
+	if c.remaining == 0 {
+		close(c.finished)
+	}
+	return c
+}
+
+func (c *coordinator) lease(worker string) leaseResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.remaining == 0 {
+		return leaseResponse{Finished: true}
+	}
+	now := time.Now()
+	for _, t := range c.tasks {
+		if t.done || (t.worker != "" && now.Before(t.deadline)) {
+			continue
+		}
+		if t.worker != "" {
+			fmt.Printf("Lease on task %d expired for worker %s, reassigning\n", t.ID, t.worker)
+		}
+		t.worker = worker
+		t.deadline = now.Add(c.leaseTimeout)
+		return leaseResponse{Task: t, LeaseMillis: c.leaseTimeout.Milliseconds()}
+	}
+	return leaseResponse{}
+}
+
+// renew extends the lease of worker on a task, reporting false when the
+// worker no longer holds it.
+func (c *coordinator) renew(req renewRequest) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if req.Task < 0 || req.Task >= len(c.tasks) {
+		return false
+	}
+	t := c.tasks[req.Task]
+	if t.done || t.worker != req.Worker {
+		return false
+	}
+	t.deadline = time.Now().Add(c.leaseTimeout)
+	return true
+}
+
+// complete records a finished task. A late report for a task that was
+// reassigned and finished elsewhere is ignored, so images are counted once.
+func (c *coordinator) complete(req completeRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if req.Task < 0 || req.Task >= len(c.tasks) || c.tasks[req.Task].done {
+		return
+	}
+	c.tasks[req.Task].done = true
+	c.summary.Processed += req.Summary.Processed
+	c.summary.Faces += req.Summary.Faces
+	c.summary.Failed += len(req.Failures)
+	for _, f := range req.Failures {
+		c.failures = append(c.failures, failure{path: f.Path, reason: f.Reason})
+	}
+	c.remaining--
+	fmt.Printf("Task %d done by %s, %d of %d tasks left\n", req.Task, req.Worker, c.remaining, len(c.tasks))
+	if c.remaining == 0 {
+		close(c.finished)
+	}
+}
+
+func (c *coordinator) routes(keys []string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("POST /lease", requireAPIKey(keys, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req leaseRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Worker == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"expected {\"worker\": ...}"})
+			return
+		}
+		writeJSON(w, http.StatusOK, c.lease(req.Worker))
+	})))
+	mux.Handle("POST /renew", requireAPIKey(keys, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req renewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Worker == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"expected {\"worker\": ..., \"task\": ...}"})
+			return
+		}
+		if !c.renew(req) {
+			writeJSON(w, http.StatusConflict, errorResponse{"lease lost"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	})))
+	mux.Handle("POST /complete", requireAPIKey(keys, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req completeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+		c.complete(req)
+		writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	})))
+	return mux
+}
+
+// runCoordinator hands the inputs out to workers on --coordinate and
+// writes the combined failure report and summary once every task is done.
+func runCoordinator(ctx context.Context, opts *options, det *detectors) error {
+	inputs, err := listInputs(opts)
+	if err != nil {
+		return err
+	}
+	keys, err := loadAPIKeys(opts.apiKeysFile)
+	if err != nil {
+		return err
+	}
+
+	c := newCoordinator(inputs, opts.taskSize, opts.leaseTimeout)
+	srv := &http.Server{Addr: opts.coordinateAddr, Handler: c.routes(keys)}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	fmt.Printf("Coordinating %d images in %d tasks on %s\n", len(inputs), len(c.tasks), opts.coordinateAddr)
+
+	var runErr error
+	select {
+	case err := <-errc:
+		return fmt.Errorf("coordinator failed: %v", err)
+	case <-ctx.Done():
+		runErr = errInterrupted
+	case <-c.finished:
+		// Let workers polling for work learn that the run is over.
+		time.Sleep(2 * workerPoll)
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := writeFailures(opts.failuresFile, c.failures, opts.fileMode); err != nil {
+		fmt.Printf("Error writing failure report: %v\n", err)
+	}
+	fmt.Printf("Processed %d of %d images: %d faces found, %d failed\n", c.summary.Processed, c.summary.Total, c.summary.Faces, c.summary.Failed)
+	if runErr != nil {
+		return runErr
+	}
+	if len(c.failures) > 0 {
+		return fmt.Errorf("%d of %d images failed, see %s", len(c.failures), c.summary.Processed, opts.failuresFile)
+	}
+	return nil
+}
+
+const workerPoll = 2 * time.Second
+
+// runWorker leases tasks from the coordinator at --worker-of and processes
+// them until the coordinator reports that everything is done. The API key,
+// if the coordinator needs one, comes from FACE_DETECTOR_API_KEY.
+func runWorker(ctx context.Context, opts *options, det *detectors) error {
+	host, _ := os.Hostname()
+	worker := fmt.Sprintf("%s-%d", host, os.Getpid())
+	base := strings.TrimSuffix(opts.workerOf, "/")
+	key := os.Getenv("FACE_DETECTOR_API_KEY")
+
+	for ctx.Err() == nil {
+		var lease leaseResponse
+		if err := postJSON(ctx, base+"/lease", key, leaseRequest{Worker: worker}, &lease); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			fmt.Printf("Error leasing work: %v\n", err)
+			sleepCtx(ctx, workerPoll)
+			continue
+		}
+		if lease.Finished {
+			return nil
+		}
+		if lease.Task == nil {
+			sleepCtx(ctx, workerPoll)
+			continue
+		}
+
+		names := make(map[string]outputNames, len(lease.Task.Inputs))
+		for i, in := range lease.Task.Inputs {
+			if i < len(lease.Task.Names) {
+				n := lease.Task.Names[i]
+				names[in] = outputNames{annotated: filepath.Join(opts.outputDir, n.Annotated), stem: n.Stem}
+			}
+		}
+		taskCtx, cancel := context.WithCancel(ctx)
+		renewed := make(chan struct{})
+		go func() {
+			defer close(renewed)
+			renewLease(taskCtx, cancel, base, key, renewRequest{Worker: worker, Task: lease.Task.ID}, time.Duration(lease.LeaseMillis)*time.Millisecond)
+		}()
+		summary, failures, err := runInputs(taskCtx, opts, det, lease.Task.Inputs, names, nil)
+		lost := taskCtx.Err() != nil && ctx.Err() == nil
+		cancel()
+		<-renewed
+		if lost {
+			fmt.Printf("Lost the lease on task %d, dropping it\n", lease.Task.ID)
+			continue
+		}
+		if errors.Is(err, errInterrupted) {
+			// The lease runs out and another worker picks the task up.
+			break
+		}
+		req := completeRequest{Worker: worker, Task: lease.Task.ID, Summary: summary, Failures: []clusterFailure{}}
+		for _, f := range failures {
+			req.Failures = append(req.Failures, clusterFailure{Path: f.path, Reason: f.reason})
+		}
+		if err := postJSON(ctx, base+"/complete", key, req, nil); err != nil {
+			fmt.Printf("Error reporting task %d: %v\n", lease.Task.ID, err)
+		}
+	}
+	return errInterrupted
+}
+
+// renewLease renews a task lease every third of its length until ctx is
+// done, calling cancel if the coordinator says the lease was lost. Failed
+// requests are retried on the next tick; the lease only runs out if they
+// keep failing.
+func renewLease(ctx context.Context, cancel context.CancelFunc, base, key string, req renewRequest, lease time.Duration) {
+	if lease <= 0 {
+		return
+	}
+	ticker := time.NewTicker(max(lease/3, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := postJSON(ctx, base+"/renew", key, req, nil)
+		if errors.Is(err, errLeaseLost) {
+			cancel()
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("Error renewing lease on task %d: %v\n", req.Task, err)
+		}
+	}
+}
+
+// errLeaseLost is returned by postJSON when the coordinator answers 409.
+var errLeaseLost = errors.New("lease lost")
+
+func postJSON(ctx context.Context, url, key string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		return errLeaseLost
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
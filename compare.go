@@ -0,0 +1,243 @@
+//go:build !purego
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"path/filepath"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// compareIoU is the overlap at which a detection of one side counts as the
+// same face as a detection of the other.
+const compareIoU = 0.5
+
+// parseDetectorSpec turns a --backend-a/--backend-b value or an entry of
+// --backend-chain into a detector configuration based on base: "haar" (the
+// default cascade), "haar:FILE", "dnn:MODEL" (opencv backend), "onnx:MODEL"
+// or "plugin:COMMAND".
+func parseDetectorSpec(spec string, base detectorConfig) (detectorConfig, error) {
+	cfg := base
+	cfg.backend = backendOpenCV
+	cfg.cascades, cfg.models, cfg.plugins = nil, nil, nil
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "haar", "cascade":
+		if arg == "" {
+			arg = defaultCascade
+		}
+		cfg.cascades = []string{arg}
+	case "dnn", backendONNX:
+		if arg == "" {
+			return cfg, fmt.Errorf("detector %q needs a model, e.g. %s:model.onnx", spec, kind)
+		}
+		if kind == backendONNX {
+			cfg.backend = backendONNX
+		}
+		cfg.models = []string{arg}
+	case "plugin":
+		if arg == "" {
+			return cfg, fmt.Errorf("detector %q needs a command", spec)
+		}
+		cfg.plugins = []string{arg}
+	default:
+		return cfg, fmt.Errorf("unknown detector %q (want haar, dnn:MODEL, onnx:MODEL or plugin:COMMAND)", spec)
+	}
+	return cfg, nil
+}
+
+// parseBackendChain turns a --backend-chain value, detector specs separated
+// by commas such as "dnn:res10,haar", into a configuration using the first
+// with the others as its fallbacks.
+func parseBackendChain(chain string, base detectorConfig) (detectorConfig, error) {
+	var cfg detectorConfig
+	for i, spec := range strings.Split(chain, ",") {
+		c, err := parseDetectorSpec(strings.TrimSpace(spec), base)
+		if err != nil {
+			return cfg, err
+		}
+		if i == 0 {
+			cfg = c
+		} else {
+			cfg.fallbacks = append(cfg.fallbacks, c)
+		}
+	}
+	return cfg, nil
+}
+
+// imageComparison is how two detectors differ on one image. Boxes are in the
+// image's pixel coordinates; MeanIoU averages over the matched pairs.
+type imageComparison struct {
+	Input   string  `json:"input"`
+	FacesA  int     `json:"faces_a"`
+	FacesB  int     `json:"faces_b"`
+	Matched int     `json:"matched"`
+	MeanIoU float64 `json:"mean_iou,omitempty"`
+	OnlyA   []box   `json:"only_a,omitempty"`
+	OnlyB   []box   `json:"only_b,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// matchDetections pairs faces of a and b greedily by best overlap and
+// returns, for each face of a, the index of its match in b or -1.
+func matchDetections(a, b []image.Rectangle) []int {
+	match := make([]int, len(a))
+	used := make([]bool, len(b))
+	for i, fa := range a {
+		match[i] = -1
+		best := compareIoU
+		for j, fb := range b {
+			if overlap := iou(fa, fb); !used[j] && overlap >= best {
+				match[i], best = j, overlap
+			}
+		}
+		if match[i] >= 0 {
+			used[match[i]] = true
+		}
+	}
+	return match
+}
+
+// runCompare runs two detector configurations over the inputs and writes a
+// per-image report of where they disagree to compare.json in the output
+// directory, plus side-by-side images with --compare-images.
+func runCompare(ctx context.Context, opts *options, cfgA, cfgB detectorConfig) error {
+	detA, err := loadDetectors(cfgA)
+	if err != nil {
+		return fmt.Errorf("failed to load detector A: %v", err)
+	}
+	defer detA.Close()
+	detB, err := loadDetectors(cfgB)
+	if err != nil {
+		return fmt.Errorf("failed to load detector B: %v", err)
+	}
+	defer detB.Close()
+
+	all, err := listAllInputs(opts)
+	if err != nil {
+		return err
+	}
+	names := planOutputNames(all, opts.outputDir)
+	inputs := shardInputs(all, opts.inputDir, opts.shard)
+
+	var report []imageComparison
+	var matched, onlyA, onlyB int
+	for _, inputPath := range inputs {
+		if ctx.Err() != nil {
+			return errInterrupted
+		}
+		if isVideo(inputPath) {
+			continue
+		}
+		c, err := compareImage(inputPath, names[inputPath], opts, detA, detB)
+		if err != nil {
+			c.Error = err.Error()
+			fmt.Printf("Error comparing %s: %v\n", inputPath, err)
+		} else {
+			fmt.Printf("%s: A %d, B %d, matched %d, only A %d, only B %d\n", inputPath, c.FacesA, c.FacesB, c.Matched, len(c.OnlyA), len(c.OnlyB))
+		}
+		matched += c.Matched
+		onlyA += len(c.OnlyA)
+		onlyB += len(c.OnlyB)
+		report = append(report, c)
+	}
+	fmt.Printf("Compared %d images: %d faces matched, %d only found by A, %d only found by B\n", len(report), matched, onlyA, onlyB)
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(opts.outputDir, "compare.json"), append(data, '\n'), opts.fileMode)
+}
+
+func compareImage(imagePath string, names outputNames, opts *options, detA, detB *detectors) (imageComparison, error) {
+	c := imageComparison{Input: imagePath}
+	in, err := loadImage(imagePath, opts)
+	if err != nil {
+		return c, err
+	}
+	defer in.Close()
+
+	foundA, err := detA.detectAll(in.img, in.resized, opts, nil)
+	if err != nil {
+		return c, fmt.Errorf("detector A: %v", err)
+	}
+	foundB, err := detB.detectAll(in.img, in.resized, opts, nil)
+	if err != nil {
+		return c, fmt.Errorf("detector B: %v", err)
+	}
+	a, b := foundA.faces, foundB.faces
+	c.FacesA, c.FacesB = len(a), len(b)
+
+	sx := float64(in.img.Cols()) / float64(in.resized.Cols())
+	sy := float64(in.img.Rows()) / float64(in.resized.Rows())
+	match := matchDetections(a, b)
+	matchedB := make([]bool, len(b))
+	total := 0.0
+	for i, j := range match {
+		if j < 0 {
+			c.OnlyA = append(c.OnlyA, boxOf(scaleRect(a[i], sx, sy)))
+			continue
+		}
+		matchedB[j] = true
+		c.Matched++
+		total += iou(a[i], b[j])
+	}
+	for j, ok := range matchedB {
+		if !ok {
+			c.OnlyB = append(c.OnlyB, boxOf(scaleRect(b[j], sx, sy)))
+		}
+	}
+	if c.Matched > 0 {
+		c.MeanIoU = total / float64(c.Matched)
+	}
+
+	if opts.compareImages {
+		path := filepath.Join(opts.outputDir, names.stem+"_compare.webp")
+		if err := saveComparison(in.resized, a, b, match, matchedB, path, opts); err != nil {
+			return c, fmt.Errorf("error saving comparison image: %v", err)
+		}
+	}
+	return c, nil
+}
+
+// saveComparison writes the detection image twice side by side, A's faces
+// on the left and B's on the right: green where both agree, red where only
+// that side found a face.
+func saveComparison(img gocv.Mat, a, b []image.Rectangle, match []int, matchedB []bool, path string, opts *options) error {
+	left := img.Clone()
+	defer left.Close()
+	right := img.Clone()
+	defer right.Close()
+
+	agree := color.RGBA{0, 200, 0, 0}
+	differ := color.RGBA{255, 0, 0, 0}
+	for i, face := range a {
+		c := agree
+		if match[i] < 0 {
+			c = differ
+		}
+		gocv.Rectangle(&left, face, c, 3)
+	}
+	for j, face := range b {
+		c := agree
+		if !matchedB[j] {
+			c = differ
+		}
+		gocv.Rectangle(&right, face, c, 3)
+	}
+	gocv.PutText(&left, "A", image.Pt(10, 30), gocv.FontHersheySimplex, 1, differ, 2)
+	gocv.PutText(&right, "B", image.Pt(10, 30), gocv.FontHersheySimplex, 1, differ, 2)
+
+	both := gocv.NewMat()
+	defer both.Close()
+	gocv.Hconcat(left, right, &both)
+	_, err := saveCrop(both, path, opts)
+	return err
+}
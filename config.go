@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// readConfig parses a config file of "name = value" lines, one flag per
+// line, with blank lines and lines starting with # ignored.
+func readConfig(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want name = value", path, n)
+		}
+		values[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return values, scanner.Err()
+}
+
+// applyConfig sets the flags named in the config file at path, except those
+// already given on the command line, which take precedence.
+func applyConfig(fs *flag.FlagSet, path string) error {
+	values, err := readConfig(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	for name, value := range values {
+		if explicit[name] {
+			continue
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s: invalid %s: %v", path, name, err)
+		}
+	}
+	return nil
+}
+
+// updateConfig rewrites the config file at path with values replacing the
+// settings of the same name, keeping everything else, comments included.
+// The file is created if it does not exist.
+func updateConfig(path string, values map[string]string, mode os.FileMode) error {
+	var lines []string
+	if data, err := os.ReadFile(path); err == nil {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	done := map[string]bool{}
+	for i, line := range lines {
+		name, _, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if value, set := values[name]; ok && set && !strings.HasPrefix(name, "#") {
+			lines[i] = name + " = " + value
+			done[name] = true
+		}
+	}
+	var added []string
+	for name := range values {
+		if !done[name] {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	for _, name := range added {
+		lines = append(lines, name+" = "+values[name])
+	}
+	return writeFileAtomic(path, []byte(strings.Join(lines, "\n")+"\n"), mode)
+}
@@ -0,0 +1,89 @@
+//go:build !purego
+
+package main
+
+import (
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// prepareCrop applies the optional crop post-processing. The caller owns the
+// returned Mat.
+func prepareCrop(crop gocv.Mat, opts *options) gocv.Mat {
+	out := crop.Clone()
+
+	var fgMask gocv.Mat
+	if opts.cropTransparent {
+		fgMask = foregroundMask(crop)
+		defer fgMask.Close()
+	}
+
+	if opts.cropGray {
+		gocv.CvtColor(out, &out, gocv.ColorBGRToGray)
+	}
+	if opts.cropNormalize {
+		normalizeCrop(&out, opts.cropGray)
+	}
+
+	if opts.cropTransparent {
+		code := gocv.ColorBGRToBGRA
+		if opts.cropGray {
+			code = gocv.ColorGrayToBGRA
+		}
+		gocv.CvtColor(out, &out, code)
+		transparent := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), out.Rows(), out.Cols(), gocv.MatTypeCV8UC4)
+		out.CopyToWithMask(&transparent, fgMask)
+		out.Close()
+		out = transparent
+	}
+	return out
+}
+
+// foregroundMask segments the head and shoulders in a face crop with
+// GrabCut, seeded with a rectangle just inside the crop borders.
+func foregroundMask(crop gocv.Mat) gocv.Mat {
+	mask := gocv.NewMat()
+	defer mask.Close()
+	bgdModel := gocv.NewMat()
+	defer bgdModel.Close()
+	fgdModel := gocv.NewMat()
+	defer fgdModel.Close()
+
+	marginX, marginY := max(1, crop.Cols()/20), max(1, crop.Rows()/20)
+	seed := image.Rect(marginX, marginY, crop.Cols()-marginX, crop.Rows()-marginY)
+	gocv.GrabCut(crop, &mask, seed, &bgdModel, &fgdModel, 5, gocv.GCInitWithRect)
+
+	// Definite (1) and probable (3) foreground both count as subject.
+	sure := gocv.NewMat()
+	defer sure.Close()
+	gocv.InRangeWithScalar(mask, gocv.NewScalar(1, 0, 0, 0), gocv.NewScalar(1, 0, 0, 0), &sure)
+	probable := gocv.NewMat()
+	defer probable.Close()
+	gocv.InRangeWithScalar(mask, gocv.NewScalar(3, 0, 0, 0), gocv.NewScalar(3, 0, 0, 0), &probable)
+
+	fg := gocv.NewMat()
+	gocv.BitwiseOr(sure, probable, &fg)
+	return fg
+}
+
+func normalizeCrop(out *gocv.Mat, gray bool) {
+	if gray {
+		gocv.EqualizeHist(*out, out)
+		return
+	}
+
+	// Equalize luminance only so colours are not shifted.
+	ycrcb := gocv.NewMat()
+	defer ycrcb.Close()
+	gocv.CvtColor(*out, &ycrcb, gocv.ColorBGRToYCrCb)
+	channels := gocv.Split(ycrcb)
+	defer func() {
+		for _, c := range channels {
+			c.Close()
+		}
+	}()
+	gocv.EqualizeHist(channels[0], &channels[0])
+	gocv.Merge(channels, &ycrcb)
+	gocv.CvtColor(ycrcb, out, gocv.ColorYCrCbToBGR)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// datasetExport arranges face crops into train/ and val/ directories for
+// model training and lists them in manifest.jsonl. The split is decided per
+// source image from a hash of its name, so crops of one photo never end up
+// on both sides and reruns put every image in the same split.
+type datasetExport struct {
+	dir         string
+	valFraction float64
+	inputDir    string
+	mode        os.FileMode
+	entries     []datasetEntry
+
+	// augment, when set, writes augmented copies of an exported crop.
+	augment func(path string) ([]augmentedCrop, error)
+}
+
+type datasetEntry struct {
+	Path         string `json:"path"`
+	Split        string `json:"split"`
+	Source       string `json:"source"`
+	Box          *box   `json:"box,omitempty"`
+	Augmentation string `json:"augmentation,omitempty"`
+}
+
+type augmentedCrop struct {
+	path string
+	kind string
+}
+
+func newDatasetExport(dir string, valFraction float64, inputDir string, mode os.FileMode) (*datasetExport, error) {
+	for _, split := range []string{"train", "val"} {
+		if err := os.MkdirAll(filepath.Join(dir, split), os.ModePerm); err != nil {
+			return nil, fmt.Errorf("failed to create dataset directory: %v", err)
+		}
+	}
+	return &datasetExport{dir: dir, valFraction: valFraction, inputDir: inputDir, mode: mode}, nil
+}
+
+func (d *datasetExport) add(r imageResult) {
+	source := inputKey(r.Input, d.inputDir)
+	split := "train"
+	if splitFraction(source) < d.valFraction {
+		split = "val"
+	}
+
+	for i, crop := range r.Crops {
+		rel := filepath.Join(split, filepath.Base(crop))
+		dst := freePath(filepath.Join(d.dir, rel))
+		if err := linkOrCopy(crop, dst); err != nil {
+			fmt.Printf("Error exporting %s to dataset: %v\n", crop, err)
+			continue
+		}
+		rel, _ = filepath.Rel(d.dir, dst)
+		entry := datasetEntry{Path: filepath.ToSlash(rel), Split: split, Source: source}
+		// Video thumbnails have no per-image boxes.
+		if len(r.Boxes) == len(r.Crops) {
+			entry.Box = &r.Boxes[i]
+		}
+		d.entries = append(d.entries, entry)
+
+		if d.augment == nil {
+			continue
+		}
+		variants, err := d.augment(dst)
+		if err != nil {
+			fmt.Printf("Error augmenting %s: %v\n", dst, err)
+		}
+		for _, v := range variants {
+			rel, _ := filepath.Rel(d.dir, v.path)
+			augmented := entry
+			augmented.Path = filepath.ToSlash(rel)
+			augmented.Augmentation = v.kind
+			d.entries = append(d.entries, augmented)
+		}
+	}
+}
+
+// splitFraction maps key to a stable value in [0, 1).
+func splitFraction(key string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+// linkOrCopy hard-links src to dst, copying when they are on different
+// file systems or links are not supported.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
+
+func (d *datasetExport) write() error {
+	return writeAtomic(filepath.Join(d.dir, "manifest.jsonl"), d.mode, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for _, e := range d.entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func validateValFraction(f float64) error {
+	if f < 0 || f > 1 || math.IsNaN(f) {
+		return fmt.Errorf("invalid validation fraction %v (want 0-1)", f)
+	}
+	return nil
+}
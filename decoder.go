@@ -0,0 +1,26 @@
+//go:build !purego
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	decoderOpenCV = "opencv"
+	decoderVips   = "vips"
+)
+
+func validateDecoder(decoder string) error {
+	switch decoder {
+	case decoderOpenCV:
+		return nil
+	case decoderVips:
+		if !vipsAvailable {
+			return errors.New("--decoder vips needs a build with -tags vips")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown decoder %q (want opencv or vips)", decoder)
+}
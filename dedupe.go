@@ -0,0 +1,146 @@
+//go:build !purego
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"math/bits"
+	"path/filepath"
+
+	"gocv.io/x/gocv"
+	"golang.org/x/sync/errgroup"
+)
+
+// duplicate is an input left out because it repeats an earlier one.
+type duplicate struct {
+	Input       string `json:"input"`
+	DuplicateOf string `json:"duplicate_of"`
+	Reason      string `json:"reason"`
+	Distance    int    `json:"distance,omitempty"`
+}
+
+type inputHash struct {
+	digest string
+	dhash  uint64
+	ok     bool
+}
+
+// skipDuplicates drops inputs that are byte-identical to an earlier input or
+// whose difference hash (dHash) is within opts.duplicateDistance bits of
+// one, as edited or re-exported copies of a photo are. The first of each
+// group in input order is kept, and the skipped inputs are reported to
+// duplicates.json in the output directory. Inputs that cannot be hashed, and
+// videos, are always kept.
+func skipDuplicates(ctx context.Context, inputs []string, opts *options) ([]string, error) {
+	hashes := make([]inputHash, len(inputs))
+	var g errgroup.Group
+	g.SetLimit(opts.decodeWorkers)
+	for i, p := range inputs {
+		if isVideo(p) {
+			continue
+		}
+		g.Go(func() error {
+			if ctx.Err() != nil {
+				return errInterrupted
+			}
+			hashes[i] = hashInput(p, opts)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var kept []string
+	var keptHashes []inputHash
+	var skipped []duplicate
+	for i, p := range inputs {
+		h := hashes[i]
+		if d, ok := findDuplicate(h, kept, keptHashes, opts.duplicateDistance); ok {
+			d.Input = p
+			skipped = append(skipped, d)
+			fmt.Printf("Skipping %s: %s of %s\n", p, d.Reason, d.DuplicateOf)
+			continue
+		}
+		kept = append(kept, p)
+		keptHashes = append(keptHashes, h)
+	}
+
+	if len(skipped) > 0 {
+		data, err := json.MarshalIndent(skipped, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(opts.outputDir, "duplicates.json")
+		if err := writeFileAtomic(path, append(data, '\n'), opts.fileMode); err != nil {
+			return nil, fmt.Errorf("failed to write duplicate report: %v", err)
+		}
+		fmt.Printf("Skipped %d duplicate images, see %s\n", len(skipped), path)
+	}
+	return kept, nil
+}
+
+func findDuplicate(h inputHash, kept []string, hashes []inputHash, maxDistance int) (duplicate, bool) {
+	if !h.ok {
+		return duplicate{}, false
+	}
+	best := -1
+	bestDistance := maxDistance + 1
+	for i, k := range hashes {
+		if !k.ok {
+			continue
+		}
+		if k.digest == h.digest {
+			return duplicate{DuplicateOf: kept[i], Reason: "identical"}, true
+		}
+		if d := bits.OnesCount64(k.dhash ^ h.dhash); d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+	if best < 0 {
+		return duplicate{}, false
+	}
+	return duplicate{DuplicateOf: kept[best], Reason: "near-duplicate", Distance: bestDistance}, true
+}
+
+// hashInput computes the file digest and dHash of an image. Inputs the
+// guards reject or that fail to decode are left unhashed, for the normal
+// run to report.
+func hashInput(path string, opts *options) inputHash {
+	if _, err := checkInput(path, &opts.limits); err != nil {
+		return inputHash{}
+	}
+	digest, err := fileSHA256(path)
+	if err != nil {
+		return inputHash{}
+	}
+	img := gocv.IMRead(path, gocv.IMReadReducedGrayscale8)
+	defer img.Close()
+	if img.Empty() {
+		return inputHash{}
+	}
+	return inputHash{digest: digest, dhash: dHash(img), ok: true}
+}
+
+// dHash shrinks a grayscale image to 9x8 and sets a bit wherever a pixel is
+// darker than its right neighbour. Scaling, recompression and small colour
+// edits leave most bits alone.
+func dHash(gray gocv.Mat) uint64 {
+	small := gocv.NewMat()
+	defer small.Close()
+	gocv.Resize(gray, &small, image.Pt(9, 8), 0, 0, gocv.InterpolationArea)
+
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if small.GetUCharAt(y, x) < small.GetUCharAt(y, x+1) {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
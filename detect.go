@@ -0,0 +1,444 @@
+//go:build !purego
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Zavr22/face-detector/facedetect"
+	"gocv.io/x/gocv"
+)
+
+const (
+	defaultScaleFactor   = 1.1
+	defaultMinNeighbors  = 5
+	defaultMinConfidence = 0.5
+)
+
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// The built-in cascade and DNN backends live in the facedetect module, so
+// other programs can import them. FaceDetector is implemented by those as
+// well as by the ONNX backend and external plugins.
+type (
+	Detection       = facedetect.Detection
+	FaceDetector    = facedetect.FaceDetector
+	cascadeDetector = facedetect.Cascade
+	netDetector     = facedetect.Net
+)
+
+// batchDetector is a FaceDetector that can process several images in one
+// call, used with --batch-size.
+type batchDetector interface {
+	DetectBatch(imgs []gocv.Mat) ([][]Detection, error)
+}
+
+// detectors runs every configured FaceDetector and merges their results.
+// fallbacks are tried in order when these find no faces or fail. plates and
+// people, when set, find license plates for redaction and full bodies for
+// person-scoped crops.
+type detectors struct {
+	list      []FaceDetector
+	fallbacks []*detectors
+	verifier  faceVerifier
+	plates    FaceDetector
+	people    FaceDetector
+	cache     *detectionCache
+
+	// mu serializes use of the OpenCV objects, which are not safe for
+	// concurrent use; an image abandoned by --timeout-per-image may still be
+	// running.
+	mu sync.Mutex
+}
+
+// sceneDetections holds everything found in one image, in the coordinates
+// of the resized image.
+type sceneDetections struct {
+	faces  []image.Rectangle
+	plates []image.Rectangle
+	people []image.Rectangle
+}
+
+// detectAll runs face detection (tiled on the original when configured) and
+// the optional plate and person detectors. batched, when not nil, holds the
+// results of the batch detectors from an earlier detectBatch call.
+func (d *detectors) detectAll(img, resized gocv.Mat, opts *options, batched []Detection) (sceneDetections, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer d.applySettings(opts.detectorSettings)()
+
+	faces, err := d.detectRegion(img, resized, opts, batched)
+	for _, fb := range d.fallbacks {
+		if err == nil && len(faces) > 0 {
+			break
+		}
+		if err != nil {
+			fmt.Printf("Error detecting faces, trying the next backend: %v\n", err)
+		}
+		faces, err = fb.detectRegion(img, resized, opts, nil)
+	}
+	if err != nil {
+		return sceneDetections{}, fmt.Errorf("error detecting faces: %v", err)
+	}
+	return d.detectExtras(resized, opts, faces)
+}
+
+// detectRegion is detectRotated restricted to the --roi region, without the
+// faces in --ignore-rect and --ignore-mask zones. Faces are returned in the
+// coordinates of the whole resized image. d.mu must be held.
+func (d *detectors) detectRegion(img, resized gocv.Mat, opts *options, batched []Detection) ([]image.Rectangle, error) {
+	sx := float64(resized.Cols()) / float64(img.Cols())
+	sy := float64(resized.Rows()) / float64(img.Rows())
+	if !opts.roi.set {
+		faces, err := d.detectRotated(img, resized, opts, batched)
+		return opts.ignore.keep(faces, img.Cols(), img.Rows(), sx, sy), err
+	}
+	roi := opts.roi.rect(img.Cols(), img.Rows())
+	roiResized := scaleRect(roi, sx, sy).Intersect(image.Rect(0, 0, resized.Cols(), resized.Rows()))
+	if roiResized.Empty() {
+		return nil, nil
+	}
+	imgRegion := img.Region(roi)
+	defer imgRegion.Close()
+	resizedRegion := resized.Region(roiResized)
+	defer resizedRegion.Close()
+
+	faces, err := d.detectRotated(imgRegion, resizedRegion, opts, nil)
+	for i := range faces {
+		faces[i] = faces[i].Add(roiResized.Min)
+	}
+	return opts.ignore.keep(faces, img.Cols(), img.Rows(), sx, sy), err
+}
+
+// detectFaces runs d's own face detectors, tiled on the original when
+// configured. d.mu must be held.
+func (d *detectors) detectFaces(img, resized gocv.Mat, opts *options, batched []Detection) ([]image.Rectangle, error) {
+	if opts.tileSize <= 0 {
+		return d.detect(resized, batched)
+	}
+	faces, err := d.detectTiled(img, opts.tileSize, opts.tileOverlap)
+	sx := float64(resized.Cols()) / float64(img.Cols())
+	sy := float64(resized.Rows()) / float64(img.Rows())
+	for i := range faces {
+		faces[i] = scaleRect(faces[i], sx, sy)
+	}
+	return faces, err
+}
+
+// detectExtras runs the plate and person detectors for an image whose faces
+// are already known. d.mu must be held.
+func (d *detectors) detectExtras(resized gocv.Mat, opts *options, faces []image.Rectangle) (sceneDetections, error) {
+	found := sceneDetections{faces: faces}
+	var err error
+	if found.plates, err = d.detectPlates(resized); err != nil {
+		return found, fmt.Errorf("error detecting license plates: %v", err)
+	}
+
+	if opts.cropScope == cropScopePerson && len(found.faces) > 0 {
+		if found.people, err = d.detectPeople(resized); err != nil {
+			return found, fmt.Errorf("error detecting people: %v", err)
+		}
+	}
+	return found, nil
+}
+
+// detectTagged is detectCached that respects --existing-faces: faces already
+// tagged in the image's metadata replace detection (skip) or are added to
+// what it finds (merge).
+func (d *detectors) detectTagged(path string, img, resized gocv.Mat, opts *options, batched []Detection) (sceneDetections, error) {
+	if opts.existingFaces == "" {
+		return d.detectCached(path, img, resized, opts, batched)
+	}
+	regions, err := existingFaces(path)
+	if err != nil {
+		fmt.Printf("Error reading face tags of %s: %v\n", path, err)
+	}
+	bounds := image.Rect(0, 0, resized.Cols(), resized.Rows())
+	var tagged []image.Rectangle
+	for _, r := range regions {
+		if rect := r.rect(bounds.Dx(), bounds.Dy()).Intersect(bounds); !rect.Empty() {
+			tagged = append(tagged, rect)
+		}
+	}
+	if len(tagged) > 0 && opts.existingFaces == existingFacesSkip {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.detectExtras(resized, opts, tagged)
+	}
+
+	found, err := d.detectCached(path, img, resized, opts, batched)
+	if err != nil {
+		return found, err
+	}
+	// Tagged faces go first so that a curated box wins over a detection of
+	// the same face.
+	found.faces = mergeDetections(append(tagged, found.faces...))
+	return found, nil
+}
+
+// modelPath accepts either a path to an existing file or the name of a
+// model known to the cache in modelDir.
+func modelPath(modelDir, spec string) (string, error) {
+	if _, err := os.Stat(spec); err == nil {
+		return spec, nil
+	}
+	return resolveModel(modelDir, spec)
+}
+
+const (
+	backendOpenCV = "opencv"
+	backendONNX   = "onnx"
+)
+
+// detectorConfig selects the detectors loaded by loadDetectors.
+// scaleFactor and minNeighbors tune the cascades, minConfidence the models.
+// fallbacks configure the detectors tried in turn when these find nothing.
+type detectorConfig struct {
+	modelDir string
+	subject  string
+	backend  string
+	device   string
+	onnxLib  string
+	cascades []string
+	models   []string
+	plugins  []string
+
+	scaleFactor   float64
+	minNeighbors  int
+	minConfidence float64
+
+	// verify, when set, is the --verify check of cascade detections.
+	verify    string
+	fallbacks []detectorConfig
+}
+
+func loadDetectors(cfg detectorConfig) (*detectors, error) {
+	d := &detectors{}
+	cascadeSpecs := cfg.cascades
+	if len(cascadeSpecs) == 0 && len(cfg.models) == 0 && len(cfg.plugins) == 0 {
+		var ok bool
+		if cascadeSpecs, ok = subjectCascades[cfg.subject]; !ok {
+			return nil, fmt.Errorf("unknown subject %q", cfg.subject)
+		}
+	}
+
+	for _, spec := range cascadeSpecs {
+		path, err := modelPath(cfg.modelDir, spec)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		c, err := facedetect.NewCascade(path, cfg.scaleFactor, cfg.minNeighbors)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		d.list = append(d.list, c)
+	}
+	if cfg.verify != "" && len(cascadeSpecs) > 0 {
+		v, err := loadVerifier(cfg.verify, cfg)
+		if err != nil {
+			d.Close()
+			return nil, fmt.Errorf("failed to load --verify detector: %v", err)
+		}
+		d.verifier = v
+	}
+
+	for _, spec := range cfg.models {
+		path, err := modelPath(cfg.modelDir, spec)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		var fd FaceDetector
+		switch cfg.backend {
+		case backendOpenCV:
+			fd, err = facedetect.NewNet(path, cfg.device, cfg.minConfidence)
+		case backendONNX:
+			fd, err = newONNXDetector(path, cfg.onnxLib, cfg.minConfidence)
+		default:
+			err = fmt.Errorf("unknown backend %q (want opencv or onnx)", cfg.backend)
+		}
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		d.list = append(d.list, fd)
+	}
+
+	for _, cmd := range cfg.plugins {
+		p, err := startPlugin(cmd)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		d.list = append(d.list, p)
+	}
+
+	for _, fbCfg := range cfg.fallbacks {
+		fb, err := loadDetectors(fbCfg)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		d.fallbacks = append(d.fallbacks, fb)
+	}
+
+	return d, nil
+}
+
+// modelFiles lists the cascade and model files cfg loads, fallbacks
+// included.
+func (cfg detectorConfig) modelFiles() ([]string, error) {
+	specs := append(append([]string(nil), cfg.cascades...), cfg.models...)
+	if len(cfg.cascades) == 0 && len(cfg.models) == 0 && len(cfg.plugins) == 0 {
+		specs = subjectCascades[cfg.subject]
+	}
+	if cfg.verify == verifyEyes {
+		specs = append(specs, eyeCascade)
+	}
+	var paths []string
+	for _, spec := range specs {
+		path, err := modelPath(cfg.modelDir, spec)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	for _, fb := range cfg.fallbacks {
+		more, err := fb.modelFiles()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, more...)
+	}
+	return paths, nil
+}
+
+func (d *detectors) loadPlates(modelDir, spec string) error {
+	path, err := modelPath(modelDir, spec)
+	if err != nil {
+		return err
+	}
+	c, err := facedetect.NewCascade(path, defaultScaleFactor, defaultMinNeighbors)
+	if err != nil {
+		return err
+	}
+	d.plates = c
+	return nil
+}
+
+func (d *detectors) detectPlates(img gocv.Mat) ([]image.Rectangle, error) {
+	return detectRects(d.plates, img)
+}
+
+func (d *detectors) detectPeople(img gocv.Mat) ([]image.Rectangle, error) {
+	return detectRects(d.people, img)
+}
+
+func detectRects(fd FaceDetector, img gocv.Mat) ([]image.Rectangle, error) {
+	if fd == nil {
+		return nil, nil
+	}
+	found, err := fd.Detect(img)
+	if err != nil {
+		return nil, err
+	}
+	rects := make([]image.Rectangle, len(found))
+	for i, f := range found {
+		rects[i] = f.Rect
+	}
+	return rects, nil
+}
+
+func (d *detectors) Close() {
+	for _, fd := range d.list {
+		fd.Close()
+	}
+	for _, fb := range d.fallbacks {
+		fb.Close()
+	}
+	if d.verifier != nil {
+		d.verifier.Close()
+	}
+	if d.plates != nil {
+		d.plates.Close()
+	}
+	if d.people != nil {
+		d.people.Close()
+	}
+}
+
+func (d *detectors) detect(img gocv.Mat, batched []Detection) ([]image.Rectangle, error) {
+	found := batched
+	for _, fd := range d.list {
+		if _, ok := fd.(batchDetector); ok && batched != nil {
+			continue
+		}
+		faces, err := fd.Detect(img)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := fd.(*cascadeDetector); ok && d.verifier != nil {
+			if faces, err = verified(d.verifier, img, faces); err != nil {
+				return nil, err
+			}
+		}
+		found = append(found, faces...)
+	}
+
+	rects := make([]image.Rectangle, len(found))
+	for i, f := range found {
+		rects[i] = f.Rect
+	}
+	return mergeDetections(rects), nil
+}
+
+// canBatch reports whether any detector benefits from detectBatch.
+func (d *detectors) canBatch() bool {
+	for _, fd := range d.list {
+		if _, ok := fd.(batchDetector); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// detectBatch runs the batch detectors over imgs together. The result for
+// each image is never nil, so it can be passed to detectAll.
+func (d *detectors) detectBatch(imgs []gocv.Mat) ([][]Detection, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	found := make([][]Detection, len(imgs))
+	for i := range found {
+		found[i] = []Detection{}
+	}
+	for _, fd := range d.list {
+		bd, ok := fd.(batchDetector)
+		if !ok {
+			continue
+		}
+		faces, err := bd.DetectBatch(imgs)
+		if err != nil {
+			return nil, err
+		}
+		for i := range found {
+			found[i] = append(found[i], faces[i]...)
+		}
+	}
+	return found, nil
+}
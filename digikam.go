@@ -0,0 +1,264 @@
+//go:build !purego
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// digiKam keeps face regions as image tag properties on person tags. Faces
+// found by a detector but not yet named are attached to the "Unknown" person
+// as autodetectedFace, faces with a suggested name to that person as
+// autodetectedPerson; the property names are digiKam's own.
+const (
+	digikamAutodetected = "autodetectedFace"
+	digikamSuggested    = "autodetectedPerson"
+	digikamUnknown      = "unknownPerson"
+)
+
+// digikamSource marks the regions this tool wrote. digiKam only reads the
+// geometry attributes, and replaces the value when a face is confirmed, so
+// reruns can replace exactly the unconfirmed faces they added earlier.
+const digikamSource = `source="face-detector"`
+
+// digikamDB writes detections into an existing digiKam database
+// (digikam4.db), so faces show up in its People view as unknown faces ready
+// to be named, or as suggestions for the person Takeout names.
+type digikamDB struct {
+	db      *sql.DB
+	unknown int64
+	added   int
+}
+
+func openDigikam(dbPath string) (*digikamDB, error) {
+	// mode=rw so that a mistyped path fails instead of creating a database.
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=rw&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open digiKam database: %v", err)
+	}
+	var roots int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM AlbumRoots`).Scan(&roots); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s is not a digiKam database: %v", dbPath, err)
+	}
+	d := &digikamDB{db: db}
+	if d.unknown, err = d.unknownTag(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to set up the Unknown person tag: %v", err)
+	}
+	return d, nil
+}
+
+func (d *digikamDB) Close() error {
+	return d.db.Close()
+}
+
+// unknownTag returns the tag digiKam files unnamed faces under, creating it
+// below People the way digiKam does if the library has none yet.
+func (d *digikamDB) unknownTag() (int64, error) {
+	var id int64
+	err := d.db.QueryRow(`SELECT tagid FROM TagProperties WHERE property = ? LIMIT 1`, digikamUnknown).Scan(&id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return id, err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	people, err := peopleTag(tx)
+	if err != nil {
+		return 0, err
+	}
+	if id, err = insertTag(tx, people, "Unknown", "person", digikamUnknown); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// personTag returns the person tag called name, creating it below People if
+// the library has none yet.
+func personTag(tx *sql.Tx, name string) (int64, error) {
+	var id int64
+	err := tx.QueryRow(`SELECT t.id FROM Tags t JOIN TagProperties p ON p.tagid = t.id
+		WHERE t.name = ? AND p.property = 'person' LIMIT 1`, name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		var people int64
+		if people, err = peopleTag(tx); err == nil {
+			id, err = insertTag(tx, people, name, "person")
+		}
+	}
+	return id, err
+}
+
+func peopleTag(tx *sql.Tx) (int64, error) {
+	var people int64
+	err := tx.QueryRow(`SELECT id FROM Tags WHERE pid = 0 AND name = 'People'`).Scan(&people)
+	if errors.Is(err, sql.ErrNoRows) {
+		return insertTag(tx, 0, "People", "person")
+	}
+	return people, err
+}
+
+func insertTag(tx *sql.Tx, pid int64, name string, properties ...string) (int64, error) {
+	res, err := tx.Exec(`INSERT INTO Tags (pid, name) VALUES (?, ?)`, pid, name)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range properties {
+		if _, err := tx.Exec(`INSERT INTO TagProperties (tagid, property, value) VALUES (?, ?, '')`, id, p); err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// imageID finds an input in the library by its album path. Album roots may
+// be mounted elsewhere than where digiKam saw them, so the longest album
+// path that ends the input's absolute path wins.
+func (d *digikamDB) imageID(input string) (int64, error) {
+	abs, err := filepath.Abs(input)
+	if err != nil {
+		return 0, err
+	}
+	abs = filepath.ToSlash(abs)
+
+	rows, err := d.db.Query(`SELECT i.id, r.identifier, r.specificPath, a.relativePath
+		FROM Images i JOIN Albums a ON i.album = a.id JOIN AlbumRoots r ON a.albumRoot = r.id
+		WHERE i.name = ?`, filepath.Base(input))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var best int64
+	bestLen := 0
+	for rows.Next() {
+		var id int64
+		var identifier, specific, relative sql.NullString
+		if err := rows.Scan(&id, &identifier, &specific, &relative); err != nil {
+			return 0, err
+		}
+		full := path.Join("/", specific.String, relative.String, filepath.Base(input))
+		// Roots identified by path rather than volume give it in the URL.
+		if u, err := url.Parse(identifier.String); err == nil && u.Query().Get("path") != "" {
+			full = path.Join(u.Query().Get("path"), full)
+		}
+		if strings.HasSuffix(abs, full) && len(full) > bestLen {
+			best, bestLen = id, len(full)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if bestLen == 0 {
+		return 0, sql.ErrNoRows
+	}
+	return best, nil
+}
+
+// add records the faces of one image: as suggestions for the person when
+// Takeout named them, as unknown faces otherwise. Faces this tool added on
+// an earlier run and that are still unconfirmed are replaced; faces
+// overlapping a region digiKam already has, whether named, ignored or found
+// by its own detector, are left out so curated libraries are not re-tagged.
+func (d *digikamDB) add(r imageResult) error {
+	id, err := d.imageID(r.Input)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("not in the digiKam library")
+	}
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM ImageTagProperties WHERE imageid = ? AND property IN (?, ?) AND instr(value, ?) > 0`,
+		id, digikamAutodetected, digikamSuggested, digikamSource); err != nil {
+		return err
+	}
+	var existing []image.Rectangle
+	rows, err := tx.Query(`SELECT value FROM ImageTagProperties WHERE imageid = ?
+		AND property IN ('tagRegion', 'autodetectedPerson', 'autodetectedFace', 'ignoredFace')`, id)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			rows.Close()
+			return err
+		}
+		if rect, ok := parseDigikamRect(value); ok {
+			existing = append(existing, rect)
+		}
+	}
+	rows.Close()
+
+	added := 0
+	for _, b := range r.Boxes {
+		rect := b.rect()
+		if overlapsAny(rect, existing) {
+			continue
+		}
+		tag, property := d.unknown, digikamAutodetected
+		if b.Name != "" {
+			if tag, err = personTag(tx, b.Name); err != nil {
+				return err
+			}
+			property = digikamSuggested
+		}
+		value := fmt.Sprintf(`<rect x="%d" y="%d" width="%d" height="%d" %s/>`, b.X, b.Y, b.W, b.H, digikamSource)
+		if _, err := tx.Exec(`INSERT INTO ImageTagProperties (imageid, tagid, property, value) VALUES (?, ?, ?, ?)`,
+			id, tag, property, value); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO ImageTags (imageid, tagid) VALUES (?, ?)`, id, tag); err != nil {
+			return err
+		}
+		added++
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	d.added += added
+	return nil
+}
+
+func overlapsAny(rect image.Rectangle, others []image.Rectangle) bool {
+	for _, o := range others {
+		if iou(rect, o) > mergeIoU {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDigikamRect reads a region value such as
+// <rect x="10" y="20" width="64" height="64"/>, ignoring any attributes
+// after the geometry.
+func parseDigikamRect(value string) (image.Rectangle, bool) {
+	var x, y, w, h int
+	if _, err := fmt.Sscanf(value, `<rect x="%d" y="%d" width="%d" height="%d"`, &x, &y, &w, &h); err != nil {
+		return image.Rectangle{}, false
+	}
+	return image.Rect(x, y, x+w, y+h), true
+}
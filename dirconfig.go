@@ -0,0 +1,302 @@
+//go:build !purego
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dirConfigName is the per-directory settings file looked for next to
+// inputs.
+const dirConfigName = ".facedetector.yml"
+
+// detectorSettings can be overridden per directory although they live on
+// the detectors rather than in options.
+var detectorSettings = map[string]bool{"scale-factor": true, "min-neighbors": true, "min-confidence": true}
+
+// dirSettingNames are the options a settings file can change: how faces are
+// detected and how crops and annotated images look. Settings files sit in
+// the photo folders, so anyone able to write there must not be able to run
+// hooks, move inputs or redirect outputs.
+var dirSettingNames = map[string]bool{
+	"decoder": true, "resize": true, "tile": true, "tile-overlap": true, "roi": true,
+	"rotations": true, "box-smoothing": true, "existing-faces": true, "face-order": true,
+	"max-megapixels": true, "max-dimension": true, "oversize": true,
+	"crop-gray": true, "crop-normalize": true, "crop-transparent": true, "composition": true,
+	"crop-scope": true, "group-crop": true, "group-padding": true, "background-blur": true,
+	"anonymize": true, "label": true, "label-scale": true,
+}
+
+// readDirConfig parses a per-directory settings file: flat YAML mapping flag
+// names to values ("min-neighbors: 8"), with # comments and optional quotes.
+func readDirConfig(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want name: value", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(name)] = value
+	}
+	return values, scanner.Err()
+}
+
+// dirConfigChain lists the settings files that apply to inputs in dir, from
+// root down to dir, so deeper files override shallower ones. A dir outside
+// root only uses its own file.
+func dirConfigChain(dir, root string) []string {
+	dirs := []string{dir}
+	if rel, err := filepath.Rel(root, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		dirs = []string{root}
+		cur := root
+		if rel != "." {
+			for _, part := range strings.Split(rel, string(filepath.Separator)) {
+				cur = filepath.Join(cur, part)
+				dirs = append(dirs, cur)
+			}
+		}
+	}
+	var files []string
+	for _, d := range dirs {
+		p := filepath.Join(d, dirConfigName)
+		if _, err := os.Stat(p); err == nil {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+// resolveDirOptions works out the options for each input directory with a
+// settings file under root, keyed by directory. Files override the global
+// config and defaults, but not flags given on the command line. Values are
+// parsed by the flags themselves, which write to bound; bound holds opts
+// only while they are set.
+func resolveDirOptions(fs *flag.FlagSet, bound, opts *options, inputs []string, root string) (map[string]*options, error) {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	resolved := map[string]*options{}
+	done := map[string]bool{}
+	for _, p := range inputs {
+		dir := filepath.Dir(p)
+		if done[dir] {
+			continue
+		}
+		done[dir] = true
+
+		values := map[string]string{}
+		for _, file := range dirConfigChain(dir, root) {
+			v, err := readDirConfig(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %v", file, err)
+			}
+			for name, value := range v {
+				if !explicit[name] {
+					values[name] = value
+				}
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		o, err := optionsWith(fs, bound, opts, values)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filepath.Join(dir, dirConfigName), err)
+		}
+		resolved[dir] = o
+	}
+	return resolved, nil
+}
+
+// optionsWith returns a copy of opts with values, keyed by flag name,
+// applied. The flags write to the fields of bound, so bound is swapped for
+// opts while they are set. Only dirSettingNames and the detector
+// thresholds, which are kept in detectorSettings, can be set per directory.
+func optionsWith(fs *flag.FlagSet, bound, opts *options, values map[string]string) (*options, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := boundFields(bound)
+	saved := map[string]string{}
+	orig := *bound
+	*bound = *opts
+	defer func() {
+		*bound = orig
+		for name, value := range saved {
+			fs.Set(name, value)
+		}
+	}()
+	settings := map[string]string{}
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil {
+			return nil, fmt.Errorf("unknown setting %q", name)
+		}
+		switch f.Value.(type) {
+		case *stringList, *roiList:
+			return nil, fmt.Errorf("%s cannot be set per directory", name)
+		}
+		if detectorSettings[name] {
+			saved[name] = f.Value.String()
+			settings[name] = values[name]
+		} else if !dirSettingNames[name] || !fields[valueAddr(f.Value)] {
+			return nil, fmt.Errorf("%s cannot be set per directory", name)
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+	o := *bound
+	o.detectorSettings = settings
+	o.dirSettings = values
+	if err := validateDirOptions(&o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// boundFields returns the addresses of the fields of o.
+func boundFields(o *options) map[uintptr]bool {
+	v := reflect.ValueOf(o).Elem()
+	fields := make(map[uintptr]bool, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		fields[v.Field(i).UnsafeAddr()] = true
+	}
+	return fields
+}
+
+// valueAddr is where a flag stores its value: the variable it was bound to.
+func valueAddr(v flag.Value) uintptr {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer {
+		return 0
+	}
+	return rv.Pointer()
+}
+
+// validateDirOptions holds a directory's options to the checks main makes
+// of the command line.
+func validateDirOptions(o *options) error {
+	for _, err := range []error{
+		validateResizeMode(o.resizeMode),
+		validateComposition(o.composition),
+		validateAnonymizeMethod(o.anonymize),
+		validateCropScope(o.cropScope),
+		validateOversizePolicy(o.limits.oversize),
+		validateQuarantineMode(o.quarantineMode),
+		validateFaceOrder(o.faceOrder),
+		validateExistingFaces(o.existingFaces),
+		validateTimelineFormat(o.timeline),
+		validateDecoder(o.decoder),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	if o.boxSmoothing < 0 || o.boxSmoothing >= 1 {
+		return errors.New("box-smoothing must be at least 0 and below 1")
+	}
+	if o.tileSize < 0 {
+		return errors.New("tile must not be negative")
+	}
+	if o.tileOverlap < 0 || o.tileOverlap >= 1 {
+		return errors.New("tile-overlap must be at least 0 and below 1")
+	}
+	if o.sealer != nil && o.keywords {
+		return errors.New("encrypted crops cannot be read back by keywords")
+	}
+	return nil
+}
+
+// applySettings overrides the detection thresholds for one image and
+// returns a function restoring them. d.mu must be held.
+func (d *detectors) applySettings(settings map[string]string) func() {
+	if len(settings) == 0 {
+		return func() {}
+	}
+	var cascades []*cascadeDetector
+	var nets []*netDetector
+	var onnxs []*onnxDetector
+	for _, dd := range append([]*detectors{d}, d.fallbacks...) {
+		for _, fd := range dd.list {
+			switch fd := fd.(type) {
+			case *cascadeDetector:
+				cascades = append(cascades, fd)
+			case *netDetector:
+				nets = append(nets, fd)
+			case *onnxDetector:
+				onnxs = append(onnxs, fd)
+			}
+		}
+	}
+
+	type cascadeSettings struct {
+		scaleFactor  float64
+		minNeighbors int
+	}
+	savedCascades := make([]cascadeSettings, len(cascades))
+	for i, c := range cascades {
+		savedCascades[i] = cascadeSettings{c.ScaleFactor, c.MinNeighbors}
+	}
+	savedNets := make([]float64, len(nets))
+	for i, n := range nets {
+		savedNets[i] = n.MinConfidence
+	}
+	savedONNX := make([]float32, len(onnxs))
+	for i, o := range onnxs {
+		savedONNX[i] = o.minConfidence
+	}
+
+	// applyTuned sets the cascade values as a pair.
+	full := map[string]string{}
+	if len(cascades) > 0 {
+		full["scale-factor"] = strconv.FormatFloat(cascades[0].ScaleFactor, 'g', -1, 64)
+		full["min-neighbors"] = strconv.Itoa(cascades[0].MinNeighbors)
+	}
+	for name, value := range settings {
+		full[name] = value
+	}
+	applyTuned(full, cascades, nets, onnxs)
+
+	return func() {
+		for i, c := range cascades {
+			c.ScaleFactor, c.MinNeighbors = savedCascades[i].scaleFactor, savedCascades[i].minNeighbors
+		}
+		for i, n := range nets {
+			n.MinConfidence = savedNets[i]
+		}
+		for i, o := range onnxs {
+			o.minConfidence = savedONNX[i]
+		}
+	}
+}
@@ -0,0 +1,104 @@
+//go:build !purego
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testFlags binds a few flags the way main does: most to options fields,
+// one to a local that main parses further.
+func testFlags() (*flag.FlagSet, *options) {
+	bound := &options{
+		resizeMode:     resizeFit,
+		composition:    compositionPadded,
+		cropScope:      cropScopeFace,
+		quarantineMode: quarantineMove,
+		faceOrder:      faceOrderLTR,
+		decoder:        decoderOpenCV,
+		tileOverlap:    0.25,
+	}
+	bound.limits.oversize = oversizeReject
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&bound.composition, "composition", compositionPadded, "")
+	fs.StringVar(&bound.anonymize, "anonymize", "", "")
+	fs.IntVar(&bound.tileSize, "tile", 0, "")
+	fs.Float64Var(&bound.tileOverlap, "tile-overlap", 0.25, "")
+	fs.String("smart-crop", "", "")
+	fs.StringVar(&bound.heatmap, "heatmap", "", "")
+	fs.StringVar(&bound.preHook, "pre-hook", "", "")
+	fs.StringVar(&bound.postHook, "post-hook", "", "")
+	fs.StringVar(&bound.quarantineDir, "quarantine", "", "")
+	fs.StringVar(&bound.quarantineMode, "quarantine-mode", quarantineMove, "")
+	return fs, bound
+}
+
+func writeDirConfig(t *testing.T, dir, config string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, dirConfigName), []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveDirOptionsAppliesOverrides(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeDirConfig(t, sub, "composition: thirds\nanonymize: blur\ntile: 640\n")
+
+	fs, bound := testFlags()
+	run := *bound
+	run.outputDir = "out"
+	inputs := []string{filepath.Join(root, "a.jpg"), filepath.Join(sub, "b.jpg")}
+	resolved, err := resolveDirOptions(fs, bound, &run, inputs, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := resolved[root]; ok {
+		t.Errorf("root has no settings file but got options")
+	}
+	o := resolved[sub]
+	if o == nil {
+		t.Fatal("no options for sub")
+	}
+	if o.composition != compositionThirds || o.anonymize != anonymizeBlur || o.tileSize != 640 {
+		t.Errorf("got composition %q, anonymize %q, tile %d; want thirds, blur, 640", o.composition, o.anonymize, o.tileSize)
+	}
+	if o.outputDir != "out" {
+		t.Errorf("got output dir %q, want the run's %q", o.outputDir, "out")
+	}
+	if bound.composition != compositionPadded || bound.tileSize != 0 || run.composition != compositionPadded {
+		t.Errorf("global options changed: bound %q %d, run %q", bound.composition, bound.tileSize, run.composition)
+	}
+}
+
+func TestResolveDirOptionsRejects(t *testing.T) {
+	for _, config := range []string{
+		"smart-crop: 400x400\n", // parsed further by main
+		"heatmap: heat.png\n",   // applies to the whole run
+		"pre-hook: touch /tmp/pwned\n",
+		"post-hook: touch /tmp/pwned\n",
+		"quarantine: /tmp/elsewhere\n",
+		"quarantine-mode: copy\n",
+		"composition: golden\n", // invalid value
+		"tile-overlap: 1\n",     // invalid value
+		"no-such-flag: 1\n",
+	} {
+		dir := t.TempDir()
+		writeDirConfig(t, dir, config)
+		fs, bound := testFlags()
+		run := *bound
+		if _, err := resolveDirOptions(fs, bound, &run, []string{filepath.Join(dir, "a.jpg")}, dir); err == nil {
+			t.Errorf("%q: expected an error", config)
+		}
+		if bound.composition != compositionPadded {
+			t.Errorf("%q: global composition changed to %q", config, bound.composition)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// trackDwell is how long one tracked face stayed in a video: entry and exit
+// in seconds from the start, and the seconds it was actually in frame
+// in between.
+type trackDwell struct {
+	Track   int     `json:"track"`
+	Entry   float64 `json:"entry"`
+	Exit    float64 `json:"exit"`
+	Seconds float64 `json:"seconds"`
+}
+
+// dwellTimes sums up the appearances of each track, in track order.
+func dwellTimes(segments []segment) []trackDwell {
+	byTrack := map[int]*trackDwell{}
+	var ids []int
+	for _, s := range segments {
+		d, ok := byTrack[s.Track]
+		if !ok {
+			d = &trackDwell{Track: s.Track, Entry: s.Start, Exit: s.End}
+			byTrack[s.Track] = d
+			ids = append(ids, s.Track)
+		}
+		d.Entry = min(d.Entry, s.Start)
+		d.Exit = max(d.Exit, s.End)
+		d.Seconds += s.End - s.Start
+	}
+	sort.Ints(ids)
+	dwells := make([]trackDwell, len(ids))
+	for i, id := range ids {
+		dwells[i] = *byTrack[id]
+	}
+	return dwells
+}
+
+// cameraDwell is one visit of a face to a live camera.
+type cameraDwell struct {
+	Source  string    `json:"source"`
+	Track   int       `json:"track"`
+	Entry   time.Time `json:"entry"`
+	Exit    time.Time `json:"exit"`
+	Seconds float64   `json:"seconds"`
+}
+
+// dwellLog appends a line of JSON to dwell.jsonl for each face that leaves a
+// camera's view, with when it entered and left.
+type dwellLog struct {
+	source   string
+	file     *os.File
+	entry    map[int]time.Time
+	lastSeen map[int]time.Time
+}
+
+func openDwellLog(dir, source string, mode os.FileMode) (*dwellLog, error) {
+	f, err := os.OpenFile(filepath.Join(dir, "dwell.jsonl"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, mode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dwell log: %v", err)
+	}
+	return &dwellLog{source: source, file: f, entry: map[int]time.Time{}, lastSeen: map[int]time.Time{}}, nil
+}
+
+// update records the track ids in view at now, logging those that left.
+func (l *dwellLog) update(ids []int, now time.Time) error {
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		seen[id] = true
+		if _, ok := l.entry[id]; !ok {
+			l.entry[id] = now
+		}
+		l.lastSeen[id] = now
+	}
+	for _, id := range l.sortedIDs() {
+		if !seen[id] {
+			if err := l.leave(id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (l *dwellLog) sortedIDs() []int {
+	ids := make([]int, 0, len(l.entry))
+	for id := range l.entry {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func (l *dwellLog) leave(id int) error {
+	entry, exit := l.entry[id], l.lastSeen[id]
+	delete(l.entry, id)
+	delete(l.lastSeen, id)
+	data, err := json.Marshal(cameraDwell{Source: l.source, Track: id, Entry: entry, Exit: exit, Seconds: exit.Sub(entry).Seconds()})
+	if err != nil {
+		return err
+	}
+	_, err = l.file.Write(append(data, '\n'))
+	return err
+}
+
+// Close logs the faces still in view as leaving when last seen.
+func (l *dwellLog) Close() error {
+	var err error
+	for _, id := range l.sortedIDs() {
+		if lerr := l.leave(id); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
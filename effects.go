@@ -0,0 +1,52 @@
+//go:build !purego
+
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"gocv.io/x/gocv"
+)
+
+// blurBackground keeps an elliptical region around every subject sharp and
+// blends it with a heavily blurred copy of the rest of the image, giving a
+// portrait-style result. ksize is rounded up to the next odd number.
+func blurBackground(img *gocv.Mat, subjects []image.Rectangle, ksize int) {
+	if ksize%2 == 0 {
+		ksize++
+	}
+
+	mask := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), img.Rows(), img.Cols(), gocv.MatTypeCV8UC1)
+	defer mask.Close()
+	for _, s := range subjects {
+		center := image.Pt((s.Min.X+s.Max.X)/2, (s.Min.Y+s.Max.Y)/2)
+		axes := image.Pt(s.Dx()/2, s.Dy()/2)
+		gocv.Ellipse(&mask, center, axes, 0, 0, 360, color.RGBA{255, 255, 255, 0}, -1)
+	}
+	// Feather the mask so the transition into the blur is not a hard edge.
+	feather := max(3, ksize/2) | 1
+	gocv.GaussianBlur(mask, &mask, image.Pt(feather, feather), 0, 0, gocv.BorderDefault)
+
+	blurred := gocv.NewMat()
+	defer blurred.Close()
+	gocv.GaussianBlur(*img, &blurred, image.Pt(ksize, ksize), 0, 0, gocv.BorderDefault)
+
+	weights := gocv.NewMat()
+	defer weights.Close()
+	mask.ConvertToWithParams(&weights, gocv.MatTypeCV32F, 1.0/255, 0)
+	gocv.CvtColor(weights, &weights, gocv.ColorGrayToBGR)
+
+	sharpF := gocv.NewMat()
+	defer sharpF.Close()
+	blurredF := gocv.NewMat()
+	defer blurredF.Close()
+	img.ConvertTo(&sharpF, gocv.MatTypeCV32FC3)
+	blurred.ConvertTo(&blurredF, gocv.MatTypeCV32FC3)
+
+	// out = blurred + (sharp - blurred) * weight
+	gocv.Subtract(sharpF, blurredF, &sharpF)
+	gocv.Multiply(sharpF, weights, &sharpF)
+	gocv.Add(blurredF, sharpF, &sharpF)
+	sharpF.ConvertTo(img, gocv.MatTypeCV8UC3)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Encrypted crops are written with encSuffix appended to their name and
+// start with encMagic and a mode byte. With a key file (encModeKey) the
+// nonce and the AES-256-GCM sealed crop follow. With recipients
+// (encModeX25519) a count byte and one stanza per recipient come first: an
+// ephemeral X25519 public key and the random file key sealed to the secret
+// it shares with the recipient. The crop is then sealed with the file key.
+// Everything before the nonce is authenticated along with the crop.
+const (
+	encSuffix     = ".enc"
+	encMagic      = "FDENC1"
+	encModeKey    = 1
+	encModeX25519 = 2
+
+	encKeySize       = 32
+	encStanzaSize    = 32 + encKeySize + 16
+	encWrapInfo      = "face-detector crop key"
+	maxEncRecipients = 255
+)
+
+// cropSealer encrypts face crops at rest, either with a shared AES key or
+// to the holders of X25519 private keys.
+type cropSealer struct {
+	key        []byte
+	recipients []*ecdh.PublicKey
+}
+
+// loadSealer reads --encrypt-key or the --encrypt-to public keys; only one
+// of them may be given.
+func loadSealer(keyFile string, recipientFiles []string) (*cropSealer, error) {
+	if keyFile != "" && len(recipientFiles) > 0 {
+		return nil, errors.New("--encrypt-key and --encrypt-to cannot be combined")
+	}
+	if len(recipientFiles) > maxEncRecipients {
+		return nil, fmt.Errorf("at most %d --encrypt-to recipients are supported", maxEncRecipients)
+	}
+	s := &cropSealer{}
+	if keyFile != "" {
+		key, err := readAESKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		s.key = key
+	}
+	for _, path := range recipientFiles {
+		pub, err := readRecipient(path)
+		if err != nil {
+			return nil, err
+		}
+		s.recipients = append(s.recipients, pub)
+	}
+	return s, nil
+}
+
+// readAESKey reads a 32-byte key stored raw (openssl rand 32) or as hex.
+func readAESKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+	if len(data) == encKeySize {
+		return data, nil
+	}
+	if key, err := hex.DecodeString(strings.TrimSpace(string(data))); err == nil && len(key) == encKeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%s is not a 32-byte key, raw or hex", path)
+}
+
+// readRecipient reads an X25519 public key in PEM, as written by
+// openssl pkey -pubout.
+func readRecipient(path string) (*ecdh.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %v", path, err)
+	}
+	pub, ok := key.(*ecdh.PublicKey)
+	if !ok || pub.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("%s is not an X25519 public key", path)
+	}
+	return pub, nil
+}
+
+// readIdentity reads an X25519 private key in PEM, as written by
+// openssl genpkey -algorithm X25519.
+func readIdentity(path string) (*ecdh.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %v", path, err)
+	}
+	priv, ok := key.(*ecdh.PrivateKey)
+	if !ok || priv.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("%s is not an X25519 private key", path)
+	}
+	return priv, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM key found in %s", path)
+	}
+	return block, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrapKey derives the key sealing the file key from an X25519 shared
+// secret with HKDF-SHA256, salted with both public keys.
+func wrapKey(shared, ephemeral, recipient []byte) []byte {
+	extract := hmac.New(sha256.New, append(append([]byte(nil), ephemeral...), recipient...))
+	extract.Write(shared)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(encWrapInfo))
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+// seal encrypts one crop.
+func (s *cropSealer) seal(plain []byte) ([]byte, error) {
+	header := []byte(encMagic)
+	key := s.key
+	if key != nil {
+		header = append(header, encModeKey)
+	} else {
+		key = make([]byte, encKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		header = append(header, encModeX25519, byte(len(s.recipients)))
+		for _, pub := range s.recipients {
+			eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+			if err != nil {
+				return nil, err
+			}
+			shared, err := eph.ECDH(pub)
+			if err != nil {
+				return nil, err
+			}
+			// Each wrap key is used once, so a fixed nonce is safe.
+			aead, err := newGCM(wrapKey(shared, eph.PublicKey().Bytes(), pub.Bytes()))
+			if err != nil {
+				return nil, err
+			}
+			header = append(header, eph.PublicKey().Bytes()...)
+			header = aead.Seal(header, make([]byte, aead.NonceSize()), key, nil)
+		}
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte(nil), header...), nonce...)
+	return aead.Seal(out, nonce, plain, header), nil
+}
+
+// openSealed decrypts a crop written by seal, with the AES key or the
+// X25519 identity it was encrypted for.
+func openSealed(data, key []byte, identity *ecdh.PrivateKey) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(encMagic)) || len(data) < len(encMagic)+1 {
+		return nil, errors.New("not an encrypted crop")
+	}
+	n := len(encMagic) + 1
+	switch data[len(encMagic)] {
+	case encModeKey:
+		if key == nil {
+			return nil, errors.New("crop was encrypted with a key file, not to a recipient")
+		}
+	case encModeX25519:
+		if identity == nil {
+			return nil, errors.New("crop was encrypted to recipients, not with a key file")
+		}
+		if len(data) < n+1 {
+			return nil, errors.New("truncated encrypted crop")
+		}
+		count := int(data[n])
+		n++
+		if len(data) < n+count*encStanzaSize {
+			return nil, errors.New("truncated encrypted crop")
+		}
+		key = nil
+		for i := 0; i < count && key == nil; i++ {
+			stanza := data[n+i*encStanzaSize : n+(i+1)*encStanzaSize]
+			eph, err := ecdh.X25519().NewPublicKey(stanza[:32])
+			if err != nil {
+				continue
+			}
+			shared, err := identity.ECDH(eph)
+			if err != nil {
+				continue
+			}
+			aead, err := newGCM(wrapKey(shared, stanza[:32], identity.PublicKey().Bytes()))
+			if err != nil {
+				return nil, err
+			}
+			key, _ = aead.Open(nil, make([]byte, aead.NonceSize()), stanza[32:], nil)
+		}
+		if key == nil {
+			return nil, errors.New("crop was not encrypted to this identity")
+		}
+		n += count * encStanzaSize
+	default:
+		return nil, errors.New("unknown encryption mode")
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < n+aead.NonceSize() {
+		return nil, errors.New("truncated encrypted crop")
+	}
+	header, nonce := data[:n], data[n:n+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, data[n+aead.NonceSize():], header)
+	if err != nil {
+		return nil, errors.New("failed to decrypt crop: wrong key or corrupted file")
+	}
+	return plain, nil
+}
+
+// decryptCrop returns the plaintext of the encrypted crop at path.
+// keyPath is the AES key file or, in PEM, the X25519 private key.
+func decryptCrop(path, keyPath string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var key []byte
+	var identity *ecdh.PrivateKey
+	if raw, rerr := os.ReadFile(keyPath); rerr == nil && bytes.Contains(raw, []byte("-----BEGIN")) {
+		identity, err = readIdentity(keyPath)
+	} else {
+		key, err = readAESKey(keyPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return openSealed(data, key, identity)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// detectionEvent reports the faces in one camera frame or, in server mode,
+// one image posted to /detect. Boxes are in the coordinates of the
+// full-resolution image.
+type detectionEvent struct {
+	Source string      `json:"source"`
+	Time   time.Time   `json:"time"`
+	Frame  int         `json:"frame,omitempty"`
+	Faces  []eventFace `json:"faces"`
+}
+
+// eventFace is a face in an event. Track, like Frame, is only set in camera
+// mode.
+type eventFace struct {
+	Track  int `json:"track,omitempty"`
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func newEventFace(id int, r image.Rectangle) eventFace {
+	return eventFace{Track: id, X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy()}
+}
+
+// eventStream pushes detection events to WebSocket clients as JSON text
+// messages. Clients only receive; anything they send is discarded.
+type eventStream struct {
+	*broadcaster
+	upgrader websocket.Upgrader
+}
+
+// newEventStream accepts connections from pages on the same origin and on
+// origins, where "*" allows any. Clients that send no Origin, which
+// browsers always do, are not web pages and are let through.
+func newEventStream(origins []string) *eventStream {
+	s := &eventStream{broadcaster: newBroadcaster()}
+	s.upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		for _, o := range origins {
+			if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+				return true
+			}
+		}
+		return false
+	}
+	return s
+}
+
+func (s *eventStream) send(event detectionEvent) {
+	if !s.watched() {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	s.publish(data)
+}
+
+func (s *eventStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	events, unsubscribe := s.subscribe()
+	defer unsubscribe()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case data := <-events:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		}
+	}
+}
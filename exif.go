@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// jpegHeadSize bounds how much of a JPEG is read looking for Exif or XMP,
+// which live in APP1 segments near the start of the file.
+const jpegHeadSize = 256 << 10
+
+var exifSignature = []byte("Exif\x00\x00")
+
+// Exif tags holding the capture time, in order of preference.
+const (
+	exifIFDPointer        = 0x8769
+	exifDateTimeOriginal  = 0x9003
+	exifDateTimeDigitized = 0x9004
+	exifDateTime          = 0x0132
+)
+
+// readJPEGHead returns the start of a JPEG file, or nil for other files.
+func readJPEGHead(path string) ([]byte, error) {
+	if ext := strings.ToLower(filepath.Ext(path)); ext != ".jpg" && ext != ".jpeg" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, jpegHeadSize))
+}
+
+// captureTime is when an image was taken: the Takeout time when known, else
+// the Exif DateTimeOriginal of a JPEG.
+func captureTime(r imageResult) (time.Time, bool) {
+	if r.TakenAt != nil {
+		return *r.TakenAt, true
+	}
+	head, err := readJPEGHead(r.Input)
+	if err != nil || head == nil {
+		return time.Time{}, false
+	}
+	return exifCaptureTime(head)
+}
+
+// exifCaptureTime reads the capture time from the Exif segment of a JPEG.
+// Exif times carry no zone, so they are returned as UTC wall-clock times.
+func exifCaptureTime(data []byte) (time.Time, bool) {
+	var tiff []byte
+	jpegSegments(data, func(marker byte, _, _ int, body []byte) error {
+		if marker == 0xE1 && bytes.HasPrefix(body, exifSignature) && tiff == nil {
+			tiff = body[len(exifSignature):]
+		}
+		return nil
+	})
+	if len(tiff) < 8 {
+		return time.Time{}, false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, false
+	}
+
+	ifd0 := readIFD(tiff, order, order.Uint32(tiff[4:]))
+	tags := ifd0
+	if off, ok := ifd0[exifIFDPointer]; ok {
+		tags = readIFD(tiff, order, off)
+	}
+	for _, tag := range []uint16{exifDateTimeOriginal, exifDateTimeDigitized} {
+		if t, ok := exifTime(tiff, tags, tag); ok {
+			return t, true
+		}
+	}
+	return exifTime(tiff, ifd0, exifDateTime)
+}
+
+// readIFD maps the tags of one image file directory to their value fields:
+// the value itself when it fits in four bytes, otherwise its offset.
+func readIFD(tiff []byte, order binary.ByteOrder, off uint32) map[uint16]uint32 {
+	tags := map[uint16]uint32{}
+	if int(off)+2 > len(tiff) {
+		return tags
+	}
+	n := int(order.Uint16(tiff[off:]))
+	for i := 0; i < n; i++ {
+		e := int(off) + 2 + i*12
+		if e+12 > len(tiff) {
+			break
+		}
+		tags[order.Uint16(tiff[e:])] = order.Uint32(tiff[e+8:])
+	}
+	return tags
+}
+
+// exifTime parses an ASCII "2006:01:02 15:04:05" tag, which at 20 bytes is
+// always stored at an offset.
+func exifTime(tiff []byte, tags map[uint16]uint32, tag uint16) (time.Time, bool) {
+	off, ok := tags[tag]
+	if !ok || int(off)+19 > len(tiff) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("2006:01:02 15:04:05", string(tiff[off:off+19]))
+	return t, err == nil
+}
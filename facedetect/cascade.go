@@ -0,0 +1,47 @@
+package facedetect
+
+import (
+	"fmt"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// Cascade is a Haar or LBP cascade classifier. Its detections have a
+// confidence of 1. ScaleFactor and MinNeighbors may be changed between
+// calls to Detect.
+type Cascade struct {
+	classifier   gocv.CascadeClassifier
+	ScaleFactor  float64
+	MinNeighbors int
+}
+
+// NewCascade loads the cascade file at path. scaleFactor is the image
+// pyramid step and minNeighbors the overlapping hits needed to keep a face.
+func NewCascade(path string, scaleFactor float64, minNeighbors int) (*Cascade, error) {
+	classifier := gocv.NewCascadeClassifier()
+	if !classifier.Load(path) {
+		classifier.Close()
+		return nil, fmt.Errorf("error loading Haar cascade file %s", path)
+	}
+	return &Cascade{classifier: classifier, ScaleFactor: scaleFactor, MinNeighbors: minNeighbors}, nil
+}
+
+func (c *Cascade) Detect(img gocv.Mat) ([]Detection, error) {
+	grayImg := gocv.NewMat()
+	defer grayImg.Close()
+	gocv.CvtColor(img, &grayImg, gocv.ColorBGRToGray)
+
+	rects := c.classifier.DetectMultiScaleWithParams(
+		grayImg, c.ScaleFactor, c.MinNeighbors, 0, image.Point{X: 30, Y: 30}, image.Point{},
+	)
+	faces := make([]Detection, len(rects))
+	for i, rect := range rects {
+		faces[i] = Detection{Rect: rect, Confidence: 1}
+	}
+	return faces, nil
+}
+
+func (c *Cascade) Close() error {
+	return c.classifier.Close()
+}
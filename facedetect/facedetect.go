@@ -0,0 +1,157 @@
+// Package facedetect finds faces with OpenCV, using Haar and LBP cascades
+// or SSD-style DNN models. It holds the detection backends of the
+// face-detector tool, for programs that want faces in images they already
+// have in memory, such as HTTP uploads or message payloads.
+package facedetect
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+	"sync"
+
+	"gocv.io/x/gocv"
+)
+
+// Detection is a single face, in the coordinates of the image searched.
+type Detection struct {
+	Rect       image.Rectangle
+	Confidence float64
+}
+
+// FaceDetector is implemented by every detection backend.
+type FaceDetector interface {
+	Detect(img gocv.Mat) ([]Detection, error)
+	Close() error
+}
+
+var (
+	// ErrTooLarge is returned for input larger than Options.MaxFileSize.
+	ErrTooLarge = errors.New("image too large")
+	// ErrUnreadable is returned for data that does not decode to an image.
+	ErrUnreadable = errors.New("unreadable image")
+)
+
+// Options configure a Detector. Zero values disable a setting.
+type Options struct {
+	// MaxFileSize rejects encoded images larger than this many bytes.
+	MaxFileSize int64
+	// MaxWidth and MaxHeight shrink larger images before detection, which
+	// is faster; faces are still returned in full-size coordinates.
+	MaxWidth, MaxHeight int
+}
+
+// mergeIoU is the overlap above which two detections are the same face.
+const mergeIoU = 0.3
+
+// Detector runs FaceDetectors over encoded images. It is safe for
+// concurrent use; calls are serialized because OpenCV objects are not.
+type Detector struct {
+	opts      Options
+	detectors []FaceDetector
+
+	mu sync.Mutex
+}
+
+// New returns a Detector using detectors, which it closes on Close.
+func New(opts Options, detectors ...FaceDetector) *Detector {
+	return &Detector{opts: opts, detectors: detectors}
+}
+
+// DetectBytes finds faces in an encoded image (JPEG, PNG, WebP or any
+// format OpenCV reads). Faces found by several detectors are reported
+// once, with the highest score; cascades score every face 1.
+func (d *Detector) DetectBytes(data []byte) ([]Detection, error) {
+	if d.opts.MaxFileSize > 0 && int64(len(data)) > d.opts.MaxFileSize {
+		return nil, ErrTooLarge
+	}
+	img, err := gocv.IMDecode(data, gocv.IMReadColor)
+	if err != nil || img.Empty() {
+		img.Close()
+		return nil, ErrUnreadable
+	}
+	defer img.Close()
+
+	search, scale := img, 1.0
+	if w, h := d.opts.MaxWidth, d.opts.MaxHeight; (w > 0 && img.Cols() > w) || (h > 0 && img.Rows() > h) {
+		scale = 1e9
+		if w > 0 {
+			scale = float64(w) / float64(img.Cols())
+		}
+		if h > 0 {
+			scale = min(scale, float64(h)/float64(img.Rows()))
+		}
+		search = gocv.NewMat()
+		defer search.Close()
+		gocv.Resize(img, &search, image.Point{}, scale, scale, gocv.InterpolationArea)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var found []Detection
+	for _, fd := range d.detectors {
+		faces, err := fd.Detect(search)
+		if err != nil {
+			return nil, fmt.Errorf("error detecting faces: %v", err)
+		}
+		found = append(found, faces...)
+	}
+	for i := range found {
+		r := found[i].Rect
+		found[i].Rect = image.Rect(int(float64(r.Min.X)/scale), int(float64(r.Min.Y)/scale),
+			int(float64(r.Max.X)/scale), int(float64(r.Max.Y)/scale))
+	}
+	return merge(found), nil
+}
+
+// DetectReader is DetectBytes for a stream. With Options.MaxFileSize set,
+// no more than that is read, so an endless body cannot exhaust memory.
+func (d *Detector) DetectReader(r io.Reader) ([]Detection, error) {
+	if d.opts.MaxFileSize > 0 {
+		r = io.LimitReader(r, d.opts.MaxFileSize+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %v", err)
+	}
+	return d.DetectBytes(data)
+}
+
+// Close closes every detector.
+func (d *Detector) Close() error {
+	var errs []error
+	for _, fd := range d.detectors {
+		errs = append(errs, fd.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// merge drops detections overlapping a higher scoring one.
+func merge(found []Detection) []Detection {
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Confidence > found[j].Confidence })
+	merged := []Detection{}
+	for _, f := range found {
+		duplicate := false
+		for _, kept := range merged {
+			if iou(f.Rect, kept.Rect) > mergeIoU {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			merged = append(merged, f)
+		}
+	}
+	return merged
+}
+
+func iou(a, b image.Rectangle) float64 {
+	inter := a.Intersect(b)
+	if inter.Empty() {
+		return 0
+	}
+	i := float64(inter.Dx() * inter.Dy())
+	return i / (float64(a.Dx()*a.Dy()+b.Dx()*b.Dy()) - i)
+}
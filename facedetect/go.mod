@@ -0,0 +1,5 @@
+module github.com/Zavr22/face-detector/facedetect
+
+go 1.23.0
+
+require gocv.io/x/gocv v0.39.0
@@ -0,0 +1,116 @@
+package facedetect
+
+import (
+	"fmt"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// InputSize is the width and height images are scaled to for a Net.
+const InputSize = 300
+
+// Net is an SSD-style DNN face model loaded with OpenCV, such as the res10
+// face detector. Faces scoring below MinConfidence are dropped; it may be
+// changed between calls to Detect.
+type Net struct {
+	net           gocv.Net
+	MinConfidence float64
+}
+
+// netDevice is an OpenCV DNN backend and target pair.
+type netDevice struct {
+	backend gocv.NetBackendType
+	target  gocv.NetTargetType
+}
+
+// devices maps device names to OpenCV DNN settings. openvino needs an
+// OpenCV built with the Inference Engine.
+var devices = map[string]netDevice{
+	"cpu":      {gocv.NetBackendDefault, gocv.NetTargetCPU},
+	"openvino": {gocv.NetBackendOpenVINO, gocv.NetTargetCPU},
+	"opencl":   {gocv.NetBackendDefault, gocv.NetTargetFP32},
+	"cuda":     {gocv.NetBackendCUDA, gocv.NetTargetCUDA},
+}
+
+// ValidateDevice checks a device name accepted by NewNet.
+func ValidateDevice(device string) error {
+	if _, ok := devices[device]; !ok {
+		return fmt.Errorf("unknown device %q (want cpu, openvino, opencl or cuda)", device)
+	}
+	return nil
+}
+
+// NewNet loads the model at path to run on device: cpu, openvino (Intel
+// Inference Engine), opencl or cuda.
+func NewNet(path, device string, minConfidence float64) (*Net, error) {
+	net := gocv.ReadNet(path, "")
+	if net.Empty() {
+		net.Close()
+		return nil, fmt.Errorf("error loading model %s", path)
+	}
+	dev := devices[device]
+	if err := net.SetPreferableBackend(dev.backend); err != nil {
+		net.Close()
+		return nil, fmt.Errorf("failed to set DNN backend for %s: %v", device, err)
+	}
+	if err := net.SetPreferableTarget(dev.target); err != nil {
+		net.Close()
+		return nil, fmt.Errorf("failed to set DNN target for %s: %v", device, err)
+	}
+	return &Net{net: net, MinConfidence: minConfidence}, nil
+}
+
+// Detect runs an SSD-style network whose output is a [1,1,N,7] blob of
+// (image, class, confidence, x1, y1, x2, y2) rows with normalized
+// coordinates, as produced by the OpenCV res10 face detector.
+func (n *Net) Detect(img gocv.Mat) ([]Detection, error) {
+	found, err := n.DetectBatch([]gocv.Mat{img})
+	if err != nil {
+		return nil, err
+	}
+	return found[0], nil
+}
+
+// DetectBatch runs imgs through the network in a single forward pass. The
+// first column of each output row says which image it belongs to.
+func (n *Net) DetectBatch(imgs []gocv.Mat) ([][]Detection, error) {
+	blob := gocv.NewMat()
+	defer blob.Close()
+	gocv.BlobFromImages(imgs, &blob, 1.0, image.Pt(InputSize, InputSize),
+		gocv.NewScalar(104, 177, 123, 0), false, false, gocv.MatTypeCV32F)
+
+	n.net.SetInput(blob, "")
+	out := n.net.Forward("")
+	defer out.Close()
+
+	detections := gocv.GetBlobChannel(out, 0, 0)
+	defer detections.Close()
+
+	faces := make([][]Detection, len(imgs))
+	for i := range faces {
+		faces[i] = []Detection{}
+	}
+	for r := 0; r < detections.Rows(); r++ {
+		confidence := detections.GetFloatAt(r, 2)
+		idx := int(detections.GetFloatAt(r, 0))
+		if float64(confidence) < n.MinConfidence || idx < 0 || idx >= len(imgs) {
+			continue
+		}
+		img := imgs[idx]
+		rect := image.Rect(
+			int(detections.GetFloatAt(r, 3)*float32(img.Cols())),
+			int(detections.GetFloatAt(r, 4)*float32(img.Rows())),
+			int(detections.GetFloatAt(r, 5)*float32(img.Cols())),
+			int(detections.GetFloatAt(r, 6)*float32(img.Rows())),
+		).Intersect(image.Rect(0, 0, img.Cols(), img.Rows()))
+		if !rect.Empty() {
+			faces[idx] = append(faces[idx], Detection{Rect: rect, Confidence: float64(confidence)})
+		}
+	}
+	return faces, nil
+}
+
+func (n *Net) Close() error {
+	return n.net.Close()
+}
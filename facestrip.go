@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// stripTile is the size each crop is scaled to in a face strip; the date
+// goes in the margin below it.
+const (
+	stripTile   = 128
+	stripMargin = 20
+)
+
+// stripFace is one dated crop of a person.
+type stripFace struct {
+	Crop   string    `json:"crop"`
+	Source string    `json:"source"`
+	Taken  time.Time `json:"taken"`
+}
+
+// faceStrips collects the crops of each known person and writes them in
+// order of capture as a "face over time" strip. People are known by the
+// names on their faces (see --takeout); crops without a name or a capture
+// date are left out.
+type faceStrips struct {
+	dir    string
+	mode   os.FileMode
+	people map[string][]stripFace
+}
+
+func newFaceStrips(dir string, mode os.FileMode) (*faceStrips, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create face strip directory: %v", err)
+	}
+	return &faceStrips{dir: dir, mode: mode, people: map[string][]stripFace{}}, nil
+}
+
+func (s *faceStrips) add(r imageResult) {
+	taken, ok := captureTime(r)
+	if !ok {
+		return
+	}
+	for i, b := range r.Boxes {
+		if b.Name == "" || i >= len(r.Crops) {
+			continue
+		}
+		s.people[b.Name] = append(s.people[b.Name], stripFace{Crop: r.Crops[i], Source: r.Input, Taken: taken})
+	}
+}
+
+// write saves a PNG strip per person, named after them, and strips.json
+// listing each person's crops in the same order.
+func (s *faceStrips) write() error {
+	names := make([]string, 0, len(s.people))
+	for name, faces := range s.people {
+		sort.SliceStable(faces, func(i, j int) bool { return faces[i].Taken.Before(faces[j].Taken) })
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(s.dir, slugify(name)+".png")
+		if err := s.writeStrip(path, s.people[name]); err != nil {
+			return fmt.Errorf("failed to write strip for %s: %v", name, err)
+		}
+	}
+	data, err := json.MarshalIndent(s.people, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, "strips.json"), append(data, '\n'), s.mode)
+}
+
+func (s *faceStrips) writeStrip(path string, faces []stripFace) error {
+	strip := image.NewRGBA(image.Rect(0, 0, len(faces)*stripTile, stripTile+stripMargin))
+	draw.Draw(strip, strip.Bounds(), image.White, image.Point{}, draw.Src)
+	for i, f := range faces {
+		crop, err := readWebP(f.Crop)
+		if err != nil {
+			return err
+		}
+		// Fit the crop into its tile, keeping its aspect ratio.
+		b := crop.Bounds()
+		w, h := stripTile, stripTile
+		if b.Dx() > b.Dy() {
+			h = b.Dy() * stripTile / b.Dx()
+		} else {
+			w = b.Dx() * stripTile / b.Dy()
+		}
+		x := i*stripTile + (stripTile-w)/2
+		y := (stripTile - h) / 2
+		draw.CatmullRom.Scale(strip, image.Rect(x, y, x+w, y+h), crop, b, draw.Src, nil)
+		drawText(strip, i*stripTile+4, stripTile+14, f.Taken.Format("2006-01-02"))
+	}
+	return writeAtomic(path, s.mode, func(w io.Writer) error {
+		return png.Encode(w, strip)
+	})
+}
+
+func readWebP(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return webp.Decode(f)
+}
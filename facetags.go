@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	existingFacesSkip  = "skip"
+	existingFacesMerge = "merge"
+)
+
+const (
+	nsRDF    = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsMWGRS  = "http://www.metadataworkinggroup.com/schemas/regions/"
+	nsStArea = "http://ns.adobe.com/xmp/sType/Area#"
+)
+
+func validateExistingFaces(mode string) error {
+	switch mode {
+	case "", existingFacesSkip, existingFacesMerge:
+		return nil
+	}
+	return fmt.Errorf("unknown --existing-faces mode %q (want skip or merge)", mode)
+}
+
+// faceRegion is a tagged face with its top-left corner and size as
+// fractions of the image.
+type faceRegion struct {
+	x, y, w, h float64
+}
+
+func (r faceRegion) rect(width, height int) image.Rectangle {
+	w, h := float64(width), float64(height)
+	return image.Rect(int(r.x*w), int(r.y*h), int((r.x+r.w)*w), int((r.y+r.h)*h))
+}
+
+// existingFaces returns the faces already tagged for an image: MWG regions
+// in its XMP sidecar or embedded XMP, or else Picasa faces in the
+// .picasa.ini of its directory. No tags is not an error.
+func existingFaces(path string) ([]faceRegion, error) {
+	packet, err := os.ReadFile(sidecarPath(path))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		regions, err := xmpFaceRegions(string(packet))
+		if err != nil || len(regions) > 0 {
+			return regions, err
+		}
+	}
+
+	head, err := readJPEGHead(path)
+	if err != nil {
+		return nil, err
+	}
+	if packet, ok := embeddedXMP(head); ok {
+		regions, err := xmpFaceRegions(packet)
+		if err != nil || len(regions) > 0 {
+			return regions, err
+		}
+	}
+
+	return picasaFaces(path)
+}
+
+// xmpFaceRegions reads the face areas of an MWG regions block. Areas may be
+// given as attributes or as elements, and regions of other types (pets,
+// focus points) are left out.
+func xmpFaceRegions(packet string) ([]faceRegion, error) {
+	var regions []faceRegion
+	var inList, inArea bool
+	var kind, field string
+	var area map[string]string
+
+	dec := xml.NewDecoder(strings.NewReader(packet))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return regions, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse XMP: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Space == nsMWGRS && t.Name.Local == "RegionList":
+				inList = true
+			case inList && t.Name.Space == nsRDF && t.Name.Local == "li":
+				kind, area = "", map[string]string{}
+			case t.Name.Space == nsMWGRS && t.Name.Local == "Area":
+				inArea = true
+			case t.Name.Space == nsMWGRS && t.Name.Local == "Type":
+				field = "Type"
+			case inArea && t.Name.Space == nsStArea:
+				field = t.Name.Local
+			}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == nsMWGRS && a.Name.Local == "Type":
+					kind = a.Value
+				case inArea && area != nil && a.Name.Space == nsStArea:
+					area[a.Name.Local] = a.Value
+				}
+			}
+		case xml.CharData:
+			switch {
+			case field == "Type":
+				kind = strings.TrimSpace(string(t))
+			case field != "" && area != nil:
+				area[field] = strings.TrimSpace(string(t))
+			}
+		case xml.EndElement:
+			field = ""
+			switch {
+			case t.Name.Space == nsMWGRS && t.Name.Local == "RegionList":
+				inList = false
+			case t.Name.Space == nsMWGRS && t.Name.Local == "Area":
+				inArea = false
+			case inList && t.Name.Space == nsRDF && t.Name.Local == "li":
+				if r, ok := areaRegion(kind, area); ok {
+					regions = append(regions, r)
+				}
+				area = nil
+			}
+		}
+	}
+}
+
+// areaRegion converts an MWG area, which gives the centre of the box, to a
+// faceRegion.
+func areaRegion(kind string, area map[string]string) (faceRegion, bool) {
+	if (kind != "" && kind != "Face") || area == nil {
+		return faceRegion{}, false
+	}
+	if unit := area["unit"]; unit != "" && unit != "normalized" {
+		return faceRegion{}, false
+	}
+	var v [4]float64
+	for i, k := range []string{"x", "y", "w", "h"} {
+		f, err := strconv.ParseFloat(area[k], 64)
+		if err != nil {
+			return faceRegion{}, false
+		}
+		v[i] = f
+	}
+	if v[2] <= 0 || v[3] <= 0 {
+		return faceRegion{}, false
+	}
+	return faceRegion{x: v[0] - v[2]/2, y: v[1] - v[3]/2, w: v[2], h: v[3]}, true
+}
+
+// picasaFaces reads an image's faces from the .picasa.ini Picasa keeps in
+// each folder, where a section per file lists them as
+// faces=rect64(3f845bcb59418507),8e62398ebda8c1a5;rect64(...),...
+func picasaFaces(path string) ([]faceRegion, error) {
+	f, err := os.Open(filepath.Join(filepath.Dir(path), ".picasa.ini"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	name := filepath.Base(path)
+	var regions []faceRegion
+	inSection := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inSection = strings.EqualFold(line[1:len(line)-1], name)
+			continue
+		}
+		value, ok := strings.CutPrefix(line, "faces=")
+		if !inSection || !ok {
+			continue
+		}
+		for _, face := range strings.Split(value, ";") {
+			rect, _, _ := strings.Cut(face, ",")
+			if r, ok := parseRect64(rect); ok {
+				regions = append(regions, r)
+			}
+		}
+	}
+	return regions, scanner.Err()
+}
+
+// parseRect64 decodes Picasa's rect64(HEX): left, top, right and bottom as
+// 16-bit fractions of the image, with leading zeros dropped.
+func parseRect64(s string) (faceRegion, bool) {
+	hex, ok := strings.CutPrefix(s, "rect64(")
+	if !ok || !strings.HasSuffix(hex, ")") {
+		return faceRegion{}, false
+	}
+	n, err := strconv.ParseUint(strings.TrimSuffix(hex, ")"), 16, 64)
+	if err != nil {
+		return faceRegion{}, false
+	}
+	edge := func(shift uint) float64 { return float64(n>>shift&0xFFFF) / 0xFFFF }
+	left, top, right, bottom := edge(48), edge(32), edge(16), edge(0)
+	if right <= left || bottom <= top {
+		return faceRegion{}, false
+	}
+	return faceRegion{x: left, y: top, w: right - left, h: bottom - top}, true
+}
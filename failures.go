@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type failure struct {
+	path   string
+	reason string
+}
+
+// writeFailures records failed inputs as "path<TAB>reason" lines, the format
+// read back by --retry-from. A run without failures removes a stale report.
+func writeFailures(path string, failures []failure, mode os.FileMode) error {
+	if len(failures) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	var b strings.Builder
+	clean := strings.NewReplacer("\t", " ", "\n", " ")
+	for _, f := range failures {
+		fmt.Fprintf(&b, "%s\t%s\n", f.path, clean.Replace(f.reason))
+	}
+	return writeFileAtomic(path, []byte(b.String()), mode)
+}
+
+// readFailures returns the input paths listed in a failures report.
+func readFailures(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		p, _, _ := strings.Cut(line, "\t")
+		paths = append(paths, p)
+	}
+	return paths, scanner.Err()
+}
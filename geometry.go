@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const mergeIoU = 0.3
+
+// expandFace grows a detected face box to include hair and shoulders,
+// clipped to bounds.
+func expandFace(face, bounds image.Rectangle) image.Rectangle {
+	extraWidth := face.Dx() / 2
+	extraHeightTop := face.Dy() / 2
+	extraHeightBottom := face.Dy()
+	return image.Rect(
+		face.Min.X-extraWidth,
+		face.Min.Y-extraHeightTop,
+		face.Max.X+extraWidth,
+		face.Max.Y+extraHeightBottom,
+	).Intersect(bounds)
+}
+
+const (
+	compositionPadded = "padded"
+	compositionCenter = "center"
+	compositionThirds = "thirds"
+)
+
+func validateComposition(c string) error {
+	switch c {
+	case compositionPadded, compositionCenter, compositionThirds:
+		return nil
+	}
+	return fmt.Errorf("unknown composition %q (want padded, center or thirds)", c)
+}
+
+const (
+	faceOrderLTR  = "ltr"
+	faceOrderTTB  = "ttb"
+	faceOrderSize = "size"
+)
+
+func validateFaceOrder(order string) error {
+	switch order {
+	case faceOrderLTR, faceOrderTTB, faceOrderSize:
+		return nil
+	}
+	return fmt.Errorf("unknown face order %q (want ltr, ttb or size)", order)
+}
+
+// sortFaces orders faces so that crop numbers do not depend on the order a
+// detector happens to report them in: left to right (ltr), top to bottom
+// (ttb) or largest first (size). Faces are compared by their centres. For
+// ltr, faces whose centres are within half a face width of each other form
+// a column, read top to bottom; for ttb, faces within half a face height
+// form a row, read left to right. A face slightly higher than its neighbour
+// in a group photo therefore keeps its place in the row.
+func sortFaces(faces []image.Rectangle, order string) {
+	if order == faceOrderSize {
+		sort.SliceStable(faces, func(i, j int) bool {
+			a, b := faces[i], faces[j]
+			if sa, sb := a.Dx()*a.Dy(), b.Dx()*b.Dy(); sa != sb {
+				return sa > sb
+			}
+			ca, cb := faceCentre(a), faceCentre(b)
+			if ca.X != cb.X {
+				return ca.X < cb.X
+			}
+			return ca.Y < cb.Y
+		})
+		return
+	}
+
+	// line is the centre across the lines (x for columns, y for rows),
+	// along the centre within a line, and size the extent across the lines.
+	type ranked struct {
+		face              image.Rectangle
+		line, along, size int
+		group             int
+	}
+	rs := make([]ranked, len(faces))
+	for i, f := range faces {
+		c := faceCentre(f)
+		rs[i] = ranked{face: f, line: c.X, along: c.Y, size: f.Dx()}
+		if order == faceOrderTTB {
+			rs[i].line, rs[i].along, rs[i].size = c.Y, c.X, f.Dy()
+		}
+	}
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].line < rs[j].line })
+	first := 0
+	for i := 1; i < len(rs); i++ {
+		rs[i].group = rs[i-1].group
+		if rs[i].line-rs[first].line > max(rs[first].size, rs[i].size)/2 {
+			rs[i].group++
+			first = i
+		}
+	}
+	sort.SliceStable(rs, func(i, j int) bool {
+		a, b := rs[i], rs[j]
+		if a.group != b.group {
+			return a.group < b.group
+		}
+		if a.along != b.along {
+			return a.along < b.along
+		}
+		return a.line < b.line
+	})
+	for i, r := range rs {
+		faces[i] = r.face
+	}
+}
+
+func faceCentre(r image.Rectangle) image.Point {
+	return r.Min.Add(r.Max).Div(2)
+}
+
+// composeCrop returns the crop window for a face. "padded" is the classic
+// expandFace box; "center" and "thirds" use a window of the same size that is
+// shifted rather than clipped at the image edges, with either the face
+// centered or the estimated eye line on the upper third.
+func composeCrop(face, bounds image.Rectangle, composition string) image.Rectangle {
+	if composition == compositionPadded {
+		return expandFace(face, bounds)
+	}
+
+	w, h := min(face.Dx()*2, bounds.Dx()), min(face.Dy()*3, bounds.Dy())
+	cx := (face.Min.X + face.Max.X) / 2
+	top := (face.Min.Y+face.Max.Y)/2 - h/2
+	if composition == compositionThirds {
+		eyeLine := face.Min.Y + face.Dy()*2/5
+		top = eyeLine - h/3
+	}
+
+	x := clamp(cx-w/2, bounds.Min.X, bounds.Max.X-w)
+	y := clamp(top, bounds.Min.Y, bounds.Max.Y-h)
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// groupCropRect is the region holding every subject, grown by padding (a
+// fraction of its size) on each side and clipped to bounds.
+func groupCropRect(subjects []image.Rectangle, bounds image.Rectangle, padding float64) image.Rectangle {
+	u := unionRect(subjects)
+	padX := int(float64(u.Dx()) * padding)
+	padY := int(float64(u.Dy()) * padding)
+	return image.Rect(u.Min.X-padX, u.Min.Y-padY, u.Max.X+padX, u.Max.Y+padY).Intersect(bounds)
+}
+
+func parseSize(s string) (image.Point, error) {
+	w, h, ok := strings.Cut(strings.ToLower(s), "x")
+	if !ok {
+		return image.Point{}, fmt.Errorf("invalid size %q (want WIDTHxHEIGHT)", s)
+	}
+	width, err := strconv.Atoi(w)
+	if err != nil || width <= 0 {
+		return image.Point{}, fmt.Errorf("invalid width in size %q", s)
+	}
+	height, err := strconv.Atoi(h)
+	if err != nil || height <= 0 {
+		return image.Point{}, fmt.Errorf("invalid height in size %q", s)
+	}
+	return image.Pt(width, height), nil
+}
+
+func unionRect(rects []image.Rectangle) image.Rectangle {
+	var u image.Rectangle
+	for _, r := range rects {
+		u = u.Union(r)
+	}
+	return u
+}
+
+func largestRect(rects []image.Rectangle) image.Rectangle {
+	var best image.Rectangle
+	for _, r := range rects {
+		if r.Dx()*r.Dy() > best.Dx()*best.Dy() {
+			best = r
+		}
+	}
+	return best
+}
+
+func clamp(v, lo, hi int) int {
+	return max(lo, min(v, hi))
+}
+
+// smartCropRect picks the largest window with the target aspect ratio that
+// fits in bounds and keeps the subjects in frame: all of them when they fit,
+// otherwise the largest one. Subjects are centered horizontally and placed on
+// the upper third line vertically.
+func smartCropRect(bounds image.Rectangle, subjects []image.Rectangle, target image.Point) image.Rectangle {
+	aspect := float64(target.X) / float64(target.Y)
+	w := min(bounds.Dx(), int(float64(bounds.Dy())*aspect))
+	h := min(bounds.Dy(), int(float64(w)/aspect))
+
+	focus := unionRect(subjects)
+	if focus.Dx() > w || focus.Dy() > h {
+		focus = largestRect(subjects)
+	}
+
+	cx := (focus.Min.X + focus.Max.X) / 2
+	cy := (focus.Min.Y + focus.Max.Y) / 2
+	x := clamp(cx-w/2, bounds.Min.X, bounds.Max.X-w)
+	y := clamp(cy-h/3, bounds.Min.Y, bounds.Max.Y-h)
+	// Never cut into the focus region when the window can hold it.
+	if focus.Dy() <= h {
+		y = clamp(y, focus.Max.Y-h, focus.Min.Y)
+		y = clamp(y, bounds.Min.Y, bounds.Max.Y-h)
+	}
+	return image.Rect(x, y, x+w, y+h)
+}
+
+func scaleRect(r image.Rectangle, sx, sy float64) image.Rectangle {
+	return image.Rect(
+		int(float64(r.Min.X)*sx),
+		int(float64(r.Min.Y)*sy),
+		int(float64(r.Max.X)*sx),
+		int(float64(r.Max.Y)*sy),
+	)
+}
+
+func iou(a, b image.Rectangle) float64 {
+	inter := a.Intersect(b)
+	if inter.Empty() {
+		return 0
+	}
+	interArea := inter.Dx() * inter.Dy()
+	union := a.Dx()*a.Dy() + b.Dx()*b.Dy() - interArea
+	return float64(interArea) / float64(union)
+}
+
+// mergeDetections drops boxes that overlap an earlier one, so faces found by
+// several cascades or models are only reported once.
+func mergeDetections(faces []image.Rectangle) []image.Rectangle {
+	var merged []image.Rectangle
+	for _, face := range faces {
+		duplicate := false
+		for _, kept := range merged {
+			if iou(face, kept) > mergeIoU {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			merged = append(merged, face)
+		}
+	}
+	return merged
+}
+
+// roiSpec is the --roi value: x, y, width and height of the region faces
+// are looked for in, each in pixels of the original image or, with a %
+// suffix, a percentage of its width or height.
+type roiSpec struct {
+	values  [4]float64
+	percent [4]bool
+	set     bool
+}
+
+func (r *roiSpec) String() string {
+	if !r.set {
+		return ""
+	}
+	parts := make([]string, 4)
+	for i, v := range r.values {
+		parts[i] = strconv.FormatFloat(v, 'g', -1, 64)
+		if r.percent[i] {
+			parts[i] += "%"
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *roiSpec) Set(v string) error {
+	if v == "" {
+		*r = roiSpec{}
+		return nil
+	}
+	parts := strings.Split(v, ",")
+	if len(parts) != 4 {
+		return fmt.Errorf("invalid region %q (want x,y,w,h)", v)
+	}
+	var spec roiSpec
+	for i, p := range parts {
+		p = strings.TrimSpace(p)
+		p, spec.percent[i] = strings.CutSuffix(p, "%")
+		n, err := strconv.ParseFloat(p, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid region %q (want x,y,w,h)", v)
+		}
+		spec.values[i] = n
+	}
+	if spec.values[2] == 0 || spec.values[3] == 0 {
+		return fmt.Errorf("invalid region %q: width and height must be positive", v)
+	}
+	spec.set = true
+	*r = spec
+	return nil
+}
+
+// rect resolves the region for an image of w x h pixels, clipped to it.
+func (r *roiSpec) rect(w, h int) image.Rectangle {
+	size := [4]int{w, h, w, h}
+	var v [4]int
+	for i := range v {
+		if r.percent[i] {
+			v[i] = int(r.values[i] * float64(size[i]) / 100)
+		} else {
+			v[i] = int(r.values[i])
+		}
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]).Intersect(image.Rect(0, 0, w, h))
+}
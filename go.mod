@@ -3,7 +3,27 @@ module wepp-image
 go 1.23.0
 
 require (
+	github.com/HugoSmits86/nativewebp v1.3.0
+	github.com/Zavr22/face-detector/facedetect v0.0.0
 	github.com/chai2010/webp v1.1.1
+	github.com/davidbyttow/govips/v2 v2.15.0
+	github.com/eclipse/paho.mqtt.golang v1.5.0
+	github.com/esimov/pigo v1.4.6
+	github.com/gorilla/websocket v1.5.3
+	github.com/mattn/go-sqlite3 v1.14.24
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
+	github.com/yalue/onnxruntime_go v1.36.0
+	go.etcd.io/bbolt v1.3.11
 	gocv.io/x/gocv v0.39.0
+	golang.org/x/image v0.24.0
+	golang.org/x/sync v0.11.0
+	golang.org/x/time v0.5.0
 )
+
+require (
+	golang.org/x/net v0.27.0 // indirect
+	golang.org/x/sys v0.22.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+)
+
+replace github.com/Zavr22/face-detector/facedetect => ./facedetect
@@ -0,0 +1,131 @@
+//go:build !purego
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	oversizeReject    = "reject"
+	oversizeDownscale = "downscale"
+)
+
+// errLimit marks inputs rejected by the configured limits.
+var errLimit = errors.New("input exceeds the configured limits")
+
+type inputLimits struct {
+	maxFileSize   int64
+	maxMegapixels float64
+	maxDimension  int
+	oversize      string
+}
+
+func validateOversizePolicy(policy string) error {
+	switch policy {
+	case oversizeReject, oversizeDownscale:
+		return nil
+	}
+	return fmt.Errorf("unknown oversize policy %q (want reject or downscale)", policy)
+}
+
+// parseByteSize parses sizes such as "512", "64K", "200MB" or "1GiB" using
+// binary multiples.
+func parseByteSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return v * mult, nil
+}
+
+// checkInput enforces the configured limits before the image is decoded and
+// returns the IMRead flag to use.
+func checkInput(path string, limits *inputLimits) (gocv.IMReadFlag, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return checkHeader(f, info.Size(), limits)
+}
+
+// checkHeader applies the limits to an encoded image of the given size.
+// Dimensions come from the header, so a decompression bomb is caught without
+// allocating its pixels. Oversized JPEGs can be decoded at 1/2, 1/4 or 1/8
+// scale instead of being rejected.
+func checkHeader(r io.Reader, size int64, limits *inputLimits) (gocv.IMReadFlag, error) {
+	if limits.maxFileSize > 0 && size > limits.maxFileSize {
+		return 0, fmt.Errorf("%w: file is %d bytes, limit is %d", errLimit, size, limits.maxFileSize)
+	}
+
+	cfg, format, err := image.DecodeConfig(r)
+	if errors.Is(err, image.ErrFormat) {
+		// Formats only OpenCV understands are left to its own pixel limit.
+		return gocv.IMReadColor, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid header: %v", errUnreadable, err)
+	}
+
+	ratio := 1.0
+	if limits.maxMegapixels > 0 {
+		mp := float64(cfg.Width) * float64(cfg.Height) / 1e6
+		ratio = max(ratio, mp/limits.maxMegapixels)
+	}
+	if limits.maxDimension > 0 {
+		side := float64(max(cfg.Width, cfg.Height)) / float64(limits.maxDimension)
+		ratio = max(ratio, side*side)
+	}
+	if ratio <= 1 {
+		return gocv.IMReadColor, nil
+	}
+
+	tooBig := fmt.Errorf("%w: image is %dx%d", errLimit, cfg.Width, cfg.Height)
+	if limits.oversize != oversizeDownscale || format != "jpeg" {
+		return 0, tooBig
+	}
+	// ratio is an area ratio; the reduced modes shrink each side.
+	switch {
+	case ratio <= 4:
+		return gocv.IMReadReducedColor2, nil
+	case ratio <= 16:
+		return gocv.IMReadReducedColor4, nil
+	case ratio <= 64:
+		return gocv.IMReadReducedColor8, nil
+	}
+	return 0, tooBig
+}
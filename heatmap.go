@@ -0,0 +1,74 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+)
+
+const heatmapSize = 512
+
+// heatmap accumulates where faces fall across a dataset. Boxes are mapped
+// into a square grid in coordinates relative to their image, so images of
+// any size and aspect ratio add up in the same frame.
+type heatmap struct {
+	grid []float64
+}
+
+func newHeatmap() *heatmap {
+	return &heatmap{grid: make([]float64, heatmapSize*heatmapSize)}
+}
+
+func (h *heatmap) add(r imageResult) {
+	if r.Width <= 0 || r.Height <= 0 {
+		return
+	}
+	for _, b := range r.Boxes {
+		x0 := b.X * heatmapSize / r.Width
+		y0 := b.Y * heatmapSize / r.Height
+		x1 := (b.X + b.W) * heatmapSize / r.Width
+		y1 := (b.Y + b.H) * heatmapSize / r.Height
+		for y := max(0, y0); y < min(heatmapSize, y1); y++ {
+			for x := max(0, x0); x < min(heatmapSize, x1); x++ {
+				h.grid[y*heatmapSize+x]++
+			}
+		}
+	}
+}
+
+// image renders the counts, scaled to the busiest cell, from dark blue
+// through green to red.
+func (h *heatmap) image() *image.RGBA {
+	peak := 0.0
+	for _, v := range h.grid {
+		peak = max(peak, v)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, heatmapSize, heatmapSize))
+	for i, v := range h.grid {
+		t := 0.0
+		if peak > 0 {
+			t = v / peak
+		}
+		img.Set(i%heatmapSize, i/heatmapSize, heatColor(t))
+	}
+	return img
+}
+
+// heatColor maps t in [0, 1] onto the classic "jet" colour scale.
+func heatColor(t float64) color.RGBA {
+	channel := func(offset float64) uint8 {
+		v := 1.5 - math.Abs(4*t-offset)
+		return uint8(255 * min(1, max(0, v)))
+	}
+	return color.RGBA{R: channel(3), G: channel(2), B: channel(1), A: 255}
+}
+
+func (h *heatmap) write(path string, mode os.FileMode) error {
+	img := h.image()
+	return writeAtomic(path, mode, func(w io.Writer) error {
+		return png.Encode(w, img)
+	})
+}
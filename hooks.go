@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// hookEvent is written as JSON to the stdin of --pre-hook and --post-hook
+// commands. The input path is also exported as FACE_DETECTOR_INPUT.
+type hookEvent struct {
+	Stage  string       `json:"stage"`
+	Input  string       `json:"input"`
+	Result *imageResult `json:"result,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+func runHook(command string, event hookEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "FACE_DETECTOR_INPUT="+event.Input)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%q: %v", command, err)
+	}
+	return nil
+}
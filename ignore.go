@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"strings"
+)
+
+// roiList is a repeatable --ignore-rect value.
+type roiList []roiSpec
+
+func (l *roiList) String() string {
+	parts := make([]string, len(*l))
+	for i := range *l {
+		parts[i] = (*l)[i].String()
+	}
+	return strings.Join(parts, " ")
+}
+
+func (l *roiList) Set(v string) error {
+	var r roiSpec
+	if err := r.Set(v); err != nil {
+		return err
+	}
+	*l = append(*l, r)
+	return nil
+}
+
+// ignoreMask is the --ignore-mask value: an image covering the frame,
+// scaled to each input, whose non-black pixels mark where faces are
+// ignored.
+type ignoreMask struct {
+	path string
+	gray *image.Gray
+}
+
+func (m *ignoreMask) String() string {
+	return m.path
+}
+
+func (m *ignoreMask) Set(v string) error {
+	if v == "" {
+		*m = ignoreMask{}
+		return nil
+	}
+	f, err := os.Open(v)
+	if err != nil {
+		return fmt.Errorf("failed to open mask: %v", err)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("failed to decode mask %s: %v", v, err)
+	}
+	*m = ignoreMask{path: v, gray: grayImage(img)}
+	return nil
+}
+
+// ignoreZones are where detections are discarded, such as a poster on the
+// wall behind a kiosk camera.
+type ignoreZones struct {
+	rects roiList
+	mask  ignoreMask
+}
+
+func (z *ignoreZones) empty() bool {
+	return len(z.rects) == 0 && z.mask.gray == nil
+}
+
+// keep drops the faces whose centre lies in a zone. Faces are in pixels of
+// an image that is the w x h original scaled by sx, sy.
+func (z *ignoreZones) keep(faces []image.Rectangle, w, h int, sx, sy float64) []image.Rectangle {
+	if z.empty() {
+		return faces
+	}
+	zones := make([]image.Rectangle, len(z.rects))
+	for i := range z.rects {
+		zones[i] = z.rects[i].rect(w, h)
+	}
+	kept := faces[:0]
+	for _, f := range faces {
+		c := image.Pt(int(float64(f.Min.X+f.Max.X)/2/sx), int(float64(f.Min.Y+f.Max.Y)/2/sy))
+		if !z.covers(zones, c, w, h) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+func (z *ignoreZones) covers(zones []image.Rectangle, p image.Point, w, h int) bool {
+	for _, zone := range zones {
+		if p.In(zone) {
+			return true
+		}
+	}
+	if m := z.mask.gray; m != nil {
+		mx := p.X * m.Rect.Dx() / max(w, 1)
+		my := p.Y * m.Rect.Dy() / max(h, 1)
+		return image.Pt(mx, my).In(image.Rect(0, 0, m.Rect.Dx(), m.Rect.Dy())) && m.GrayAt(mx, my).Y > 0
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// readFileList reads input paths from a file, or from stdin when path is
+// "-", one per line or NUL-separated as written by find -print0. Lists with
+// a NUL anywhere are taken to be NUL-separated, so paths may then contain
+// newlines. Directories are skipped, as in directory mode.
+func readFileList(path string) ([]string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []string
+	if bytes.IndexByte(data, 0) >= 0 {
+		entries = strings.Split(string(data), "\x00")
+	} else {
+		entries = strings.Split(string(data), "\n")
+		for i, e := range entries {
+			entries[i] = strings.TrimSuffix(e, "\r")
+		}
+	}
+
+	var paths []string
+	for _, e := range entries {
+		if e == "" {
+			continue
+		}
+		if info, err := os.Stat(e); err == nil && info.IsDir() {
+			continue
+		}
+		paths = append(paths, e)
+	}
+	return paths, nil
+}
+
+// expandPatterns turns positional arguments into input paths. Each is a
+// file, a directory (its files, as in directory mode), or a glob pattern in
+// which ** matches any number of directories. Duplicates are dropped and a
+// pattern matching nothing is an error, as a mistyped path would be.
+func expandPatterns(patterns []string) ([]string, error) {
+	var paths []string
+	seen := map[string]bool{}
+	for _, p := range patterns {
+		matches, err := expandPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", p)
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				paths = append(paths, m)
+			}
+		}
+	}
+	return paths, nil
+}
+
+func expandPattern(pattern string) ([]string, error) {
+	if !hasMeta(pattern) {
+		info, err := os.Stat(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return []string{pattern}, nil
+		}
+		entries, err := os.ReadDir(pattern)
+		if err != nil {
+			return nil, err
+		}
+		var paths []string
+		for _, e := range entries {
+			if !e.IsDir() {
+				paths = append(paths, filepath.Join(pattern, e.Name()))
+			}
+		}
+		return paths, nil
+	}
+
+	// Walk from the deepest directory without wildcards and match the rest
+	// of the pattern segment by segment.
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+	fixed := 0
+	for fixed < len(segments) && !hasMeta(segments[fixed]) {
+		fixed++
+	}
+	root := strings.Join(segments[:fixed], "/")
+	if root == "" && fixed > 0 {
+		root = "/"
+	}
+	if fixed == 0 {
+		root = "."
+	}
+	rest := segments[fixed:]
+
+	var paths []string
+	err := filepath.WalkDir(filepath.FromSlash(root), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(filepath.FromSlash(root), p)
+		if err != nil {
+			return err
+		}
+		if matchSegments(rest, strings.Split(filepath.ToSlash(rel), "/")) {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return paths, err
+}
+
+func hasMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// matchSegments matches path segments against pattern segments, where a
+// "**" segment stands for zero or more path segments.
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	ok, err := path.Match(pattern[0], segments[0])
+	return err == nil && ok && matchSegments(pattern[1:], segments[1:])
+}
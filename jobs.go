@@ -0,0 +1,370 @@
+//go:build !purego
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// job is an asynchronous batch over a directory below --input-dir or an
+// uploaded zip archive. Outputs go to <output-dir>/jobs/<id>.
+type job struct {
+	ID       string        `json:"id"`
+	Status   string        `json:"status"`
+	Input    string        `json:"input"`
+	Output   string        `json:"output"`
+	Progress imageProgress `json:"progress"`
+	Summary  *batchSummary `json:"summary,omitempty"`
+	Error    string        `json:"error,omitempty"`
+
+	archive string
+	events  *broadcaster
+}
+
+// jobRecord is a job as stored, including the pending upload.
+type jobRecord struct {
+	job
+	Archive string `json:"archive,omitempty"`
+}
+
+// jobManager runs submitted jobs one at a time, in order, keeping their
+// state in store.
+type jobManager struct {
+	opts  *options
+	det   *detectors
+	store *jobStore
+	queue chan *job
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newJobManager(opts *options, det *detectors, store *jobStore) *jobManager {
+	return &jobManager{opts: opts, det: det, store: store, queue: make(chan *job, 100), jobs: map[string]*job{}}
+}
+
+// restore loads stored jobs. Jobs that were queued or interrupted while
+// running are started again from the beginning.
+func (m *jobManager) restore() ([]*job, error) {
+	var pending []*job
+	err := m.store.each(func(data []byte) error {
+		var r jobRecord
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		j := &r.job
+		j.archive = r.Archive
+		j.events = newBroadcaster()
+		if j.Status == jobQueued || j.Status == jobRunning {
+			j.Status = jobQueued
+			j.Progress = imageProgress{}
+			pending = append(pending, j)
+		}
+		m.jobs[j.ID] = j
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load jobs: %v", err)
+	}
+	return pending, nil
+}
+
+// save writes j to the store; the caller holds m.mu.
+func (m *jobManager) save(j *job) {
+	if err := m.store.put(j.ID, jobRecord{job: *j, Archive: j.archive}); err != nil {
+		fmt.Printf("Error saving job %s: %v\n", j.ID, err)
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// snapshot returns a copy of the job's public state.
+func (m *jobManager) snapshot(id string) (job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+func (m *jobManager) update(j *job, change func(*job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	change(j)
+	m.save(j)
+}
+
+func (m *jobManager) submit(j *job) error {
+	select {
+	case m.queue <- j:
+	default:
+		return errors.New("job queue is full")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[j.ID] = j
+	m.save(j)
+	return nil
+}
+
+// run processes restored and newly queued jobs until ctx is cancelled.
+func (m *jobManager) run(ctx context.Context, pending []*job) {
+	for _, j := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+		m.runJob(ctx, j)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-m.queue:
+			m.runJob(ctx, j)
+		}
+	}
+}
+
+func (m *jobManager) runJob(ctx context.Context, j *job) {
+	m.update(j, func(j *job) { j.Status = jobRunning })
+	m.publish(j, "status")
+
+	opts := *m.opts
+	opts.inputDir = j.Input
+	opts.outputDir = j.Output
+	opts.failuresFile = filepath.Join(j.Output, "failures.txt")
+	opts.retryFrom = ""
+
+	summary, err := func() (batchSummary, error) {
+		// The archive is kept until the job finishes, so that a job
+		// interrupted by a restart extracts it afresh.
+		if j.archive != "" {
+			opts.inputDir = filepath.Join(j.Output, "input")
+			if err := os.RemoveAll(opts.inputDir); err != nil {
+				return batchSummary{}, err
+			}
+			if err := extractZip(j.archive, opts.inputDir, opts.limits.maxFileSize, opts.maxExtractSize); err != nil {
+				return batchSummary{}, err
+			}
+		}
+		return runBatch(ctx, &opts, m.det, func(p imageProgress) {
+			m.update(j, func(j *job) { j.Progress = p })
+			m.publish(j, "progress")
+		})
+	}()
+
+	if errors.Is(err, errInterrupted) {
+		// Shutting down: leave the job running so restore picks it up.
+		return
+	}
+	archive := j.archive
+	m.update(j, func(j *job) {
+		j.Summary = &summary
+		j.Status = jobDone
+		j.archive = ""
+		if err != nil {
+			j.Status = jobFailed
+			j.Error = err.Error()
+		}
+	})
+	if archive != "" {
+		os.Remove(archive)
+	}
+	m.publish(j, "summary")
+}
+
+// handleGet returns a job's state and, once it has finished, the names of
+// its output files, which can be fetched from /jobs/{id}/outputs/{name}.
+func (m *jobManager) handleGet(w http.ResponseWriter, r *http.Request) {
+	s, ok := m.snapshot(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{"no such job"})
+		return
+	}
+	resp := struct {
+		job
+		Outputs []string `json:"outputs,omitempty"`
+	}{job: s}
+	if s.Status == jobDone || s.Status == jobFailed {
+		entries, _ := os.ReadDir(s.Output)
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				resp.Outputs = append(resp.Outputs, e.Name())
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleOutput serves one output file of a job.
+func (m *jobManager) handleOutput(w http.ResponseWriter, r *http.Request) {
+	s, ok := m.snapshot(r.PathValue("id"))
+	name := r.PathValue("name")
+	if !ok || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		writeJSON(w, http.StatusNotFound, errorResponse{"no such output"})
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(s.Output, name))
+}
+
+func (m *jobManager) publish(j *job, event string) {
+	s, _ := m.snapshot(j.ID)
+	data, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	j.events.publish([]byte("event: " + event + "\ndata: " + string(data) + "\n\n"))
+}
+
+// handleSubmit starts a job. The body is either a zip archive
+// (Content-Type application/zip) or JSON {"dir": "..."} naming a directory
+// relative to --input-dir.
+func (m *jobManager) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	j := &job{ID: newJobID(), Status: jobQueued, events: newBroadcaster()}
+	j.Output = filepath.Join(m.opts.outputDir, "jobs", j.ID)
+	if err := os.MkdirAll(j.Output, os.ModePerm); err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/zip") {
+		j.archive = filepath.Join(j.Output, "input.zip")
+		if m.opts.maxUploadSize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, m.opts.maxUploadSize)
+		}
+		if err := saveUpload(r.Body, j.archive); err != nil {
+			os.RemoveAll(j.Output)
+			status := http.StatusBadRequest
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			writeJSON(w, status, errorResponse{err.Error()})
+			return
+		}
+		j.Input = "upload.zip"
+	} else {
+		var req struct {
+			Dir string `json:"dir"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			os.RemoveAll(j.Output)
+			writeJSON(w, http.StatusBadRequest, errorResponse{"expected a zip archive or {\"dir\": ...}"})
+			return
+		}
+		// Rooting the path keeps requests inside --input-dir.
+		j.Input = filepath.Join(m.opts.inputDir, filepath.Clean("/"+req.Dir))
+		if info, err := os.Stat(j.Input); err != nil || !info.IsDir() {
+			os.RemoveAll(j.Output)
+			writeJSON(w, http.StatusBadRequest, errorResponse{fmt.Sprintf("no such directory: %s", req.Dir)})
+			return
+		}
+	}
+
+	if err := m.submit(j); err != nil {
+		os.RemoveAll(j.Output)
+		w.Header().Set("Retry-After", "60")
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{err.Error()})
+		return
+	}
+	s, _ := m.snapshot(j.ID)
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	writeJSON(w, http.StatusAccepted, s)
+}
+
+func saveUpload(body io.Reader, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+	return f.Close()
+}
+
+// handleEvents streams a job's progress as server-sent events: the current
+// state first ("status"), a "progress" event per image and a final
+// "summary", after which the stream ends.
+func (m *jobManager) handleEvents(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	j, ok := m.jobs[r.PathValue("id")]
+	m.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{"no such job"})
+		return
+	}
+
+	// Subscribe before reading the state so no event falls in between.
+	events, unsubscribe := j.events.subscribe()
+	defer unsubscribe()
+	s, _ := m.snapshot(j.ID)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	send := func(msg []byte) bool {
+		if _, err := w.Write(msg); err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	}
+
+	data, _ := json.Marshal(s)
+	if s.Status == jobDone || s.Status == jobFailed {
+		send([]byte("event: summary\ndata: " + string(data) + "\n\n"))
+		return
+	}
+	if !send([]byte("event: status\ndata: " + string(data) + "\n\n")) {
+		return
+	}
+	// Slow readers may miss events, so the job state is also polled to make
+	// sure the stream still ends with the summary.
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-events:
+			if !send(msg) || strings.HasPrefix(string(msg), "event: summary") {
+				return
+			}
+		case <-tick.C:
+			if s, _ := m.snapshot(j.ID); s.Status == jobDone || s.Status == jobFailed {
+				data, _ := json.Marshal(s)
+				send([]byte("event: summary\ndata: " + string(data) + "\n\n"))
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+//go:build !purego
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var jobsBucket = []byte("jobs")
+
+// jobStore persists jobs in a bbolt database so they survive restarts.
+type jobStore struct {
+	db *bolt.DB
+}
+
+func openJobStore(path string) (*jobStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job database: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open job database: %v", err)
+	}
+	return &jobStore{db: db}, nil
+}
+
+func (s *jobStore) put(id string, record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(id), data)
+	})
+}
+
+// each calls fn with the stored JSON of every job.
+func (s *jobStore) each(fn func(data []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(_, v []byte) error {
+			return fn(v)
+		})
+	})
+}
+
+func (s *jobStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// WebP has no place for IPTC-IIM, so keywords are written as XMP dc:subject,
+// the IPTC Core keywords property that DAMs read.
+
+// imageKeywords are the keywords for an output showing all of r's faces:
+// faces:N and the names of the people in it.
+func imageKeywords(r imageResult) []string {
+	keywords := []string{fmt.Sprintf("faces:%d", r.Faces)}
+	seen := map[string]bool{}
+	for _, b := range r.Boxes {
+		if b.Name != "" && !seen[b.Name] {
+			seen[b.Name] = true
+			keywords = append(keywords, b.Name)
+		}
+	}
+	return keywords
+}
+
+// tagOutputs writes keywords into r's output images. Crops are tagged with
+// the name of their face, when known.
+func tagOutputs(r imageResult, perm os.FileMode) error {
+	keywords := imageKeywords(r)
+	for _, p := range []string{r.Annotated, r.SmartCrop, r.GroupCrop} {
+		if p == "" {
+			continue
+		}
+		if err := embedWebPXMP(p, keywordPacket(keywords), perm); err != nil {
+			return err
+		}
+	}
+	for i, p := range r.Crops {
+		if i >= len(r.Boxes) || r.Boxes[i].Name == "" {
+			continue
+		}
+		if err := embedWebPXMP(p, keywordPacket([]string{"faces:1", r.Boxes[i].Name}), perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func keywordPacket(keywords []string) string {
+	var b strings.Builder
+	b.WriteString(`<?xpacket begin="` + "\uFEFF" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">
+   <dc:subject>
+    <rdf:Bag>
+`)
+	for _, k := range keywords {
+		fmt.Fprintf(&b, "     <rdf:li>%s</rdf:li>\n", xmlEscape(k))
+	}
+	b.WriteString(`    </rdf:Bag>
+   </dc:subject>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>
+`)
+	return b.String()
+}
+
+// webpXMPFlag marks a VP8X header as followed by an XMP chunk.
+const webpXMPFlag = 0x04
+
+// embedWebPXMP stores an XMP packet in a WebP file, replacing any earlier
+// one. Simple (VP8 or VP8L only) files are converted to the extended format,
+// which is the only one that carries metadata.
+func embedWebPXMP(path, packet string, perm os.FileMode) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return errors.New(path + " is not a WebP file")
+	}
+
+	var chunks [][]byte
+	for pos := 12; pos+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size + size&1
+		if end > len(data) {
+			end = len(data)
+		}
+		if string(data[pos:pos+4]) != "XMP " {
+			chunks = append(chunks, data[pos:end])
+		}
+		pos = end
+	}
+	if len(chunks) == 0 {
+		return errors.New(path + " has no image data")
+	}
+
+	if string(chunks[0][:4]) != "VP8X" {
+		header, err := vp8xHeader(chunks[0])
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		chunks = append([][]byte{header}, chunks...)
+	} else {
+		chunks[0] = bytes.Clone(chunks[0])
+	}
+	chunks[0][8] |= webpXMPFlag
+	chunks = append(chunks, riffChunk("XMP ", []byte(packet)))
+
+	var out bytes.Buffer
+	out.WriteString("RIFF\x00\x00\x00\x00WEBP")
+	for _, c := range chunks {
+		out.Write(c)
+	}
+	buf := out.Bytes()
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(buf)-8))
+	return writeFileAtomic(path, buf, perm)
+}
+
+func riffChunk(fourCC string, payload []byte) []byte {
+	c := make([]byte, 8, 8+len(payload)+1)
+	copy(c, fourCC)
+	binary.LittleEndian.PutUint32(c[4:], uint32(len(payload)))
+	c = append(c, payload...)
+	if len(payload)%2 == 1 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+// vp8xHeader builds the extended header for a simple file from the canvas
+// size in its image chunk. The alpha flag is left clear: a lossless image
+// carries its own alpha, and Go's decoder rejects VP8L behind the flag.
+func vp8xHeader(image []byte) ([]byte, error) {
+	var width, height int
+	body := image[8:]
+	switch string(image[:4]) {
+	case "VP8L":
+		if len(body) < 5 || body[0] != 0x2F {
+			return nil, errors.New("invalid VP8L header")
+		}
+		bits := binary.LittleEndian.Uint32(body[1:])
+		width = int(bits&0x3FFF) + 1
+		height = int(bits>>14&0x3FFF) + 1
+	case "VP8 ":
+		if len(body) < 10 || body[3] != 0x9D || body[4] != 0x01 || body[5] != 0x2A {
+			return nil, errors.New("invalid VP8 header")
+		}
+		width = int(binary.LittleEndian.Uint16(body[6:]) & 0x3FFF)
+		height = int(binary.LittleEndian.Uint16(body[8:]) & 0x3FFF)
+	default:
+		return nil, fmt.Errorf("unexpected chunk %q", image[:4])
+	}
+
+	payload := make([]byte, 10)
+	putUint24(payload[4:], width-1)
+	putUint24(payload[7:], height-1)
+	return riffChunk("VP8X", payload), nil
+}
+
+func putUint24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
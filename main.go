@@ -1,150 +1,1563 @@
+//go:build !purego
+
 package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
 	_ "encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
 	"image/color"
+	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
 
+	"github.com/Zavr22/face-detector/facedetect"
 	"github.com/chai2010/webp"
 	"github.com/nfnt/resize"
 	"gocv.io/x/gocv"
+	"golang.org/x/sync/errgroup"
 )
 
 func resizeImage(img image.Image, maxWidth, maxHeight uint) image.Image {
 	return resize.Thumbnail(maxWidth, maxHeight, img, resize.Lanczos3)
 }
 
-func saveAsWebP(img image.Image, outputPath string) error {
-	var buf bytes.Buffer
-	if err := webp.Encode(&buf, img, &webp.Options{Lossless: true}); err != nil {
-		return fmt.Errorf("failed to encode image to WebP: %v", err)
-	}
-	return ioutil.WriteFile(outputPath, buf.Bytes(), 0644)
+func saveAsWebP(img image.Image, outputPath string, mode os.FileMode) error {
+	return writeAtomic(outputPath, mode, func(w io.Writer) error {
+		if err := webp.Encode(w, img, &webp.Options{Lossless: true}); err != nil {
+			return fmt.Errorf("failed to encode image to WebP: %v", err)
+		}
+		return nil
+	})
 }
 
-func saveMatAsWebP(mat gocv.Mat, outputPath string) error {
-	img, err := mat.ToImage()
+func saveMatAsWebP(mat gocv.Mat, outputPath string, mode os.FileMode) error {
+	scratch := getMat()
+	defer putMat(scratch)
+	img, err := matImage(mat, &scratch)
 	if err != nil {
 		return fmt.Errorf("failed to convert Mat to Image: %v", err)
 	}
-	return saveAsWebP(img, outputPath)
+	return saveAsWebP(img, outputPath, mode)
 }
 
-func cropAndSaveFace(img gocv.Mat, face image.Rectangle, index int, outputDir, baseFilename string) error {
-	extraWidth := face.Dx() / 2
-	extraHeightTop := face.Dy() / 2
-	extraHeightBottom := face.Dy()
-	cropRect := image.Rect(
-		max(0, face.Min.X-extraWidth),
-		max(0, face.Min.Y-extraHeightTop),
-		min(img.Cols(), face.Max.X+extraWidth),
-		min(img.Rows(), face.Max.Y+extraHeightBottom),
-	)
+// saveCrop writes a face crop, or any other image showing faces, to
+// outputPath, or encrypted to outputPath with encSuffix appended when
+// --encrypt-key or --encrypt-to is set, and returns the path written.
+func saveCrop(mat gocv.Mat, outputPath string, opts *options) (string, error) {
+	if opts.sealer == nil {
+		return outputPath, saveMatAsWebP(mat, outputPath, opts.fileMode)
+	}
+	scratch := getMat()
+	defer putMat(scratch)
+	img, err := matImage(mat, &scratch)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert Mat to Image: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, img, &webp.Options{Lossless: true}); err != nil {
+		return "", fmt.Errorf("failed to encode image to WebP: %v", err)
+	}
+	data, err := opts.sealer.seal(buf.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt crop: %v", err)
+	}
+	outputPath += encSuffix
+	return outputPath, writeFileAtomic(outputPath, data, opts.fileMode)
+}
 
-	croppedImg := img.Region(cropRect)
+func cropAndSaveFace(img gocv.Mat, cropRect image.Rectangle, index int, opts *options, baseFilename string) (string, error) {
+	region := img.Region(cropRect)
+	defer region.Close()
+	croppedImg := prepareCrop(region, opts)
 	defer croppedImg.Close()
 
-	outputPath := filepath.Join(outputDir, fmt.Sprintf("%s_face_%d.webp", baseFilename, index))
-	return saveMatAsWebP(croppedImg, outputPath)
+	outputPath := filepath.Join(opts.outputDir, fmt.Sprintf("%s_face_%d.webp", baseFilename, index))
+	return saveCrop(croppedImg, outputPath, opts)
+}
+
+type options struct {
+	inputDir  string
+	outputDir string
+	maxWidth  uint
+	maxHeight uint
+	preHook   string
+	postHook  string
+
+	decoder       string
+	resizeMode    string
+	interpolation gocv.InterpolationFlags
+
+	tileSize    int
+	tileOverlap float64
+	rotations   rotationList
+	roi         roiSpec
+	ignore      ignoreZones
+	batchSize   int
+
+	decodeWorkers int
+	encodeWorkers int
+
+	detectEvery int
+	sceneCut    float64
+	timeline    string
+
+	boxSmoothing float64
+
+	serveAddr   string
+	workers     int
+	apiKeysFile string
+	tlsCert     string
+	tlsKey      string
+	tlsClientCA string
+	rateLimit   float64
+	rateBurst   int
+	maxInFlight int
+	jobDB       string
+
+	// maxUploadSize caps a zip uploaded to /jobs and maxExtractSize the
+	// bytes extracted from it; 0 disables either.
+	maxUploadSize  int64
+	maxExtractSize int64
+
+	coordinateAddr string
+	workerOf       string
+	taskSize       int
+	leaseTimeout   time.Duration
+
+	compareA      string
+	compareB      string
+	compareImages bool
+	tuneLabels    string
+	hardNegatives string
+
+	camera          string
+	cameraName      string
+	streamAddr      string
+	allowedOrigins  stringList
+	countWindows    []time.Duration
+	private         bool
+	retention       time.Duration
+	motionThreshold float64
+	cooldown        time.Duration
+
+	// sealer encrypts face crops, with --encrypt-key or --encrypt-to.
+	sealer *cropSealer
+
+	// manifest, when set, is written to the output directory after a
+	// batch, signed with manifestKey when that is set too.
+	manifest    *manifest
+	manifestKey ed25519.PrivateKey
+
+	mqttBroker    string
+	mqttTopic     string
+	mqttDiscovery bool
+
+	slackChannel   string
+	telegramChat   string
+	notifyInterval time.Duration
+
+	cropGray        bool
+	cropNormalize   bool
+	cropTransparent bool
+	composition     string
+	cropScope       string
+	groupCrop       bool
+	groupPadding    float64
+
+	backgroundBlur int
+	anonymize      string
+
+	label      string
+	labelScale float64
+	labelColor color.RGBA
+
+	smartCrop image.Point
+
+	limits    inputLimits
+	maxMemory int64
+
+	quarantineDir  string
+	quarantineMode string
+
+	failFast     bool
+	maxErrors    int
+	failuresFile string
+	retryFrom    string
+	filesFrom    string
+	patterns     []string
+
+	// print0 receives crop paths, NUL-terminated, with --print0.
+	print0 io.Writer
+
+	// flags, when set, is where per-directory settings files are resolved,
+	// its flags writing to flagOpts; dirOpts then holds the options of each
+	// input directory that has one, detectorSettings the thresholds such a
+	// file overrides and dirSettings everything it sets.
+	flags            *flag.FlagSet
+	flagOpts         *options
+	dirOpts          map[string]*options
+	detectorSettings map[string]string
+	dirSettings      map[string]string
+
+	// auditLog is where --audit-log appends a record of each run.
+	auditLog string
+
+	heatmap    string
+	statsFile  string
+	statsChart string
+	datasetDir string
+	valSplit   float64
+	augment    *augmenter
+
+	xmp           string
+	existingFaces string
+	digikam       string
+	takeout       bool
+	pseudonyms    *pseudonymizer
+	keywords      bool
+	faceStrips    string
+	organizeDir   string
+	faceOrder     string
+
+	skipDuplicates    bool
+	duplicateDistance int
+	suppressBursts    bool
+	burstWindow       time.Duration
+
+	tfrecordPrefix      string
+	tfrecordShards      int
+	tfrecordCompression string
+
+	timeoutPerImage time.Duration
+
+	fileMode os.FileMode
+	preserve map[string]bool
+
+	shard shard
+}
+
+// loadedImage is an input read and resized for detection. batched holds the
+// batch detector results once its batch has been run.
+type loadedImage struct {
+	img     gocv.Mat
+	resized gocv.Mat
+	batched []Detection
 }
 
-func detectFace(imagePath string, outputImagePath string, outputDir string, maxWidth, maxHeight uint) (bool, error) {
-	classifier := gocv.NewCascadeClassifier()
-	if !classifier.Load("haarcascade_frontalface_default.xml") {
-		return false, fmt.Errorf("error loading Haar cascade file")
+func loadImage(imagePath string, opts *options) (*loadedImage, error) {
+	readFlag, err := checkInput(imagePath, &opts.limits)
+	if err != nil {
+		return nil, fmt.Errorf("rejected input: %w", err)
 	}
-	defer classifier.Close()
 
-	img := gocv.IMRead(imagePath, gocv.IMReadColor)
+	var img gocv.Mat
+	if opts.decoder == decoderVips {
+		if img, err = vipsDecode(imagePath, readFlag); err != nil {
+			return nil, err
+		}
+	} else {
+		img = gocv.IMRead(imagePath, readFlag)
+	}
 	if img.Empty() {
-		return false, fmt.Errorf("error reading image")
+		img.Close()
+		return nil, errUnreadable
 	}
-	defer img.Close()
+	in := &loadedImage{img: img, resized: gocv.NewMat()}
+	resizeForDetection(img, &in.resized, opts)
+	return in, nil
+}
 
-	resizedImg := gocv.NewMat()
-	defer resizedImg.Close()
-	gocv.Resize(img, &resizedImg, image.Point{X: int(maxWidth), Y: int(maxHeight)}, 0, 0, gocv.InterpolationLinear)
+func (in *loadedImage) Close() {
+	in.img.Close()
+	in.resized.Close()
+}
 
-	grayImg := gocv.NewMat()
-	defer grayImg.Close()
-	gocv.CvtColor(resizedImg, &grayImg, gocv.ColorBGRToGray)
+// detectFace processes one image. in is the image already loaded by a
+// prefetched batch, or nil to read it here; either way it is closed.
+func detectFace(imagePath string, names outputNames, opts *options, det *detectors, in *loadedImage) (imageResult, error) {
+	result := imageResult{Input: imagePath}
 
-	faces := classifier.DetectMultiScaleWithParams(
-		grayImg, 1.1, 5, 0, image.Point{X: 30, Y: 30}, image.Point{},
-	)
+	if in == nil {
+		var err error
+		if in, err = loadImage(imagePath, opts); err != nil {
+			return result, err
+		}
+	}
+	defer in.Close()
 
-	if len(faces) == 0 {
-		return false, nil
+	found, err := det.detectTagged(imagePath, in.img, in.resized, opts, in.batched)
+	if err != nil {
+		return result, err
+	}
+	return writeOutputs(imagePath, names, opts, in, found)
+}
+
+// writeOutputs saves the crops and annotated image for what was found in a
+// loaded image.
+func writeOutputs(imagePath string, names outputNames, opts *options, in *loadedImage, found sceneDetections) (imageResult, error) {
+	result := imageResult{Input: imagePath}
+	img, resizedImg := in.img, in.resized
+	faces, plates, people := found.faces, found.plates, found.people
+	sortFaces(faces, opts.faceOrder)
+
+	// Detection ran on the resized image; crops are cut from the original so
+	// they keep full resolution.
+	sx := float64(img.Cols()) / float64(resizedImg.Cols())
+	sy := float64(img.Rows()) / float64(resizedImg.Rows())
+
+	result.Faces = len(faces)
+	result.Plates = len(plates)
+	result.Width, result.Height = img.Cols(), img.Rows()
+	for _, face := range faces {
+		result.Boxes = append(result.Boxes, boxOf(scaleRect(face, sx, sy)))
+	}
+	if opts.takeout {
+		if err := applyTakeout(&result); err != nil {
+			fmt.Printf("Error reading Takeout metadata for %s: %v\n", imagePath, err)
+		}
+		if opts.pseudonyms != nil {
+			opts.pseudonyms.apply(&result)
+		}
+	}
+	if len(faces) == 0 && len(plates) == 0 {
+		return result, nil
 	}
 
-	baseFilename := filepath.Base(imagePath)
-	baseFilename = baseFilename[:len(baseFilename)-len(filepath.Ext(baseFilename))]
+	baseFilename := names.stem
+	bounds := image.Rect(0, 0, resizedImg.Cols(), resizedImg.Rows())
+	origBounds := image.Rect(0, 0, img.Cols(), img.Rows())
 
+	expanded := make([]image.Rectangle, len(faces))
+	subjects := make([]image.Rectangle, len(faces))
+	cropRects := make([]image.Rectangle, len(faces))
 	for i, face := range faces {
-		extraWidth := face.Dx() / 2
-		extraHeightTop := face.Dy() / 2
-		extraHeightBottom := face.Dy()
-		expandedRect := image.Rect(
-			max(0, face.Min.X-extraWidth),
-			max(0, face.Min.Y-extraHeightTop),
-			min(resizedImg.Cols(), face.Max.X+extraWidth),
-			min(resizedImg.Rows(), face.Max.Y+extraHeightBottom),
-		)
-		gocv.Rectangle(&resizedImg, expandedRect, color.RGBA{255, 0, 0, 0}, 3)
+		expanded[i] = expandFace(face, bounds)
+		subjects[i] = scaleRect(expanded[i], sx, sy).Intersect(origBounds)
 
-		if err := cropAndSaveFace(resizedImg, face, i+1, outputDir, baseFilename); err != nil {
-			return false, fmt.Errorf("error saving face image: %v", err)
+		cropRects[i] = composeCrop(scaleRect(face, sx, sy), origBounds, opts.composition)
+		if person, ok := personFor(face, people); ok {
+			cropRects[i] = scaleRect(person, sx, sy).Intersect(origBounds)
 		}
 	}
 
-	if err := saveMatAsWebP(resizedImg, outputImagePath); err != nil {
-		return false, fmt.Errorf("error saving output image in WebP format: %v", err)
+	// Crops are independent, so group photos encode them in parallel.
+	crops := make([]string, len(faces))
+	var g errgroup.Group
+	g.SetLimit(opts.encodeWorkers)
+	for i, cropRect := range cropRects {
+		g.Go(func() error {
+			cropPath, err := cropAndSaveFace(img, cropRect, i+1, opts, baseFilename)
+			crops[i] = cropPath
+			return err
+		})
+	}
+	err := g.Wait()
+	for _, cropPath := range crops {
+		if cropPath != "" {
+			result.Crops = append(result.Crops, cropPath)
+		}
+	}
+	if err != nil {
+		return result, fmt.Errorf("error saving face image: %v", err)
 	}
 
-	return true, nil
+	if opts.groupCrop && len(faces) > 1 {
+		groupRect := groupCropRect(subjects, origBounds, opts.groupPadding)
+		region := img.Region(groupRect)
+		groupPath, err := saveCrop(region, filepath.Join(opts.outputDir, fmt.Sprintf("%s_group.webp", baseFilename)), opts)
+		region.Close()
+		if err != nil {
+			return result, fmt.Errorf("error saving group crop: %v", err)
+		}
+		result.GroupCrop = groupPath
+	}
+
+	if opts.smartCrop != (image.Point{}) && len(faces) > 0 {
+		smartCropPath, err := saveSmartCrop(img, subjects, opts, baseFilename)
+		if err != nil {
+			return result, fmt.Errorf("error saving smart crop: %v", err)
+		}
+		result.SmartCrop = smartCropPath
+	}
+
+	annotated := getMat()
+	defer putMat(annotated)
+	resizedImg.CopyTo(&annotated)
+	if opts.backgroundBlur > 0 && len(faces) > 0 {
+		blurBackground(&annotated, expanded, opts.backgroundBlur)
+	}
+	if opts.anonymize != "" {
+		redactRegions(&annotated, faces, opts.anonymize)
+	}
+	if len(plates) > 0 {
+		redactRegions(&annotated, plates, opts.anonymize)
+	}
+	if opts.anonymize == "" {
+		for _, rect := range expanded {
+			gocv.Rectangle(&annotated, rect, color.RGBA{255, 0, 0, 0}, 3)
+		}
+	}
+	if opts.label != "" {
+		drawLabel(&annotated, expandLabel(opts.label, result, len(faces)), opts.labelScale, opts.labelColor)
+	}
+
+	annotatedPath, err := saveCrop(annotated, names.annotated, opts)
+	if err != nil {
+		return result, fmt.Errorf("error saving output image in WebP format: %v", err)
+	}
+	result.Annotated = annotatedPath
+
+	if opts.keywords {
+		if err := tagOutputs(result, opts.fileMode); err != nil {
+			return result, fmt.Errorf("error writing keywords: %v", err)
+		}
+	}
+	return result, nil
 }
 
-func processImages(inputDir, outputDir string, maxWidth, maxHeight uint) error {
-	files, err := ioutil.ReadDir(inputDir)
+func listInputs(opts *options) ([]string, error) {
+	paths, err := listAllInputs(opts)
 	if err != nil {
-		return fmt.Errorf("failed to read input directory: %v", err)
+		return nil, err
 	}
+	return shardInputs(paths, opts.inputDir, opts.shard), nil
+}
+
+// listAllInputs lists the inputs of every shard. Output names are planned
+// over this list so shards writing to the same directory never collide.
+func listAllInputs(opts *options) ([]string, error) {
+	var paths []string
+	var err error
+	switch {
+	case opts.retryFrom != "":
+		if paths, err = readFailures(opts.retryFrom); err != nil {
+			return nil, fmt.Errorf("failed to read retry list: %v", err)
+		}
+	case len(opts.patterns) > 0:
+		if paths, err = expandPatterns(opts.patterns); err != nil {
+			return nil, err
+		}
+	case opts.filesFrom != "":
+		if paths, err = readFileList(opts.filesFrom); err != nil {
+			return nil, fmt.Errorf("failed to read file list: %v", err)
+		}
+	default:
+		files, err := ioutil.ReadDir(opts.inputDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input directory: %v", err)
+		}
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			paths = append(paths, filepath.Join(opts.inputDir, file.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// shardInputs keeps the paths in the shard. Paths are keyed relative to the
+// input directory so machines mounting it in different places agree.
+func shardInputs(paths []string, inputDir string, s shard) []string {
+	if s.count <= 1 {
+		return paths
+	}
+	var kept []string
+	for _, p := range paths {
+		if s.includes(inputKey(p, inputDir)) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+var errInterrupted = errors.New("interrupted")
 
-	for _, file := range files {
-		if file.IsDir() {
+type batch struct {
+	opts      *options
+	det       *detectors
+	mem       *memoryBudget
+	loaded    map[string]*loadedImage
+	failures  []failure
+	total     int
+	processed int
+	faces     int
+	progress  func(imageProgress)
+
+	// running is held while an image runs under --timeout-per-image,
+	// including one abandoned after timing out, so that the next image's
+	// clock only starts once it has the detectors to itself.
+	running chan struct{}
+}
+
+// imageProgress reports one finished image of a batch.
+type imageProgress struct {
+	Input string `json:"input"`
+	Faces int    `json:"faces"`
+	Error string `json:"error,omitempty"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+
+	result imageResult
+}
+
+// batchSummary totals a batch run.
+type batchSummary struct {
+	Total     int `json:"total"`
+	Processed int `json:"processed"`
+	Faces     int `json:"faces"`
+	Failed    int `json:"failed"`
+}
+
+// optsFor returns the options for one input, which a settings file in its
+// directory may override.
+func (b *batch) optsFor(inputPath string) *options {
+	if o := b.opts.dirOpts[filepath.Dir(inputPath)]; o != nil {
+		return o
+	}
+	return b.opts
+}
+
+// preHook runs the pre-hook for inputPath and reports whether the image
+// should be processed.
+func (b *batch) preHook(inputPath string) bool {
+	preHook := b.optsFor(inputPath).preHook
+	if preHook == "" {
+		return true
+	}
+	if err := runHook(preHook, hookEvent{Stage: "pre", Input: inputPath}); err != nil {
+		fmt.Printf("Skipping file %s: pre-hook failed: %v\n", inputPath, err)
+		return false
+	}
+	return true
+}
+
+// prefetch loads inputs and runs the batch detectors over all of them in
+// one pass. Images that fail to load are left for processFile to report.
+func (b *batch) prefetch(inputs []string) {
+	if len(inputs) < 2 || b.opts.tileSize > 0 || b.opts.roi.set || !b.det.canBatch() {
+		return
+	}
+
+	var loaded []*loadedImage
+	var mats []gocv.Mat
+	for _, inputPath := range inputs {
+		// Batched detection runs with the global thresholds.
+		if isVideo(inputPath) || b.optsFor(inputPath) != b.opts {
+			continue
+		}
+		// Each prefetched image holds its share of --max-memory until it is
+		// processed; once the budget is used up, the rest load one by one.
+		if !b.mem.tryAcquire() {
+			break
+		}
+		in, err := loadImage(inputPath, b.opts)
+		if err != nil {
+			b.mem.release()
 			continue
 		}
+		b.loaded[inputPath] = in
+		loaded = append(loaded, in)
+		mats = append(mats, in.resized)
+	}
+	if len(mats) == 0 {
+		return
+	}
+
+	found, err := b.det.detectBatch(mats)
+	if err != nil {
+		fmt.Printf("Batched detection failed, falling back to single images: %v\n", err)
+		return
+	}
+	for i, in := range loaded {
+		in.batched = found[i]
+	}
+}
+
+// release closes prefetched images that were never processed and hands
+// back their memory budget.
+func (b *batch) release() {
+	for inputPath, in := range b.loaded {
+		in.Close()
+		delete(b.loaded, inputPath)
+		b.mem.release()
+	}
+}
+
+// processFile runs one input through detection, output and the post-hook,
+// recording failures. Outputs of an image that failed half way are removed.
+func (b *batch) processFile(inputPath string, names outputNames) error {
+	fmt.Printf("Processing file: %s\n", inputPath)
+	in := b.loaded[inputPath]
+	delete(b.loaded, inputPath)
+
+	result, err := b.detect(inputPath, names, in)
+	return b.finish(inputPath, result, err)
+}
+
+// finish records the outcome of one input, handles failures and runs the
+// post-hook.
+func (b *batch) finish(inputPath string, result imageResult, err error) error {
+	opts := b.optsFor(inputPath)
+
+	b.processed++
+	if err != nil {
+		removeOutputs(result)
+		b.failures = append(b.failures, failure{path: inputPath, reason: err.Error()})
+		fmt.Printf("Error processing file %s: %v\n", inputPath, err)
+		if opts.quarantineDir != "" && errors.Is(err, errUnreadable) {
+			dst, qerr := quarantineFile(inputPath, opts.quarantineDir, opts.quarantineMode, err)
+			if qerr != nil {
+				fmt.Printf("Error quarantining file %s: %v\n", inputPath, qerr)
+			} else {
+				fmt.Printf("Quarantined %s as %s\n", inputPath, dst)
+			}
+		}
+	} else {
+		b.faces += result.Faces
+		if perr := preserveAttributes(result, opts.preserve); perr != nil {
+			fmt.Printf("Error preserving attributes for %s: %v\n", inputPath, perr)
+		}
+	}
+
+	if opts.postHook != "" {
+		event := hookEvent{Stage: "post", Input: inputPath, Result: &result}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		if err := runHook(opts.postHook, event); err != nil {
+			fmt.Printf("Post-hook failed for %s: %v\n", inputPath, err)
+		}
+	}
+
+	if b.progress != nil {
+		p := imageProgress{Input: inputPath, Faces: result.Faces, Done: b.processed, Total: b.total, result: result}
+		if err != nil {
+			p.Error = err.Error()
+		}
+		b.progress(p)
+	}
+	return err
+}
+
+// detect runs detectFace, or processVideo for videos, giving up after
+// --timeout-per-image. OpenCV calls cannot be interrupted, so a timed-out
+// image keeps running in the background and its outputs are removed when it
+// eventually finishes. The time spent waiting for it does not count
+// against the next image.
+func (b *batch) detect(inputPath string, names outputNames, in *loadedImage) (imageResult, error) {
+	opts := b.optsFor(inputPath)
+	run := func() (imageResult, error) {
+		if isVideo(inputPath) {
+			return processVideo(inputPath, names, opts, b.det)
+		}
+		return detectFace(inputPath, names, opts, b.det, in)
+	}
+
+	timeout := b.opts.timeoutPerImage
+	if timeout <= 0 {
+		return run()
+	}
 
-		inputPath := filepath.Join(inputDir, file.Name())
-		outputImagePath := filepath.Join(outputDir, fmt.Sprintf("output_%s.webp", file.Name()))
+	type outcome struct {
+		result imageResult
+		err    error
+	}
+	started := make(chan struct{})
+	done := make(chan outcome, 1)
+	go func() {
+		b.running <- struct{}{}
+		close(started)
+		result, err := run()
+		<-b.running
+		done <- outcome{result, err}
+	}()
+	<-started
 
-		fmt.Printf("Processing file: %s\n", inputPath)
-		if _, err := detectFace(inputPath, outputImagePath, outputDir, maxWidth, maxHeight); err != nil {
-			fmt.Printf("Error processing file %s: %v\n", inputPath, err)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-timer.C:
+		go func() {
+			o := <-done
+			removeOutputs(o.result)
+		}()
+		return imageResult{Input: inputPath}, fmt.Errorf("timed out after %v", timeout)
+	}
+}
+
+func removeOutputs(result imageResult) {
+	for _, p := range result.outputs() {
+		if p != "" {
+			os.Remove(p)
 		}
 	}
+}
 
-	return nil
+// processImages handles the inputs one by one until they run out, the error
+// policy says stop, or ctx is cancelled. On cancellation the image in flight
+// is finished and the failure report and summary are still written.
+func processImages(ctx context.Context, opts *options, det *detectors) error {
+	// Per-directory settings files are resolved through the command line's
+	// flags, so only command-line runs read them.
+	o := *opts
+	o.flags = flag.CommandLine
+	o.flagOpts = opts
+	_, err := runBatch(ctx, &o, det, nil)
+	return err
+}
+
+// runBatch is processImages reporting each image to progress, when not nil,
+// and returning the totals.
+func runBatch(ctx context.Context, opts *options, det *detectors, progress func(imageProgress)) (batchSummary, error) {
+	all, err := listAllInputs(opts)
+	if err != nil {
+		return batchSummary{}, err
+	}
+	names := planOutputNames(all, opts.outputDir)
+	inputs := shardInputs(all, opts.inputDir, opts.shard)
+	if opts.skipDuplicates {
+		if inputs, err = skipDuplicates(ctx, inputs, opts); err != nil {
+			return batchSummary{}, err
+		}
+	}
+	if opts.flags != nil {
+		root := opts.inputDir
+		if len(opts.patterns) > 0 || opts.filesFrom != "" || opts.retryFrom != "" {
+			root = "."
+		}
+		if opts.dirOpts, err = resolveDirOptions(opts.flags, opts.flagOpts, opts, inputs, root); err != nil {
+			return batchSummary{}, err
+		}
+	}
+
+	var heat *heatmap
+	if opts.heatmap != "" {
+		heat = newHeatmap()
+		progress = observeResults(progress, heat.add)
+	}
+	var stats *datasetStats
+	if opts.statsFile != "" || opts.statsChart != "" {
+		stats = newDatasetStats()
+		progress = observeResults(progress, stats.add)
+	}
+	var dataset *datasetExport
+	if opts.datasetDir != "" {
+		if dataset, err = newDatasetExport(opts.datasetDir, opts.valSplit, opts.inputDir, opts.fileMode); err != nil {
+			return batchSummary{}, err
+		}
+		if opts.augment != nil {
+			dataset.augment = opts.augment.apply
+		}
+		progress = observeResults(progress, dataset.add)
+	}
+	if opts.xmp != "" {
+		progress = observeResults(progress, func(r imageResult) {
+			if err := writeXMP(r, opts.xmp, opts.fileMode); err != nil {
+				fmt.Printf("Error writing XMP for %s: %v\n", r.Input, err)
+			}
+		})
+	}
+	if opts.print0 != nil {
+		progress = observeResults(progress, func(r imageResult) {
+			for _, crop := range r.Crops {
+				io.WriteString(opts.print0, crop+"\x00")
+			}
+		})
+	}
+	var strips *faceStrips
+	if opts.faceStrips != "" {
+		if strips, err = newFaceStrips(opts.faceStrips, opts.fileMode); err != nil {
+			return batchSummary{}, err
+		}
+		progress = observeResults(progress, strips.add)
+	}
+	if opts.organizeDir != "" {
+		org, err := newOrganizer(opts.organizeDir)
+		if err != nil {
+			return batchSummary{}, err
+		}
+		defer func() { fmt.Printf("Organized %d photos into %s\n", org.linked, opts.organizeDir) }()
+		progress = observeResults(progress, org.add)
+	}
+	var bursts *burstFilter
+	if opts.suppressBursts {
+		bursts = &burstFilter{window: opts.burstWindow}
+		progress = observeResults(progress, bursts.add)
+	}
+	if opts.digikam != "" {
+		dk, err := openDigikam(opts.digikam)
+		if err != nil {
+			return batchSummary{}, err
+		}
+		defer func() {
+			fmt.Printf("Added %d faces to %s\n", dk.added, opts.digikam)
+			dk.Close()
+		}()
+		progress = observeResults(progress, func(r imageResult) {
+			if err := dk.add(r); err != nil {
+				fmt.Printf("Error adding %s to digiKam: %v\n", r.Input, err)
+			}
+		})
+	}
+	if opts.tfrecordPrefix != "" {
+		records, err := newTFRecordExport(opts.tfrecordPrefix, opts.tfrecordShards, opts.tfrecordCompression, opts.inputDir, opts.fileMode)
+		if err != nil {
+			return batchSummary{}, err
+		}
+		defer func() {
+			if err := records.Close(); err != nil {
+				fmt.Printf("Error writing TFRecord files: %v\n", err)
+			}
+		}()
+		progress = observeResults(progress, records.add)
+	}
+
+	var audit *auditLog
+	if opts.auditLog != "" {
+		if audit, err = openAuditLog(opts.auditLog, opts.fileMode); err != nil {
+			return batchSummary{}, err
+		}
+		defer audit.Close()
+		if err := audit.start(flag.CommandLine); err != nil {
+			return batchSummary{}, fmt.Errorf("failed to write audit log: %v", err)
+		}
+		next := progress
+		progress = func(p imageProgress) {
+			o := opts
+			if d := opts.dirOpts[filepath.Dir(p.Input)]; d != nil {
+				o = d
+			}
+			if err := audit.input(p, o); err != nil {
+				fmt.Printf("Error writing audit log: %v\n", err)
+			}
+			if next != nil {
+				next(p)
+			}
+		}
+	}
+
+	var outputs []string
+	if opts.manifest != nil {
+		progress = observeResults(progress, func(r imageResult) {
+			outputs = append(outputs, r.outputs()...)
+		})
+	}
+
+	summary, failures, runErr := runInputs(ctx, opts, det, inputs, names, progress)
+	if err := writeFailures(opts.failuresFile, failures, opts.fileMode); err != nil {
+		fmt.Printf("Error writing failure report: %v\n", err)
+	}
+	if bursts != nil {
+		path := filepath.Join(opts.outputDir, "bursts.json")
+		removed, err := bursts.suppress(path, opts.fileMode)
+		if err != nil {
+			fmt.Printf("Error suppressing burst crops: %v\n", err)
+		} else if removed > 0 {
+			fmt.Printf("Removed %d repeated burst crops, see %s\n", removed, path)
+		}
+	}
+	if heat != nil {
+		if err := heat.write(opts.heatmap, opts.fileMode); err != nil {
+			fmt.Printf("Error writing heatmap: %v\n", err)
+		}
+	}
+	if stats != nil && opts.statsFile != "" {
+		if err := stats.write(opts.statsFile, opts.fileMode); err != nil {
+			fmt.Printf("Error writing statistics: %v\n", err)
+		}
+	}
+	if dataset != nil {
+		if err := dataset.write(); err != nil {
+			fmt.Printf("Error writing dataset manifest: %v\n", err)
+		}
+	}
+	if strips != nil {
+		if err := strips.write(); err != nil {
+			fmt.Printf("Error writing face strips: %v\n", err)
+		}
+	}
+	if stats != nil && opts.statsChart != "" {
+		if err := stats.writeChart(opts.statsChart, opts.fileMode); err != nil {
+			fmt.Printf("Error writing statistics chart: %v\n", err)
+		}
+	}
+	if opts.manifest != nil {
+		if err := opts.manifest.write(opts.outputDir, outputs, opts.manifestKey, opts.fileMode); err != nil {
+			fmt.Printf("Error writing manifest: %v\n", err)
+		}
+	}
+	if audit != nil {
+		if err := audit.end(summary); err != nil {
+			fmt.Printf("Error writing audit log: %v\n", err)
+		}
+	}
+	fmt.Printf("Processed %d of %d images: %d faces found, %d failed\n", summary.Processed, summary.Total, summary.Faces, summary.Failed)
+
+	if runErr != nil {
+		return summary, runErr
+	}
+	if len(failures) > 0 {
+		return summary, fmt.Errorf("%d of %d images failed, see %s", len(failures), summary.Processed, opts.failuresFile)
+	}
+	return summary, nil
+}
+
+// observeResults chains fn, called with each successful image's result, in
+// front of progress.
+func observeResults(progress func(imageProgress), fn func(imageResult)) func(imageProgress) {
+	return func(p imageProgress) {
+		if p.Error == "" {
+			fn(p.result)
+		}
+		if progress != nil {
+			progress(p)
+		}
+	}
+}
+
+// runInputs processes the given inputs, writing each to its names entry.
+// The error is only set when the run stopped early; failed images are
+// returned in the failures list.
+func runInputs(ctx context.Context, opts *options, det *detectors, inputs []string, names map[string]outputNames, progress func(imageProgress)) (batchSummary, []failure, error) {
+	b := &batch{opts: opts, det: det, mem: newMemoryBudget(opts.maxMemory), loaded: map[string]*loadedImage{}, total: len(inputs), progress: progress, running: make(chan struct{}, 1)}
+	defer b.release()
+	if opts.timeoutPerImage <= 0 && opts.batchSize == 1 && len(inputs) > 1 {
+		runErr := b.runPipeline(ctx, inputs, names)
+		summary := batchSummary{Total: len(inputs), Processed: b.processed, Faces: b.faces, Failed: len(b.failures)}
+		return summary, b.failures, runErr
+	}
+
+	var runErr error
+outer:
+	for start := 0; start < len(inputs); start += opts.batchSize {
+		var ready []string
+		for _, inputPath := range inputs[start:min(start+opts.batchSize, len(inputs))] {
+			if b.preHook(inputPath) {
+				ready = append(ready, inputPath)
+			}
+		}
+		b.prefetch(ready)
+
+		for _, inputPath := range ready {
+			if ctx.Err() != nil {
+				runErr = errInterrupted
+				break outer
+			}
+			// Prefetched images already hold their budget.
+			if b.loaded[inputPath] == nil {
+				if err := b.mem.acquire(ctx); err != nil {
+					runErr = errInterrupted
+					break outer
+				}
+			}
+			err := b.processFile(inputPath, names[inputPath])
+			b.mem.release()
+			if err != nil {
+				failed := len(b.failures)
+				if opts.failFast || (opts.maxErrors > 0 && failed >= opts.maxErrors) {
+					runErr = fmt.Errorf("stopping after %d failed image(s)", failed)
+					break outer
+				}
+			}
+		}
+	}
+
+	summary := batchSummary{Total: len(inputs), Processed: b.processed, Faces: b.faces, Failed: len(b.failures)}
+	return summary, b.failures, runErr
 }
 
 func main() {
-	inputDir := "input_images"
-	outputDir := "output_images"
-	maxWidth := uint(1024)
-	maxHeight := uint(1024)
+	opts := &options{
+		inputDir:  "input_images",
+		outputDir: "output_images",
+		maxWidth:  1024,
+		maxHeight: 1024,
+	}
+
+	var cascades, models, plugins stringList
+	detCfg := detectorConfig{}
+	flag.StringVar(&detCfg.modelDir, "model-dir", "", "directory holding cascades and models (default ~/.cache/face-detector)")
+	flag.Var(&cascades, "cascade", "Haar/LBP cascade file or known cascade name (repeatable)")
+	flag.Var(&models, "model", "DNN face model (repeatable): SSD-style for the opencv backend, SCRFD or RetinaFace ONNX for the onnx backend")
+	flag.StringVar(&detCfg.backend, "backend", backendOpenCV, "inference backend for --model: opencv or onnx (ONNX Runtime)")
+	flag.StringVar(&detCfg.device, "device", "cpu", "DNN device for the opencv backend: cpu, openvino (Intel Inference Engine), opencl or cuda")
+	flag.StringVar(&detCfg.onnxLib, "onnxruntime-lib", os.Getenv("ONNXRUNTIME_LIB"), "path to the ONNX Runtime shared library (default $ONNXRUNTIME_LIB or the system library)")
+	flag.StringVar(&detCfg.subject, "subject", "face", "what to detect when no cascade, model or plugin is given: face, pet (cats) or anime")
+	flag.Float64Var(&detCfg.scaleFactor, "scale-factor", defaultScaleFactor, "cascade image pyramid step; smaller finds more faces, slower")
+	flag.IntVar(&detCfg.minNeighbors, "min-neighbors", defaultMinNeighbors, "overlapping cascade hits needed to keep a face; higher means fewer false positives")
+	flag.Float64Var(&detCfg.minConfidence, "min-confidence", defaultMinConfidence, "minimum score (0-1) for --model detections")
+	flag.Var(&plugins, "plugin", "external detector command speaking JSON-RPC on stdin/stdout (repeatable)")
+	flag.StringVar(&detCfg.verify, "verify", "", "double-check cascade detections before cropping: eyes (an eye pair) or a detector as for --backend-a, e.g. dnn:MODEL")
+	backendChain := flag.String("backend-chain", "", "detectors tried in order until one finds faces, e.g. dnn:MODEL,haar; entries as for --backend-a")
+	flag.StringVar(&opts.preHook, "pre-hook", "", "shell command run before each image; a non-zero exit skips the image")
+	flag.StringVar(&opts.postHook, "post-hook", "", "shell command run after each image with the results as JSON on stdin")
+	flag.StringVar(&opts.decoder, "decoder", decoderOpenCV, "image decoder: opencv or vips (needs a build with -tags vips)")
+	flag.StringVar(&opts.resizeMode, "resize", resizeStretch, "resize before detection: stretch, fit or none")
+	interpolation := flag.String("interpolation", "linear", "resize interpolation: nearest, linear, cubic, area or lanczos")
+	flag.IntVar(&opts.tileSize, "tile", 0, "detect on full-resolution tiles of this size in pixels (0 disables tiling)")
+	flag.Float64Var(&opts.tileOverlap, "tile-overlap", 0.25, "fraction of each tile overlapping its neighbours")
+	flag.Var(&opts.roi, "roi", "only look for faces in this region: x,y,w,h in pixels of the original image or percent, e.g. 10%,0,30%,60%")
+	flag.Var(&opts.ignore.rects, "ignore-rect", "discard faces centred in this region, x,y,w,h as for --roi (repeatable)")
+	flag.Var(&opts.ignore.mask, "ignore-mask", "discard faces centred on non-black pixels of this image, which is scaled to each input")
+	flag.Var(&opts.rotations, "rotations", "detect on copies rotated by these degrees counter-clockwise, e.g. 0,90,180,270 (include 0 for the image as is), or step:30 for every 30 degrees")
+	flag.IntVar(&opts.detectEvery, "detect-every", 10, "for videos, run full detection every N frames and track faces in between")
+	flag.Float64Var(&opts.boxSmoothing, "box-smoothing", 0, "for videos, weight (0-1) of a face's previous box when drawing it, to steady jittering boxes (0 disables)")
+	flag.Float64Var(&opts.sceneCut, "scene-cut", 0.5, "for videos, histogram distance (0-1) between frames that counts as a scene cut and forces re-detection (0 disables)")
+	flag.StringVar(&opts.timeline, "timeline", "", "for videos, also write when each face appears and disappears: srt or json")
+	flag.StringVar(&opts.serveAddr, "serve", "", "serve the detection HTTP API on this address (e.g. :8080) instead of processing the input directory")
+	flag.IntVar(&opts.workers, "workers", 1, "in server mode, number of detection workers")
+	flag.StringVar(&opts.apiKeysFile, "api-keys", "", "in server mode, file of accepted API keys, one per line (also read from FACE_DETECTOR_API_KEYS)")
+	flag.StringVar(&opts.tlsCert, "tls-cert", "", "in server mode, serve HTTPS with this PEM certificate (needs --tls-key)")
+	flag.StringVar(&opts.tlsKey, "tls-key", "", "PEM private key for --tls-cert")
+	var outbound outboundConfig
+	var hostTimeouts stringList
+	flag.StringVar(&outbound.proxy, "proxy", "", "proxy URL for outbound HTTP(S) requests (default from HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	flag.StringVar(&outbound.caBundle, "ca-bundle", "", "PEM bundle of extra CAs trusted for outbound HTTPS, e.g. a corporate proxy's")
+	maxBandwidth := flag.String("max-bandwidth", "", "cap outbound transfers at this many bytes per second in total, e.g. 2M (0 or empty for no limit)")
+	flag.IntVar(&ioRetry.retries, "io-retries", ioRetry.retries, "times to retry outbound requests and output writes that fail transiently")
+	flag.DurationVar(&ioRetry.backoff, "io-backoff", ioRetry.backoff, "wait before the first I/O retry, doubling for each further retry")
+	flag.Var(&hostTimeouts, "host-timeout", "HOST=DURATION limit for outbound requests to one host (repeatable)")
+	flag.StringVar(&opts.tlsClientCA, "tls-client-ca", "", "require client certificates signed by this PEM CA bundle (mutual TLS)")
+	flag.Float64Var(&opts.rateLimit, "rate-limit", 0, "in server mode, requests per second allowed per client (0 disables)")
+	flag.IntVar(&opts.rateBurst, "rate-burst", 10, "requests a client may burst above --rate-limit")
+	flag.IntVar(&opts.maxInFlight, "max-inflight", 16, "in server mode, maximum requests processed at once; more get 503 (0 means no cap)")
+	flag.StringVar(&opts.jobDB, "job-db", "", "in server mode, database keeping async jobs across restarts (default OUTPUT_DIR/jobs.db)")
+	cachePath := flag.String("cache", "", "database caching detections by file content, reused when only crop or output settings change")
+	shardSpec := flag.String("shard", "", "only process shard K of N of the inputs, e.g. 3/8, split by file name hash")
+	flag.StringVar(&opts.coordinateAddr, "coordinate", "", "hand the inputs out to workers connecting on this address (e.g. :9090) instead of processing them")
+	flag.StringVar(&opts.workerOf, "worker-of", "", "process tasks from the coordinator at this URL (e.g. http://host:9090); inputs must be mounted at the same paths")
+	flag.IntVar(&opts.taskSize, "task-size", 100, "with --coordinate, images per task")
+	flag.DurationVar(&opts.leaseTimeout, "lease-timeout", 10*time.Minute, "with --coordinate, time a worker has to finish a task before it is reassigned")
+	flag.StringVar(&opts.compareA, "backend-a", "", "compare two detectors over the inputs instead of processing them: haar, haar:FILE, dnn:MODEL, onnx:MODEL or plugin:COMMAND (needs --backend-b)")
+	flag.StringVar(&opts.compareB, "backend-b", "", "the second detector to compare, in the same form as --backend-a")
+	flag.BoolVar(&opts.compareImages, "compare-images", false, "with --backend-a/--backend-b, also write side-by-side images of both detectors' faces")
+	flag.StringVar(&opts.tuneLabels, "tune", "", "sweep detection thresholds over the images labeled in this JSON file and write the best settings to --config (default <output>/tuned.conf)")
+	flag.StringVar(&opts.hardNegatives, "hard-negatives", "", "with --tune, save the false positives left at the best settings to this directory as negative samples for cascade training")
+	flag.StringVar(&opts.camera, "camera", "", "run on a live camera instead of the input directory: device index (0) or stream URL")
+	flag.StringVar(&opts.cameraName, "camera-name", "", "name of the camera in events and notifications, e.g. \"front door\" (default the --camera value)")
+	flag.StringVar(&opts.mqttBroker, "mqtt-broker", "", "in camera mode, publish face presence to this MQTT broker, e.g. tcp://localhost:1883 (credentials from MQTT_USERNAME/MQTT_PASSWORD)")
+	flag.StringVar(&opts.mqttTopic, "mqtt-topic", "face_detector/state", "MQTT topic for face presence updates")
+	flag.BoolVar(&opts.mqttDiscovery, "mqtt-discovery", false, "announce the camera to Home Assistant via MQTT discovery")
+	flag.StringVar(&opts.slackChannel, "slack-channel", "", "in camera mode, post a snapshot to this Slack channel ID when a new face appears (token from SLACK_BOT_TOKEN)")
+	flag.StringVar(&opts.telegramChat, "telegram-chat", "", "in camera mode, send a snapshot to this Telegram chat ID when a new face appears (token from TELEGRAM_BOT_TOKEN)")
+	flag.DurationVar(&opts.notifyInterval, "notify-interval", time.Minute, "minimum time between Slack/Telegram alerts")
+	flag.Float64Var(&opts.motionThreshold, "motion-threshold", 0, "in camera mode, only run detection when this fraction (0-1) of pixels changed since the last frame, e.g. 0.01 (0 disables)")
+	flag.DurationVar(&opts.cooldown, "cooldown", 30*time.Second, "in camera mode, don't snapshot a face again if one was seen in the same place within this long")
+	flag.StringVar(&opts.streamAddr, "stream-addr", "", "in camera mode, serve the annotated feed as MJPEG at http://ADDR/stream.mjpg, detection events over WebSocket at ws://ADDR/events and face counts at /counts and /metrics")
+	flag.BoolVar(&opts.private, "private", false, "in camera mode, only ever publish redacted frames and face counts: no snapshots, events, dwell log or MQTT track ids (redacts with --anonymize, default blur; detects on every frame, ignoring --detect-every and --motion-threshold)")
+	retention := flag.String("retention", "", "in camera mode, delete this camera's snapshots in the output directory once they are older than this, e.g. 30d or 12h")
+	flag.Var(&opts.allowedOrigins, "allowed-origin", "origin, e.g. https://dashboard.example.com, of a web page allowed to open the /events WebSocket in camera and server mode, or * for any (repeatable; default same origin only)")
+	countWindows := flag.String("count-windows", "1m,15m,1h", "with --stream-addr, trailing windows to count distinct faces over")
+	flag.IntVar(&opts.batchSize, "batch-size", 1, "images per DNN forward pass with --model (ignored with --tile)")
+	flag.IntVar(&opts.decodeWorkers, "decode-workers", 2, "goroutines reading and resizing images ahead of detection")
+	flag.IntVar(&opts.encodeWorkers, "encode-workers", runtime.NumCPU(), "goroutines writing crops and annotated images")
+	flag.BoolVar(&opts.cropGray, "crop-gray", false, "write face crops in grayscale")
+	flag.BoolVar(&opts.cropNormalize, "crop-normalize", false, "apply histogram equalization to face crops")
+	flag.BoolVar(&opts.cropTransparent, "crop-transparent", false, "cut face crops out of their background (GrabCut) with a transparent alpha channel")
+	flag.StringVar(&opts.composition, "composition", compositionPadded, "face crop framing: padded, center or thirds (eye line on the upper third)")
+	flag.StringVar(&opts.cropScope, "crop-scope", cropScopeFace, "what each crop covers: face (face and shoulders) or person (full body via HOG)")
+	flag.BoolVar(&opts.groupCrop, "group-crop", false, "when several faces are found, also write one crop containing all of them")
+	flag.Float64Var(&opts.groupPadding, "group-padding", 0.1, "padding around the --group-crop region as a fraction of its size")
+	flag.IntVar(&opts.backgroundBlur, "background-blur", 0, "blur everything but the people in the annotated image with this kernel size (0 disables)")
+	flag.StringVar(&opts.anonymize, "anonymize", "", "redact faces in the annotated image instead of outlining them: blur or pixelate")
+	redactPlates := flag.Bool("redact-plates", false, "also detect and redact license plates in the annotated image")
+	plateCascade := flag.String("plate-cascade", defaultPlateCascade, "cascade file or known cascade name used by --redact-plates")
+	flag.StringVar(&opts.label, "label", "", "text drawn on the annotated image; supports {file}, {time} (when the photo was taken), {faces} and {names} (people named by --takeout)")
+	flag.Float64Var(&opts.labelScale, "label-scale", 1.0, "font scale of the --label text")
+	labelColor := flag.String("label-color", "#ffffff", "color of the --label text as #rrggbb or r,g,b")
+	smartCrop := flag.String("smartcrop", "", "also write a WIDTHxHEIGHT crop of the source keeping the faces in frame, e.g. 1200x628")
+	maxFileSize := flag.String("max-file-size", "512MB", "reject input files larger than this (0 disables)")
+	maxUploadSize := flag.String("max-upload-size", "2GB", "in server mode, reject zip archives uploaded to /jobs larger than this (0 disables)")
+	maxExtractSize := flag.String("max-extract-size", "8GB", "in server mode, fail jobs whose uploaded archive extracts to more than this (0 disables)")
+	pprofAddr := flag.String("pprof", "", "serve net/http/pprof on this address, e.g. :6060")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile of the run to this file")
+	memProfile := flag.String("memprofile", "", "write a heap profile to this file at the end of the run")
+	maxMemory := flag.String("max-memory", "0", "hold back new images while the process uses more memory than this (0 disables)")
+	flag.Float64Var(&opts.limits.maxMegapixels, "max-megapixels", 250, "reject or downscale images above this many megapixels (0 disables)")
+	flag.IntVar(&opts.limits.maxDimension, "max-dimension", 0, "reject or downscale images whose longest side exceeds this (0 disables)")
+	flag.StringVar(&opts.limits.oversize, "oversize", oversizeReject, "what to do with images over the pixel limits: reject or downscale (JPEG only)")
+	flag.StringVar(&opts.quarantineDir, "quarantine", "", "move files that fail to decode into this directory, with reasons in reasons.tsv")
+	flag.StringVar(&opts.quarantineMode, "quarantine-mode", quarantineMove, "how --quarantine handles bad files: move or copy")
+	flag.BoolVar(&opts.failFast, "fail-fast", false, "stop at the first image that fails")
+	flag.IntVar(&opts.maxErrors, "max-errors", 0, "stop once this many images have failed (0 means never)")
+	flag.StringVar(&opts.heatmap, "heatmap", "", "write a PNG heatmap of where faces appear across all images to this file")
+	flag.StringVar(&opts.statsFile, "stats", "", "write dataset statistics as JSON to this file at the end of the run")
+	flag.StringVar(&opts.statsChart, "stats-chart", "", "draw the dataset statistics as a PNG bar chart to this file")
+	flag.StringVar(&opts.datasetDir, "export-dataset", "", "also arrange face crops into train/ and val/ under this directory with a manifest.jsonl, for model training")
+	flag.Float64Var(&opts.valSplit, "val-split", 0.2, "with --export-dataset, fraction of source images whose crops go to val/")
+	flag.StringVar(&opts.xmp, "xmp", "", "record detected faces as MWG face regions in XMP: sidecar (photo.xmp next to the input) or embed (in the JPEG itself)")
+	flag.StringVar(&opts.existingFaces, "existing-faces", "", "use faces already tagged in XMP regions or .picasa.ini: skip (instead of detecting when an image has tags) or merge (with detections)")
+	flag.StringVar(&opts.digikam, "digikam", "", "add detected faces to this digiKam database (digikam4.db), for images already in its library: as unknown faces, or as suggestions for the person when --takeout names them")
+	flag.BoolVar(&opts.takeout, "takeout", false, "read Google Takeout JSON sidecars next to inputs for the time taken and people names")
+	pseudonymKey := flag.String("pseudonym-key", "", "with --takeout, replace people names in all outputs with stable pseudonyms, the HMAC-SHA256 of the name under the secret key in this file")
+	pseudonymPrefix := flag.String("pseudonym-prefix", "person-", "prefix of the pseudonyms written with --pseudonym-key")
+	encryptKey := flag.String("encrypt-key", "", "encrypt face crops and every other image showing faces with AES-256-GCM using this 32-byte key file (raw or hex); they get a .enc suffix and videos get no annotated copy")
+	var encryptTo stringList
+	flag.Var(&encryptTo, "encrypt-to", "encrypt face images, as with --encrypt-key, to the holder of this X25519 public key in PEM (repeatable)")
+	writeManifest := flag.Bool("manifest", false, "write manifest.json to the output directory listing every output with its SHA-256, the build, all parameters and the model digests")
+	manifestKey := flag.String("manifest-key", "", "sign the manifest with this Ed25519 private key in PEM, to manifest.json.sig (implies --manifest)")
+	checkManifestPath := flag.String("check-manifest", "", "verify the outputs listed in this manifest instead of processing images")
+	manifestPubKey := flag.String("manifest-pubkey", "", "with --check-manifest, also verify the signature with this Ed25519 public key in PEM")
+	decryptPath := flag.String("decrypt", "", "decrypt this .enc crop to stdout instead of processing images (needs --decrypt-key)")
+	decryptKey := flag.String("decrypt-key", "", "with --decrypt, the --encrypt-key file or the X25519 private key in PEM")
+	flag.BoolVar(&opts.keywords, "keywords", false, "write XMP keywords such as faces:3 and known people names into the output images")
+	flag.StringVar(&opts.faceStrips, "face-strips", "", "write a strip per named person of their face crops ordered by capture date (Exif or Takeout) to this directory")
+	flag.StringVar(&opts.organizeDir, "organize", "", "hard-link (or copy) source photos into a folder per named person under this directory, unnamed faces into unknown/")
+	flag.BoolVar(&opts.skipDuplicates, "skip-duplicates", false, "skip inputs identical or near-identical (by perceptual hash) to an earlier input, reported in duplicates.json")
+	flag.IntVar(&opts.duplicateDistance, "duplicate-distance", 5, "with --skip-duplicates, the most bits (of 64) two image hashes may differ by to count as duplicates")
+	flag.BoolVar(&opts.suppressBursts, "suppress-bursts", false, "at the end of the run, delete face crops repeated across burst shots, keeping the sharpest of each; listed in bursts.json")
+	flag.DurationVar(&opts.burstWindow, "burst-window", 2*time.Second, "with --suppress-bursts, how far apart in capture time shots of one burst may be")
+	flag.StringVar(&opts.faceOrder, "face-order", faceOrderLTR, "order faces are numbered in within an image: ltr (left to right), ttb (top to bottom) or size (largest first)")
+	augmentations := flag.String("augment", "", "with --export-dataset, also write augmented copies of each crop: comma-separated flip, rotate, brightness")
+	augmentSeed := flag.Int64("augment-seed", 1, "seed for the random rotation and brightness of --augment")
+	flag.StringVar(&opts.tfrecordPrefix, "export-tfrecord", "", "also write face crops with labels and boxes as TFRecord files named PREFIX-00000-of-0000N.tfrecord")
+	flag.IntVar(&opts.tfrecordShards, "tfrecord-shards", 1, "number of TFRecord files to spread the examples over")
+	flag.StringVar(&opts.tfrecordCompression, "tfrecord-compression", compressionNone, "TFRecord compression: none, gzip or zlib")
+	flag.StringVar(&opts.auditLog, "audit-log", "", "append a hash-chained JSON line per run and per input to this file: parameters, faces found and redactions applied")
+	flag.StringVar(&opts.failuresFile, "failures", "", "where to write failed inputs and reasons (default <output>/failures.txt)")
+	flag.StringVar(&opts.retryFrom, "retry-from", "", "process only the inputs listed in this failures file")
+	print0 := flag.Bool("print0", false, "print the path of each face crop to stdout followed by a NUL, for xargs -0; other output goes to stderr")
+	flag.StringVar(&opts.filesFrom, "files-from", "", "process the files listed in this file (- for stdin), one per line or NUL-separated, instead of the input directory")
+	flag.DurationVar(&opts.timeoutPerImage, "timeout-per-image", 0, "give up on an image after this long, e.g. 30s (0 disables)")
+	chmod := flag.String("chmod", "", "octal permissions for output files (default 0644 minus umask)")
+	preserve := flag.String("preserve", "", "comma-separated source attributes copied to outputs: mode, mtime, owner")
+	configPath := flag.String("config", "", "read settings from this file of \"flag = value\" lines; command-line flags take precedence")
+	flag.Parse()
+	if *configPath != "" {
+		if err := applyConfig(flag.CommandLine, *configPath); err != nil && !(opts.tuneLabels != "" && errors.Is(err, os.ErrNotExist)) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Positional arguments are files, directories or glob patterns to
+	// process instead of the input directory.
+	opts.patterns = flag.Args()
+
+	if *print0 {
+		// Keep stdout for the paths; progress and messages move to stderr.
+		opts.print0 = os.Stdout
+		os.Stdout = os.Stderr
+	}
+
+	if opts.failuresFile == "" {
+		opts.failuresFile = filepath.Join(opts.outputDir, "failures.txt")
+	}
+
+	if err := validateResizeMode(opts.resizeMode); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := validateComposition(opts.composition); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := validateAnonymizeMethod(opts.anonymize); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := validateCropScope(opts.cropScope); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := validateOversizePolicy(opts.limits.oversize); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := validateQuarantineMode(opts.quarantineMode); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.private && opts.camera == "" {
+		fmt.Fprintln(os.Stderr, "Error: --private needs --camera")
+		os.Exit(1)
+	}
+	if *pseudonymKey != "" {
+		if !opts.takeout {
+			fmt.Fprintln(os.Stderr, "Error: --pseudonym-key needs --takeout")
+			os.Exit(1)
+		}
+		p, err := loadPseudonymizer(*pseudonymKey, *pseudonymPrefix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.pseudonyms = p
+	}
+	if *retention != "" {
+		if opts.camera == "" {
+			fmt.Fprintln(os.Stderr, "Error: --retention needs --camera")
+			os.Exit(1)
+		}
+		d, err := parseRetention(*retention)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.retention = d
+	}
+	if opts.private && opts.anonymize == "" {
+		opts.anonymize = anonymizeBlur
+	}
+	if opts.cameraName == "" {
+		opts.cameraName = opts.camera
+	}
+	if err := validateValFraction(opts.valSplit); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := validateXMPMode(opts.xmp); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *encryptKey != "" || len(encryptTo) > 0 {
+		if opts.keywords || opts.faceStrips != "" || opts.suppressBursts || opts.datasetDir != "" || opts.tfrecordPrefix != "" {
+			fmt.Fprintln(os.Stderr, "Error: encrypted crops cannot be read back by --keywords, --face-strips, --suppress-bursts, --export-dataset or --export-tfrecord")
+			os.Exit(1)
+		}
+		sealer, err := loadSealer(*encryptKey, encryptTo)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.sealer = sealer
+	}
+	if *checkManifestPath != "" {
+		if err := checkManifest(*checkManifestPath, *manifestPubKey, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *writeManifest || *manifestKey != "" {
+		opts.manifest = newManifest(flag.CommandLine)
+		if *manifestKey != "" {
+			key, err := readSigningKey(*manifestKey)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.manifestKey = key
+		}
+	}
+	if *decryptPath != "" {
+		if *decryptKey == "" {
+			fmt.Fprintln(os.Stderr, "Error: --decrypt needs --decrypt-key")
+			os.Exit(1)
+		}
+		plain, err := decryptCrop(*decryptPath, *decryptKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(plain)
+		return
+	}
+	if opts.suppressBursts && (opts.datasetDir != "" || opts.tfrecordPrefix != "" || opts.faceStrips != "" || opts.print0 != nil) {
+		fmt.Fprintln(os.Stderr, "Error: --suppress-bursts removes crops at the end of the run and cannot be combined with --export-dataset, --export-tfrecord, --face-strips or --print0")
+		os.Exit(1)
+	}
+	if opts.duplicateDistance < 0 || opts.duplicateDistance > 64 {
+		fmt.Fprintln(os.Stderr, "Error: --duplicate-distance must be between 0 and 64")
+		os.Exit(1)
+	}
+	if err := validateFaceOrder(opts.faceOrder); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := validateExistingFaces(opts.existingFaces); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := validateCompression(opts.tfrecordCompression); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.tfrecordShards < 1 {
+		fmt.Fprintln(os.Stderr, "Error: --tfrecord-shards must be at least 1")
+		os.Exit(1)
+	}
+	if (opts.compareA == "") != (opts.compareB == "") {
+		fmt.Fprintln(os.Stderr, "Error: --backend-a and --backend-b go together")
+		os.Exit(1)
+	}
+	if (opts.tlsCert == "") != (opts.tlsKey == "") || (opts.tlsClientCA != "" && opts.tlsCert == "") {
+		fmt.Fprintln(os.Stderr, "Error: --tls-cert and --tls-key go together, and --tls-client-ca needs both")
+		os.Exit(1)
+	}
+	if ioRetry.retries < 0 || ioRetry.backoff <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: --io-retries must not be negative and --io-backoff must be positive")
+		os.Exit(1)
+	}
+	timeouts, err := parseHostTimeouts(hostTimeouts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	outbound.hostTimeouts = timeouts
+	if *maxBandwidth != "" {
+		if outbound.maxBandwidth, err = parseByteSize(*maxBandwidth); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --max-bandwidth: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if opts.countWindows, err = parseDurations(*countWindows); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --count-windows: %v\n", err)
+		os.Exit(1)
+	}
+	// Model downloads, notifications and cluster workers all use the
+	// default transport.
+	if http.DefaultTransport, err = outbound.transport(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	sh, err := parseShard(*shardSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts.shard = sh
+	if opts.taskSize < 1 {
+		fmt.Fprintln(os.Stderr, "Error: --task-size must be at least 1")
+		os.Exit(1)
+	}
+	if opts.workers < 1 {
+		fmt.Fprintln(os.Stderr, "Error: --workers must be at least 1")
+		os.Exit(1)
+	}
+	if opts.batchSize < 1 {
+		fmt.Fprintln(os.Stderr, "Error: --batch-size must be at least 1")
+		os.Exit(1)
+	}
+	if opts.decodeWorkers < 1 || opts.encodeWorkers < 1 {
+		fmt.Fprintln(os.Stderr, "Error: --decode-workers and --encode-workers must be at least 1")
+		os.Exit(1)
+	}
+	if opts.detectEvery < 1 {
+		fmt.Fprintln(os.Stderr, "Error: --detect-every must be at least 1")
+		os.Exit(1)
+	}
+	if opts.boxSmoothing < 0 || opts.boxSmoothing >= 1 {
+		fmt.Fprintln(os.Stderr, "Error: --box-smoothing must be at least 0 and below 1")
+		os.Exit(1)
+	}
+	if opts.tileSize < 0 {
+		fmt.Fprintln(os.Stderr, "Error: --tile must not be negative")
+		os.Exit(1)
+	}
+	if opts.tileOverlap < 0 || opts.tileOverlap >= 1 {
+		fmt.Fprintln(os.Stderr, "Error: --tile-overlap must be at least 0 and below 1")
+		os.Exit(1)
+	}
+	if err := validateTimelineFormat(opts.timeline); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := facedetect.ValidateDevice(detCfg.device); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := validateDecoder(opts.decoder); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	interp, err := parseInterpolation(*interpolation)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts.interpolation = interp
+	if opts.limits.maxFileSize, err = parseByteSize(*maxFileSize); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.maxUploadSize, err = parseByteSize(*maxUploadSize); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.maxExtractSize, err = parseByteSize(*maxExtractSize); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.maxMemory, err = parseByteSize(*maxMemory); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.augment, err = parseAugmentations(*augmentations, *augmentSeed, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.fileMode, err = outputFileMode(*chmod); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.preserve, err = parsePreserve(*preserve); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.labelColor, err = parseColor(*labelColor); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *smartCrop != "" {
+		if opts.smartCrop, err = parseSize(*smartCrop); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+	if detCfg.modelDir == "" {
+		dir, err := defaultModelDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		detCfg.modelDir = dir
+	}
+	detCfg.cascades, detCfg.models, detCfg.plugins = cascades, models, plugins
+	if *backendChain != "" {
+		if len(cascades) > 0 || len(models) > 0 || len(plugins) > 0 || opts.compareA != "" {
+			fmt.Fprintln(os.Stderr, "Error: --backend-chain replaces --cascade, --model, --plugin and --backend-a/--backend-b")
+			os.Exit(1)
+		}
+		if detCfg, err = parseBackendChain(*backendChain, detCfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var cfgA, cfgB detectorConfig
+	if opts.compareA != "" {
+		if cfgA, err = parseDetectorSpec(opts.compareA, detCfg); err == nil {
+			cfgB, err = parseDetectorSpec(opts.compareB, detCfg)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	det, err := loadDetectors(detCfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading detectors: %v\n", err)
+		os.Exit(1)
+	}
+	defer det.Close()
+	if opts.manifest != nil {
+		paths, err := detCfg.modelFiles()
+		if err == nil {
+			for _, p := range paths {
+				if err = opts.manifest.addModel(p); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error recording models in the manifest: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if opts.cropScope == cropScopePerson {
+		if det.people, err = newPersonDetector(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading person detector: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *redactPlates {
+		if err := det.loadPlates(detCfg.modelDir, *plateCascade); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading plate cascade: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *cachePath != "" {
+		// Besides the settings, the content of every model, plugin and mask
+		// decides what is detected.
+		files, err := detCfg.inputFiles()
+		if err == nil && *redactPlates {
+			var plates string
+			plates, err = modelPath(detCfg.modelDir, *plateCascade)
+			files = append(files, plates)
+		}
+		if opts.ignore.mask.path != "" {
+			files = append(files, opts.ignore.mask.path)
+		}
+		var digests []string
+		if err == nil {
+			digests, err = fileDigests(files)
+		}
+		if err == nil {
+			det.cache, err = openDetectionCache(*cachePath, detCfg, opts.resizeMode, opts.maxWidth, opts.maxHeight,
+				opts.interpolation, opts.tileSize, opts.tileOverlap, opts.rotations, opts.roi, opts.ignore.rects, opts.ignore.mask.path, opts.cropScope, *redactPlates, *plateCascade, digests)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer det.cache.Close()
+	}
+
+	if err := os.MkdirAll(opts.outputDir, os.ModePerm); err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating output directory: %v\n", err)
 		os.Exit(1)
 	}
 
-	if err := processImages(inputDir, outputDir, maxWidth, maxHeight); err != nil {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		fmt.Fprintln(os.Stderr, "Interrupted, finishing the current image (press Ctrl-C again to abort)")
+		cancel()
+		// Restore default signal handling so a second Ctrl-C kills us.
+		signal.Stop(sigs)
+	}()
+
+	run := processImages
+	switch {
+	case opts.serveAddr != "":
+		run = runServer
+	case opts.coordinateAddr != "":
+		run = runCoordinator
+	case opts.workerOf != "":
+		run = runWorker
+	case opts.camera != "":
+		run = runCamera
+	case opts.tuneLabels != "":
+		run = func(ctx context.Context, opts *options, det *detectors) error {
+			return runTune(ctx, opts, det, opts.tuneLabels, *configPath)
+		}
+	case opts.compareA != "":
+		run = func(ctx context.Context, opts *options, _ *detectors) error {
+			return runCompare(ctx, opts, cfgA, cfgB)
+		}
+	}
+	stopProfiling, err := startProfiling(*pprofAddr, *cpuProfile, *memProfile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	err = run(ctx, opts, det)
+	stopProfiling()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if errors.Is(err, errInterrupted) {
+			os.Exit(130)
+		}
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	manifestName   = "manifest.json"
+	manifestSigExt = ".sig"
+)
+
+// manifest records what a run wrote and how: the files with their SHA-256,
+// the build that wrote them, every flag value and the digests of the
+// detection models. With --manifest-key it is signed with Ed25519, the
+// base64 signature going to manifest.json.sig.
+type manifest struct {
+	Version    string            `json:"version"`
+	Generated  time.Time         `json:"generated"`
+	Parameters map[string]string `json:"parameters"`
+	Models     []manifestFile    `json:"models,omitempty"`
+	Files      []manifestFile    `json:"files"`
+}
+
+// manifestFile is an output, relative to the manifest, or a model by name.
+type manifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// toolVersion describes the running build: its module version and, when
+// built from a checkout, the commit.
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			version += " " + s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				version += "-dirty"
+			}
+		}
+	}
+	return version
+}
+
+// newManifest starts a manifest for a run configured by fs.
+func newManifest(fs *flag.FlagSet) *manifest {
+	m := &manifest{Version: toolVersion(), Parameters: map[string]string{}}
+	fs.VisitAll(func(f *flag.Flag) {
+		m.Parameters[f.Name] = f.Value.String()
+	})
+	return m
+}
+
+func describeFile(path, name string) (manifestFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return manifestFile{}, err
+	}
+	digest, err := fileSHA256(path)
+	if err != nil {
+		return manifestFile{}, err
+	}
+	return manifestFile{Path: filepath.ToSlash(name), Size: info.Size(), SHA256: digest}, nil
+}
+
+// addModel records the digest of a model file the detectors use.
+func (m *manifest) addModel(path string) error {
+	f, err := describeFile(path, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	m.Models = append(m.Models, f)
+	return nil
+}
+
+// write hashes the outputs still on disk and writes the manifest, and its
+// signature when key is set, to dir.
+func (m *manifest) write(dir string, outputs []string, key ed25519.PrivateKey, mode os.FileMode) error {
+	m.Generated = time.Now().UTC()
+	m.Files = []manifestFile{}
+	seen := map[string]bool{}
+	for _, p := range outputs {
+		if p == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			rel = p
+		}
+		f, err := describeFile(p, rel)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, f)
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	path := filepath.Join(dir, manifestName)
+	if err := writeFileAtomic(path, data, mode); err != nil {
+		return err
+	}
+	if key == nil {
+		return nil
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	return writeFileAtomic(path+manifestSigExt, []byte(sig+"\n"), mode)
+}
+
+// readSigningKey reads an Ed25519 private key in PEM, as written by
+// openssl genpkey -algorithm ed25519.
+func readSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %v", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 private key", path)
+	}
+	return priv, nil
+}
+
+func readVerifyKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %v", path, err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 public key", path)
+	}
+	return pub, nil
+}
+
+// checkManifest verifies the manifest at path: its signature with the
+// public key at pubPath, when given, and the size and digest of every file
+// it lists. Problems are written to w; the error says how many there were.
+func checkManifest(path, pubPath string, w io.Writer) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %v", err)
+	}
+	if pubPath != "" {
+		pub, err := readVerifyKey(pubPath)
+		if err != nil {
+			return err
+		}
+		sig, err := os.ReadFile(path + manifestSigExt)
+		if err != nil {
+			return fmt.Errorf("failed to read signature: %v", err)
+		}
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil || !ed25519.Verify(pub, data, raw) {
+			return errors.New("manifest signature is not valid")
+		}
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("failed to parse manifest: %v", err)
+	}
+
+	dir := filepath.Dir(path)
+	bad := 0
+	for _, f := range m.Files {
+		got, err := describeFile(filepath.Join(dir, filepath.FromSlash(f.Path)), f.Path)
+		switch {
+		case err != nil:
+			fmt.Fprintf(w, "%s: %v\n", f.Path, err)
+		case got.Size != f.Size || got.SHA256 != f.SHA256:
+			fmt.Fprintf(w, "%s: modified\n", f.Path)
+		default:
+			continue
+		}
+		bad++
+	}
+	if bad > 0 {
+		return fmt.Errorf("%d of %d files do not match the manifest", bad, len(m.Files))
+	}
+	fmt.Fprintf(w, "All %d files match the manifest\n", len(m.Files))
+	return nil
+}
@@ -0,0 +1,120 @@
+//go:build !purego
+
+package main
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryBudget holds back new images while the process is over --max-memory.
+// One image is always let through so a batch never stalls on its own
+// baseline. A nil budget admits everything.
+type memoryBudget struct {
+	limit int64
+
+	mu       sync.Mutex
+	inFlight int
+	freed    chan struct{}
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &memoryBudget{limit: limit, freed: make(chan struct{}, 1)}
+}
+
+// acquire waits until another image fits in the budget. When over it, cached
+// Mats and free Go heap are handed back before waiting on images in flight.
+func (m *memoryBudget) acquire(ctx context.Context) error {
+	if m == nil {
+		return nil
+	}
+	for {
+		m.mu.Lock()
+		if m.inFlight == 0 || memoryInUse() < m.limit {
+			m.inFlight++
+			m.mu.Unlock()
+			return nil
+		}
+		m.mu.Unlock()
+
+		releaseMemory()
+		if memoryInUse() < m.limit {
+			continue
+		}
+		select {
+		case <-m.freed:
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// tryAcquire is acquire without waiting: it reports false when another
+// image does not fit in the budget right now.
+func (m *memoryBudget) tryAcquire() bool {
+	if m == nil {
+		return true
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		m.mu.Lock()
+		if m.inFlight == 0 || memoryInUse() < m.limit {
+			m.inFlight++
+			m.mu.Unlock()
+			return true
+		}
+		m.mu.Unlock()
+		releaseMemory()
+	}
+	return false
+}
+
+func (m *memoryBudget) release() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.inFlight--
+	m.mu.Unlock()
+	select {
+	case m.freed <- struct{}{}:
+	default:
+	}
+}
+
+// releaseMemory closes pooled Mats and returns unused Go heap to the OS.
+func releaseMemory() {
+	for {
+		select {
+		case m := <-matPool:
+			m.Close()
+		default:
+			debug.FreeOSMemory()
+			return
+		}
+	}
+}
+
+// memoryInUse is the resident set size where /proc is available, which
+// includes OpenCV's C heap, and the Go runtime's own total elsewhere.
+func memoryInUse() int64 {
+	if data, err := os.ReadFile("/proc/self/statm"); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 1 {
+			if pages, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				return pages * int64(os.Getpagesize())
+			}
+		}
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return int64(stats.Sys - stats.HeapReleased)
+}
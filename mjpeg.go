@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+const mjpegBoundary = "frame"
+
+// broadcaster fans messages out to any number of subscribers. Slow
+// subscribers miss messages rather than holding up the publisher.
+type broadcaster struct {
+	mu      sync.Mutex
+	clients map[chan []byte]struct{}
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{clients: map[chan []byte]struct{}{}}
+}
+
+func (b *broadcaster) subscribe() (<-chan []byte, func()) {
+	c := make(chan []byte, 1)
+	b.mu.Lock()
+	b.clients[c] = struct{}{}
+	b.mu.Unlock()
+	return c, func() {
+		b.mu.Lock()
+		delete(b.clients, c)
+		b.mu.Unlock()
+	}
+}
+
+// watched reports whether anyone is subscribed, so messages need not be
+// built otherwise.
+func (b *broadcaster) watched() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.clients) > 0
+}
+
+func (b *broadcaster) publish(msg []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		select {
+		case c <- msg:
+		default:
+		}
+	}
+}
+
+// mjpegStream serves published JPEG frames as a multipart/x-mixed-replace
+// stream, viewable in browsers and most NVRs.
+type mjpegStream struct {
+	*broadcaster
+}
+
+func newMJPEGStream() *mjpegStream {
+	return &mjpegStream{newBroadcaster()}
+}
+
+func (s *mjpegStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	frames, unsubscribe := s.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case jpeg := <-frames:
+			if _, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, len(jpeg)); err != nil {
+				return
+			}
+			// The frame is shared with every other viewer; never append to it.
+			if _, err := w.Write(jpeg); err != nil {
+				return
+			}
+			if _, err := io.WriteString(w, "\r\n"); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
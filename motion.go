@@ -0,0 +1,49 @@
+//go:build !purego
+
+package main
+
+import (
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// motionDelta is the per-pixel grey level change that counts as motion.
+const motionDelta = 25
+
+// motionGate decides by frame differencing whether anything moved, so an
+// idle camera can skip face detection.
+type motionGate struct {
+	threshold float64
+	prev      gocv.Mat
+}
+
+func newMotionGate(threshold float64) *motionGate {
+	return &motionGate{threshold: threshold, prev: gocv.NewMat()}
+}
+
+// moved reports whether more than the threshold fraction of pixels changed
+// since the previous frame. The first frame always counts as motion.
+func (g *motionGate) moved(frame gocv.Mat) bool {
+	gray := gocv.NewMat()
+	gocv.CvtColor(frame, &gray, gocv.ColorBGRToGray)
+	gocv.GaussianBlur(gray, &gray, image.Pt(21, 21), 0, 0, gocv.BorderDefault)
+
+	prev := g.prev
+	g.prev = gray
+	defer prev.Close()
+	if prev.Empty() || prev.Rows() != gray.Rows() || prev.Cols() != gray.Cols() {
+		return true
+	}
+
+	diff := gocv.NewMat()
+	defer diff.Close()
+	gocv.AbsDiff(prev, gray, &diff)
+	gocv.Threshold(diff, &diff, motionDelta, 255, gocv.ThresholdBinary)
+	changed := float64(gocv.CountNonZero(diff)) / float64(diff.Rows()*diff.Cols())
+	return changed > g.threshold
+}
+
+func (g *motionGate) Close() {
+	g.prev.Close()
+}
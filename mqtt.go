@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const mqttTimeout = 10 * time.Second
+
+// mqttPublisher reports face presence to an MQTT broker. The state topic
+// carries a retained JSON payload whose "state" is ON while faces are in
+// view, which Home Assistant reads as a binary sensor; with discovery the
+// sensor is registered automatically.
+type mqttPublisher struct {
+	client mqtt.Client
+	topic  string
+	last   string
+
+	// countOnly leaves the track ids out of the payload.
+	countOnly bool
+}
+
+// mqttPayload is the state message. Faces lists track ids.
+type mqttPayload struct {
+	State  string    `json:"state"`
+	Source string    `json:"source"`
+	Count  int       `json:"count"`
+	Faces  []int     `json:"faces"`
+	Time   time.Time `json:"time"`
+}
+
+// newMQTTPublisher connects to broker (e.g. tcp://localhost:1883).
+// Credentials come from MQTT_USERNAME and MQTT_PASSWORD.
+func newMQTTPublisher(broker, topic, source string, discovery bool) (*mqttPublisher, error) {
+	clientOpts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(fmt.Sprintf("face-detector-%d", os.Getpid())).
+		SetUsername(os.Getenv("MQTT_USERNAME")).
+		SetPassword(os.Getenv("MQTT_PASSWORD")).
+		SetAutoReconnect(true)
+	client := mqtt.NewClient(clientOpts)
+	token := client.Connect()
+	if !token.WaitTimeout(mqttTimeout) {
+		return nil, fmt.Errorf("timed out connecting to MQTT broker %s", broker)
+	}
+	if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker %s: %v", broker, err)
+	}
+
+	p := &mqttPublisher{client: client, topic: topic}
+	if discovery {
+		if err := p.announce(source); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// announce publishes a Home Assistant discovery config for a binary sensor
+// named after the source.
+func (p *mqttPublisher) announce(source string) error {
+	id := "face_detector_" + slugify(source)
+	config := map[string]interface{}{
+		"name":                  "Face at " + source,
+		"unique_id":             id,
+		"device_class":          "occupancy",
+		"state_topic":           p.topic,
+		"value_template":        "{{ value_json.state }}",
+		"json_attributes_topic": p.topic,
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return p.send("homeassistant/binary_sensor/"+id+"/config", data)
+}
+
+// update publishes the faces in view when they differ from the last update.
+func (p *mqttPublisher) update(source string, faces []int) error {
+	payload := mqttPayload{State: "OFF", Source: source, Count: len(faces), Faces: faces, Time: time.Now()}
+	if len(faces) > 0 {
+		payload.State = "ON"
+	}
+	key := fmt.Sprint(faces)
+	if p.countOnly {
+		payload.Faces = []int{}
+		key = fmt.Sprint(len(faces))
+	}
+	if key == p.last {
+		return nil
+	}
+	p.last = key
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return p.send(p.topic, data)
+}
+
+func (p *mqttPublisher) send(topic string, data []byte) error {
+	token := p.client.Publish(topic, 1, true, data)
+	if !token.WaitTimeout(mqttTimeout) {
+		return fmt.Errorf("timed out publishing to %s", topic)
+	}
+	return token.Error()
+}
+
+func (p *mqttPublisher) Close() {
+	p.client.Disconnect(250)
+}
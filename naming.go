@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// outputNames are the file names derived from one input: the annotated image
+// path and the stem used for its face, group and smart crops.
+type outputNames struct {
+	annotated string
+	stem      string
+}
+
+func dirHash(path string) string {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		dir = filepath.Dir(path)
+	}
+	sum := sha256.Sum256([]byte(dir))
+	return hex.EncodeToString(sum[:4])
+}
+
+// planOutputNames assigns output names to every input so that no two inputs
+// write to the same files. Unique names keep the historical scheme
+// (output_a.jpg.webp, a_face_1.webp); a.jpg next to a.png gets the extension
+// folded into the crop stem (a_jpg_face_1.webp), and identical file names in
+// different directories get a short hash of the directory appended.
+func planOutputNames(inputs []string, outputDir string) map[string]outputNames {
+	baseCount := map[string]int{}
+	stemCount := map[string]int{}
+	for _, in := range inputs {
+		base := filepath.Base(in)
+		baseCount[base]++
+		stemCount[strings.TrimSuffix(base, filepath.Ext(base))]++
+	}
+
+	names := make(map[string]outputNames, len(inputs))
+	for _, in := range inputs {
+		base := filepath.Base(in)
+		ext := filepath.Ext(base)
+		stem := strings.TrimSuffix(base, ext)
+
+		suffix := ""
+		if baseCount[base] > 1 {
+			suffix = "_" + dirHash(in)
+		}
+		cropStem := stem
+		if stemCount[stem] > 1 && ext != "" {
+			cropStem += "_" + strings.TrimPrefix(ext, ".")
+		}
+
+		names[in] = outputNames{
+			annotated: filepath.Join(outputDir, fmt.Sprintf("output_%s%s%s.webp", stem, suffix, ext)),
+			stem:      cropStem + suffix,
+		}
+	}
+	return names
+}
+
+// slugify lowercases s and replaces anything but letters and digits with
+// underscores, for names built from user-supplied labels.
+func slugify(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(s))
+}
@@ -0,0 +1,64 @@
+//go:build !purego
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// negativeSink collects false detections as negative samples for training a
+// cascade: each region is written as a PNG, and its path appended to
+// negatives.txt, the background description file opencv_traincascade reads
+// with -bg.
+type negativeSink struct {
+	dir   string
+	mode  os.FileMode
+	list  *os.File
+	count int
+}
+
+func newNegativeSink(dir string, mode os.FileMode) (*negativeSink, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create negatives directory: %v", err)
+	}
+	list, err := os.OpenFile(filepath.Join(dir, "negatives.txt"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, mode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open negatives list: %v", err)
+	}
+	return &negativeSink{dir: dir, mode: mode, list: list}, nil
+}
+
+// save writes rect of img, taken from the image at source, as a negative.
+func (n *negativeSink) save(img gocv.Mat, rect image.Rectangle, source string) error {
+	rect = rect.Intersect(image.Rect(0, 0, img.Cols(), img.Rows()))
+	if rect.Empty() {
+		return nil
+	}
+	region := img.Region(rect)
+	defer region.Close()
+
+	stem := strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))
+	name := fmt.Sprintf("%s_%d_%d_%dx%d.png", stem, rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+	path := filepath.Join(n.dir, name)
+	if !gocv.IMWrite(path, region) {
+		return fmt.Errorf("failed to write negative sample %s", path)
+	}
+	if err := os.Chmod(path, n.mode); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(n.list, name); err != nil {
+		return err
+	}
+	n.count++
+	return nil
+}
+
+func (n *negativeSink) Close() error {
+	return n.list.Close()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const notifyTimeout = 30 * time.Second
+
+// notifier sends an alert with an annotated JPEG snapshot.
+type notifier interface {
+	notify(text string, jpeg []byte) error
+}
+
+// newNotifiers builds the notifiers enabled on the command line. Bot tokens
+// are read from SLACK_BOT_TOKEN and TELEGRAM_BOT_TOKEN.
+func newNotifiers(slackChannel, telegramChat string) ([]notifier, error) {
+	var list []notifier
+	if slackChannel != "" {
+		token := os.Getenv("SLACK_BOT_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("--slack-channel needs SLACK_BOT_TOKEN")
+		}
+		list = append(list, &slackNotifier{token: token, channel: slackChannel})
+	}
+	if telegramChat != "" {
+		token := os.Getenv("TELEGRAM_BOT_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("--telegram-chat needs TELEGRAM_BOT_TOKEN")
+		}
+		list = append(list, &telegramNotifier{token: token, chat: telegramChat})
+	}
+	return list, nil
+}
+
+// alerter rate-limits notifications: after an alert, further ones are
+// dropped until interval has passed. Sending happens in the background so
+// a slow API does not stall the camera loop.
+type alerter struct {
+	notifiers []notifier
+	interval  time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+	wg   sync.WaitGroup
+}
+
+func (a *alerter) alert(text string, jpeg []byte) {
+	a.mu.Lock()
+	if !a.last.IsZero() && time.Since(a.last) < a.interval {
+		a.mu.Unlock()
+		return
+	}
+	a.last = time.Now()
+	a.mu.Unlock()
+
+	for _, n := range a.notifiers {
+		a.wg.Add(1)
+		go func(n notifier) {
+			defer a.wg.Done()
+			if err := n.notify(text, jpeg); err != nil {
+				fmt.Printf("Error sending notification: %v\n", err)
+			}
+		}(n)
+	}
+}
+
+// wait blocks until notifications in flight have been sent.
+func (a *alerter) wait() {
+	a.wg.Wait()
+}
+
+var notifyClient = &http.Client{Timeout: notifyTimeout}
+
+// slackNotifier uploads the snapshot to a channel with the Slack Web API's
+// external upload flow.
+type slackNotifier struct {
+	token   string
+	channel string
+}
+
+func (s *slackNotifier) notify(text string, jpeg []byte) error {
+	var ticket struct {
+		OK        bool   `json:"ok"`
+		Error     string `json:"error"`
+		UploadURL string `json:"upload_url"`
+		FileID    string `json:"file_id"`
+	}
+	query := url.Values{"filename": {"snapshot.jpg"}, "length": {strconv.Itoa(len(jpeg))}}
+	if err := s.call("files.getUploadURLExternal", query, nil, &ticket); err != nil {
+		return err
+	}
+	if !ticket.OK {
+		return fmt.Errorf("slack: %s", ticket.Error)
+	}
+
+	resp, err := notifyClient.Post(ticket.UploadURL, "image/jpeg", bytes.NewReader(jpeg))
+	if err != nil {
+		return fmt.Errorf("slack upload failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack upload failed: %s", resp.Status)
+	}
+
+	complete := map[string]interface{}{
+		"files":           []map[string]string{{"id": ticket.FileID, "title": text}},
+		"channel_id":      s.channel,
+		"initial_comment": text,
+	}
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := s.call("files.completeUploadExternal", nil, complete, &result); err != nil {
+		return err
+	}
+	if !result.OK {
+		return fmt.Errorf("slack: %s", result.Error)
+	}
+	return nil
+}
+
+func (s *slackNotifier) call(method string, query url.Values, body, result interface{}) error {
+	var req *http.Request
+	var err error
+	endpoint := "https://slack.com/api/" + method
+	if body == nil {
+		req, err = http.NewRequest(http.MethodGet, endpoint+"?"+query.Encode(), nil)
+	} else {
+		var data []byte
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+		req, err = http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		}
+	}
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("slack %s failed: %v", method, err)
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// telegramNotifier posts the snapshot to a chat with the Bot API.
+type telegramNotifier struct {
+	token string
+	chat  string
+}
+
+func (t *telegramNotifier) notify(text string, jpeg []byte) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("chat_id", t.chat)
+	w.WriteField("caption", text)
+	part, err := w.CreateFormFile("photo", "snapshot.jpg")
+	if err != nil {
+		return err
+	}
+	part.Write(jpeg)
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	resp, err := notifyClient.Post("https://api.telegram.org/bot"+t.token+"/sendPhoto", w.FormDataContentType(), &body)
+	if err != nil {
+		// The *url.Error text includes the URL, and with it the token.
+		return fmt.Errorf("telegram sendPhoto failed: %v", errors.Unwrap(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("telegram sendPhoto failed: %s: %s", resp.Status, msg)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+//go:build !purego
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseDurations reads a comma-separated list such as --count-windows.
+func parseDurations(s string) ([]time.Duration, error) {
+	var ds []time.Duration
+	for _, part := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid duration %q", part)
+		}
+		ds = append(ds, d)
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds, nil
+}
+
+// occupancy keeps rolling counts of the distinct face tracks seen by a
+// camera, for simple footfall analytics: how many are in view now, how many
+// were seen within each trailing window, and how many since the start.
+type occupancy struct {
+	source  string
+	windows []time.Duration
+
+	mu       sync.Mutex
+	lastSeen map[int]time.Time
+	inView   int
+	total    int
+}
+
+func newOccupancy(source string, windows []time.Duration) *occupancy {
+	return &occupancy{source: source, windows: windows, lastSeen: map[int]time.Time{}}
+}
+
+// observe records the tracks in a frame.
+func (o *occupancy) observe(tracks []*track, now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, t := range tracks {
+		if _, ok := o.lastSeen[t.id]; !ok {
+			o.total++
+		}
+		o.lastSeen[t.id] = now
+	}
+	o.inView = len(tracks)
+
+	// Tracks older than the longest window no longer count anywhere.
+	if len(o.windows) > 0 {
+		horizon := now.Add(-o.windows[len(o.windows)-1])
+		for id, seen := range o.lastSeen {
+			if seen.Before(horizon) {
+				delete(o.lastSeen, id)
+			}
+		}
+	}
+}
+
+// occupancyCounts is the JSON served at /counts. Windows maps each window,
+// e.g. "15m0s", to the distinct tracks seen within it.
+type occupancyCounts struct {
+	Source  string         `json:"source"`
+	Time    time.Time      `json:"time"`
+	InView  int            `json:"in_view"`
+	Total   int            `json:"total"`
+	Windows map[string]int `json:"windows"`
+}
+
+func (o *occupancy) counts(now time.Time) occupancyCounts {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	c := occupancyCounts{Source: o.source, Time: now, InView: o.inView, Total: o.total, Windows: map[string]int{}}
+	for _, w := range o.windows {
+		n := 0
+		for _, seen := range o.lastSeen {
+			if now.Sub(seen) <= w {
+				n++
+			}
+		}
+		c.Windows[w.String()] = n
+	}
+	return c
+}
+
+func (o *occupancy) handleCounts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, o.counts(time.Now()))
+}
+
+// handleMetrics serves the counts in the Prometheus text format.
+func (o *occupancy) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	c := o.counts(time.Now())
+	source := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(c.Source)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP face_detector_faces_in_view Face tracks in the latest frame.\n")
+	fmt.Fprintf(w, "# TYPE face_detector_faces_in_view gauge\n")
+	fmt.Fprintf(w, "face_detector_faces_in_view{source=\"%s\"} %d\n", source, c.InView)
+	fmt.Fprintf(w, "# HELP face_detector_face_tracks_total Distinct face tracks seen since start.\n")
+	fmt.Fprintf(w, "# TYPE face_detector_face_tracks_total counter\n")
+	fmt.Fprintf(w, "face_detector_face_tracks_total{source=\"%s\"} %d\n", source, c.Total)
+	fmt.Fprintf(w, "# HELP face_detector_face_tracks_window Distinct face tracks seen within the trailing window.\n")
+	fmt.Fprintf(w, "# TYPE face_detector_face_tracks_window gauge\n")
+	for _, win := range o.windows {
+		fmt.Fprintf(w, "face_detector_face_tracks_window{source=\"%s\",window=\"%s\"} %d\n", source, win, c.Windows[win.String()])
+	}
+}
@@ -0,0 +1,215 @@
+//go:build !purego
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+
+	ort "github.com/yalue/onnxruntime_go"
+	"gocv.io/x/gocv"
+)
+
+const (
+	onnxInputSize = 640
+	onnxNMS       = 0.4
+)
+
+var (
+	ortInitOnce sync.Once
+	ortInitErr  error
+)
+
+// initONNXRuntime loads the ONNX Runtime shared library. It is opened at
+// run time, so builds do not need ONNX Runtime installed.
+func initONNXRuntime(libPath string) error {
+	ortInitOnce.Do(func() {
+		if libPath != "" {
+			ort.SetSharedLibraryPath(libPath)
+		}
+		ortInitErr = ort.InitializeEnvironment()
+	})
+	return ortInitErr
+}
+
+// onnxDetector runs SCRFD or RetinaFace models with ONNX Runtime. The layout
+// is recognised from the outputs: SCRFD exports have 6 or 9 (score, box and
+// optional keypoint tensors for strides 8, 16 and 32), RetinaFace exports
+// have 3 (loc, conf, landmarks over prior boxes).
+type onnxDetector struct {
+	session    *ort.DynamicAdvancedSession
+	outputs    int
+	retinaFace bool
+	width      int
+	height     int
+
+	minConfidence float32
+}
+
+func newONNXDetector(path, libPath string, minConfidence float64) (*onnxDetector, error) {
+	if err := initONNXRuntime(libPath); err != nil {
+		return nil, fmt.Errorf("failed to initialize ONNX Runtime: %v", err)
+	}
+
+	inputs, outputs, err := ort.GetInputOutputInfo(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ONNX model %s: %v", path, err)
+	}
+	if len(inputs) != 1 {
+		return nil, fmt.Errorf("ONNX model %s has %d inputs, want 1", path, len(inputs))
+	}
+	d := &onnxDetector{outputs: len(outputs), width: onnxInputSize, height: onnxInputSize, minConfidence: float32(minConfidence)}
+	switch len(outputs) {
+	case 3:
+		d.retinaFace = true
+	case 6, 9:
+	default:
+		return nil, fmt.Errorf("ONNX model %s has %d outputs; expected a SCRFD (6 or 9) or RetinaFace (3) export", path, len(outputs))
+	}
+	// Fixed-size exports dictate the input size; dynamic ones use the default.
+	if dims := inputs[0].Dimensions; len(dims) == 4 && dims[2] > 0 && dims[3] > 0 {
+		d.height, d.width = int(dims[2]), int(dims[3])
+	}
+
+	outputNames := make([]string, len(outputs))
+	for i, o := range outputs {
+		outputNames[i] = o.Name
+	}
+	d.session, err = ort.NewDynamicAdvancedSession(path, []string{inputs[0].Name}, outputNames, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error loading ONNX model %s: %v", path, err)
+	}
+	return d, nil
+}
+
+func (d *onnxDetector) Detect(img gocv.Mat) ([]Detection, error) {
+	// Letterbox into the network input, padding right and bottom.
+	scale := min(float64(d.width)/float64(img.Cols()), float64(d.height)/float64(img.Rows()))
+	resized := gocv.NewMat()
+	defer resized.Close()
+	gocv.Resize(img, &resized, image.Pt(max(1, int(float64(img.Cols())*scale)), max(1, int(float64(img.Rows())*scale))), 0, 0, gocv.InterpolationLinear)
+	padded := gocv.NewMat()
+	defer padded.Close()
+	gocv.CopyMakeBorder(resized, &padded, 0, d.height-resized.Rows(), 0, d.width-resized.Cols(), gocv.BorderConstant, color.RGBA{})
+
+	var blob gocv.Mat
+	if d.retinaFace {
+		blob = gocv.BlobFromImage(padded, 1.0, image.Pt(d.width, d.height), gocv.NewScalar(104, 117, 123, 0), false, false)
+	} else {
+		blob = gocv.BlobFromImage(padded, 1.0/128, image.Pt(d.width, d.height), gocv.NewScalar(127.5, 127.5, 127.5, 0), true, false)
+	}
+	defer blob.Close()
+	data, err := blob.DataPtrFloat32()
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := ort.NewTensor(ort.NewShape(1, 3, int64(d.height), int64(d.width)), append([]float32(nil), data...))
+	if err != nil {
+		return nil, err
+	}
+	defer input.Destroy()
+
+	outputs := make([]ort.Value, d.outputs)
+	if err := d.session.Run([]ort.Value{input}, outputs); err != nil {
+		return nil, fmt.Errorf("ONNX inference failed: %v", err)
+	}
+	tensors := make([][]float32, len(outputs))
+	for i, o := range outputs {
+		defer o.Destroy()
+		t, ok := o.(*ort.Tensor[float32])
+		if !ok {
+			return nil, fmt.Errorf("ONNX output %d is not a float32 tensor", i)
+		}
+		tensors[i] = t.GetData()
+	}
+
+	var boxes []image.Rectangle
+	var scores []float32
+	if d.retinaFace {
+		boxes, scores = d.decodeRetinaFace(tensors)
+	} else {
+		boxes, scores = d.decodeSCRFD(tensors)
+	}
+
+	bounds := image.Rect(0, 0, img.Cols(), img.Rows())
+	var faces []Detection
+	for _, i := range gocv.NMSBoxes(boxes, scores, d.minConfidence, onnxNMS) {
+		rect := scaleRect(boxes[i], 1/scale, 1/scale).Intersect(bounds)
+		if !rect.Empty() {
+			faces = append(faces, Detection{Rect: rect, Confidence: float64(scores[i])})
+		}
+	}
+	return faces, nil
+}
+
+// decodeSCRFD turns per-stride distance predictions into boxes. Each grid
+// cell has two anchors.
+func (d *onnxDetector) decodeSCRFD(t [][]float32) ([]image.Rectangle, []float32) {
+	const anchors = 2
+	var boxes []image.Rectangle
+	var scores []float32
+	for level, stride := range []int{8, 16, 32} {
+		scoreT, boxT := t[level], t[level+3]
+		cols := d.width / stride
+		for i, score := range scoreT {
+			if score < d.minConfidence {
+				continue
+			}
+			cell := i / anchors
+			cx := float32((cell % cols) * stride)
+			cy := float32((cell / cols) * stride)
+			s := float32(stride)
+			boxes = append(boxes, image.Rect(
+				int(cx-boxT[i*4]*s), int(cy-boxT[i*4+1]*s),
+				int(cx+boxT[i*4+2]*s), int(cy+boxT[i*4+3]*s),
+			))
+			scores = append(scores, score)
+		}
+	}
+	return boxes, scores
+}
+
+// decodeRetinaFace applies the standard prior boxes (min sizes 16/32,
+// 64/128, 256/512 at strides 8, 16, 32, variances 0.1 and 0.2).
+func (d *onnxDetector) decodeRetinaFace(t [][]float32) ([]image.Rectangle, []float32) {
+	loc, conf := t[0], t[1]
+	minSizes := [][]int{{16, 32}, {64, 128}, {256, 512}}
+	var boxes []image.Rectangle
+	var scores []float32
+	p := 0
+	for level, stride := range []int{8, 16, 32} {
+		rows := int(math.Ceil(float64(d.height) / float64(stride)))
+		cols := int(math.Ceil(float64(d.width) / float64(stride)))
+		for y := 0; y < rows; y++ {
+			for x := 0; x < cols; x++ {
+				for _, size := range minSizes[level] {
+					if (p+1)*2 > len(conf) || (p+1)*4 > len(loc) {
+						return boxes, scores
+					}
+					score := conf[p*2+1]
+					if score >= d.minConfidence {
+						pcx := (float64(x) + 0.5) * float64(stride)
+						pcy := (float64(y) + 0.5) * float64(stride)
+						pw, ph := float64(size), float64(size)
+						cx := pcx + float64(loc[p*4])*0.1*pw
+						cy := pcy + float64(loc[p*4+1])*0.1*ph
+						w := pw * math.Exp(float64(loc[p*4+2])*0.2)
+						h := ph * math.Exp(float64(loc[p*4+3])*0.2)
+						boxes = append(boxes, image.Rect(int(cx-w/2), int(cy-h/2), int(cx+w/2), int(cy+h/2)))
+						scores = append(scores, score)
+					}
+					p++
+				}
+			}
+		}
+	}
+	return boxes, scores
+}
+
+func (d *onnxDetector) Close() error {
+	return d.session.Destroy()
+}
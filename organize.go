@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// unknownPerson is the folder for photos with faces nobody has named.
+const unknownPerson = "unknown"
+
+// organizer links each source photo into a folder per person in it, so a
+// flat dump becomes browsable by who is in the pictures. People are known by
+// their face names (see --takeout); a photo with several people is linked
+// into each of their folders.
+type organizer struct {
+	dir    string
+	linked int
+}
+
+func newOrganizer(dir string) (*organizer, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create organize directory: %v", err)
+	}
+	return &organizer{dir: dir}, nil
+}
+
+func (o *organizer) add(r imageResult) {
+	folders := map[string]bool{}
+	for _, b := range r.Boxes {
+		if b.Name != "" {
+			folders[slugify(b.Name)] = true
+		} else {
+			folders[unknownPerson] = true
+		}
+	}
+	for folder := range folders {
+		if err := o.link(r.Input, filepath.Join(o.dir, folder)); err != nil {
+			fmt.Printf("Error organizing %s: %v\n", r.Input, err)
+		}
+	}
+}
+
+// link puts src into dir under its own name. A file already there is taken
+// to be src from an earlier run when it is the same file or has the same
+// size; otherwise src gets a free name next to it.
+func (o *organizer) link(src, dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	dst := filepath.Join(dir, filepath.Base(src))
+	if existing, err := os.Stat(dst); err == nil {
+		info, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if os.SameFile(existing, info) || existing.Size() == info.Size() {
+			return nil
+		}
+		dst = freePath(dst)
+	}
+	if err := linkOrCopy(src, dst); err != nil {
+		return err
+	}
+	o.linked++
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// outboundConfig is how the tool reaches remote servers: model downloads,
+// notifications and cluster workers talking to their coordinator. Proxies
+// come from HTTP_PROXY, HTTPS_PROXY and NO_PROXY unless proxy is set.
+type outboundConfig struct {
+	proxy        string
+	caBundle     string
+	hostTimeouts map[string]time.Duration
+
+	// maxBandwidth caps uploads and downloads together, in bytes per
+	// second; 0 leaves them unlimited.
+	maxBandwidth int64
+}
+
+// parseHostTimeouts reads --host-timeout values of the form HOST=DURATION.
+func parseHostTimeouts(specs []string) (map[string]time.Duration, error) {
+	timeouts := map[string]time.Duration{}
+	for _, spec := range specs {
+		host, value, ok := strings.Cut(spec, "=")
+		if !ok || host == "" {
+			return nil, fmt.Errorf("invalid host timeout %q (want HOST=DURATION)", spec)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid host timeout %q (want HOST=DURATION)", spec)
+		}
+		timeouts[strings.ToLower(host)] = d
+	}
+	return timeouts, nil
+}
+
+// transport builds the HTTP transport for outbound requests.
+func (c outboundConfig) transport() (http.RoundTripper, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if c.proxy != "" {
+		u, err := url.Parse(c.proxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", c.proxy)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	if c.caBundle != "" {
+		pem, err := os.ReadFile(c.caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+		}
+		// Trust the bundle in addition to the system roots, so a corporate
+		// CA does not break access to public hosts.
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.caBundle)
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	var rt http.RoundTripper = t
+	if c.maxBandwidth > 0 {
+		rt = &throttledTransport{base: rt, limiter: rate.NewLimiter(rate.Limit(c.maxBandwidth), throttleChunk)}
+	}
+	if ioRetry.retries > 0 {
+		rt = &retryTransport{base: rt, policy: ioRetry}
+	}
+	if len(c.hostTimeouts) > 0 {
+		rt = &hostTimeoutTransport{base: rt, timeouts: c.hostTimeouts}
+	}
+	return rt, nil
+}
+
+// throttleChunk is the most read at once from a throttled body, and the
+// limiter's burst.
+const throttleChunk = 32 << 10
+
+// throttledTransport shares one bandwidth budget between the request and
+// response bodies of all requests.
+type throttledTransport struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(req.Context())
+		req.Body = &throttledBody{ReadCloser: req.Body, ctx: req.Context(), limiter: t.limiter}
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &throttledBody{ReadCloser: resp.Body, ctx: req.Context(), limiter: t.limiter}
+	return resp, nil
+}
+
+type throttledBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := b.limiter.WaitN(b.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// hostTimeoutTransport bounds whole requests, body included, to hosts with
+// a configured timeout.
+type hostTimeoutTransport struct {
+	base     http.RoundTripper
+	timeouts map[string]time.Duration
+}
+
+func (t *hostTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	d, ok := t.timeouts[strings.ToLower(req.URL.Hostname())]
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), d)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
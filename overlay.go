@@ -0,0 +1,90 @@
+//go:build !purego
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// parseColor accepts "#rrggbb" or "r,g,b".
+func parseColor(s string) (color.RGBA, error) {
+	if strings.HasPrefix(s, "#") && len(s) == 7 {
+		v, err := strconv.ParseUint(s[1:], 16, 32)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid color %q: %v", s, err)
+		}
+		return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+	}
+
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q (want #rrggbb or r,g,b)", s)
+	}
+	var rgb [3]uint8
+	for i, p := range parts {
+		v, err := strconv.ParseUint(strings.TrimSpace(p), 10, 8)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid color %q: %v", s, err)
+		}
+		rgb[i] = uint8(v)
+	}
+	return color.RGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: 255}, nil
+}
+
+// expandLabel fills in the {file}, {time}, {faces} and {names}
+// placeholders of a --label template for r. {time} is when the photo was
+// taken, or the current time when that is unknown; {names} lists the
+// people Takeout names, pseudonymized with --pseudonym-key.
+func expandLabel(template string, r imageResult, faces int) string {
+	taken, ok := captureTime(r)
+	if !ok {
+		taken = time.Now()
+	}
+	return strings.NewReplacer(
+		"{file}", filepath.Base(r.Input),
+		"{time}", taken.Format("2006-01-02 15:04:05"),
+		"{faces}", strconv.Itoa(faces),
+		"{names}", strings.Join(labelNames(r), ", "),
+	).Replace(template)
+}
+
+// labelNames lists the named faces of r in order, then anyone else in the
+// photo, each once.
+func labelNames(r imageResult) []string {
+	var names []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, b := range r.Boxes {
+		add(b.Name)
+	}
+	for _, p := range r.People {
+		add(p)
+	}
+	return names
+}
+
+// drawLabel writes text in the top-left corner of img on a dark backing box
+// so it stays readable on any background.
+func drawLabel(img *gocv.Mat, text string, scale float64, c color.RGBA) {
+	const font = gocv.FontHersheySimplex
+	thickness := max(1, int(scale*2))
+	size := gocv.GetTextSize(text, font, scale, thickness)
+	pad := max(4, size.Y/2)
+
+	box := image.Rect(0, 0, size.X+2*pad, size.Y+2*pad)
+	gocv.Rectangle(img, box, color.RGBA{0, 0, 0, 0}, -1)
+	gocv.PutText(img, text, image.Pt(pad, pad+size.Y), font, scale, c, thickness)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	preserveMode  = "mode"
+	preserveMtime = "mtime"
+	preserveOwner = "owner"
+)
+
+// outputFileMode is the mode for new outputs: the --chmod value verbatim, or
+// 0644 filtered through the process umask.
+func outputFileMode(chmod string) (os.FileMode, error) {
+	if chmod == "" {
+		return os.FileMode(0644 &^ currentUmask()), nil
+	}
+	mode, err := strconv.ParseUint(chmod, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid --chmod %q (want octal permissions such as 0640)", chmod)
+	}
+	return os.FileMode(mode), nil
+}
+
+func parsePreserve(list string) (map[string]bool, error) {
+	preserve := map[string]bool{}
+	if list == "" {
+		return preserve, nil
+	}
+	for _, attr := range strings.Split(list, ",") {
+		attr = strings.TrimSpace(attr)
+		switch attr {
+		case preserveMode, preserveMtime, preserveOwner:
+			preserve[attr] = true
+		default:
+			return nil, fmt.Errorf("unknown --preserve attribute %q (want mode, mtime or owner)", attr)
+		}
+	}
+	return preserve, nil
+}
+
+// preserveAttributes copies the requested attributes of the source file onto
+// every output produced from it.
+func preserveAttributes(result imageResult, preserve map[string]bool) error {
+	if len(preserve) == 0 {
+		return nil
+	}
+	info, err := os.Stat(result.Input)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range result.outputs() {
+		if p == "" {
+			continue
+		}
+		if preserve[preserveMode] {
+			if err := os.Chmod(p, info.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+		if preserve[preserveMtime] {
+			if err := os.Chtimes(p, info.ModTime(), info.ModTime()); err != nil {
+				return err
+			}
+		}
+		if preserve[preserveOwner] {
+			if err := chownLike(p, info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+func currentUmask() int {
+	return 0
+}
+
+func chownLike(path string, info os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,69 @@
+//go:build !purego
+
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	cropScopeFace   = "face"
+	cropScopePerson = "person"
+)
+
+func validateCropScope(scope string) error {
+	switch scope {
+	case cropScopeFace, cropScopePerson:
+		return nil
+	}
+	return fmt.Errorf("unknown crop scope %q (want face or person)", scope)
+}
+
+// personDetector finds full bodies with OpenCV's default HOG people
+// detector.
+type personDetector struct {
+	hog gocv.HOGDescriptor
+}
+
+func newPersonDetector() (*personDetector, error) {
+	hog := gocv.NewHOGDescriptor()
+	svm := gocv.HOGDefaultPeopleDetector()
+	defer svm.Close()
+	if err := hog.SetSVMDetector(svm); err != nil {
+		hog.Close()
+		return nil, fmt.Errorf("failed to set HOG people detector: %v", err)
+	}
+	return &personDetector{hog: hog}, nil
+}
+
+func (p *personDetector) Detect(img gocv.Mat) ([]Detection, error) {
+	rects := p.hog.DetectMultiScale(img)
+	people := make([]Detection, len(rects))
+	for i, rect := range rects {
+		people[i] = Detection{Rect: rect, Confidence: 1}
+	}
+	return people, nil
+}
+
+func (p *personDetector) Close() error {
+	return p.hog.Close()
+}
+
+// personFor returns the smallest person box containing the center of face.
+func personFor(face image.Rectangle, people []image.Rectangle) (image.Rectangle, bool) {
+	center := image.Pt((face.Min.X+face.Max.X)/2, (face.Min.Y+face.Max.Y)/2)
+	var best image.Rectangle
+	found := false
+	for _, p := range people {
+		if !center.In(p) {
+			continue
+		}
+		if !found || p.Dx()*p.Dy() < best.Dx()*best.Dy() {
+			best, found = p, true
+		}
+	}
+	return best, found
+}
@@ -0,0 +1,87 @@
+//go:build purego
+
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+
+	"github.com/HugoSmits86/nativewebp"
+	pigo "github.com/esimov/pigo/core"
+)
+
+//go:embed facefinder
+var facefinderCascade []byte
+
+func detectPigo(classifier *pigo.Pigo, img image.Image, minSize int, minConfidence float64) []image.Rectangle {
+	bounds := img.Bounds()
+	params := pigo.CascadeParams{
+		MinSize:     minSize,
+		MaxSize:     max(bounds.Dx(), bounds.Dy()),
+		ShiftFactor: 0.1,
+		ScaleFactor: 1.1,
+		ImageParams: pigo.ImageParams{
+			Pixels: pigo.RgbToGrayscale(img),
+			Rows:   bounds.Dy(),
+			Cols:   bounds.Dx(),
+			Dim:    bounds.Dx(),
+		},
+	}
+
+	dets := classifier.ClusterDetections(classifier.RunCascade(params, 0), 0.2)
+	var faces []image.Rectangle
+	for _, d := range dets {
+		if float64(d.Q) < minConfidence {
+			continue
+		}
+		half := d.Scale / 2
+		face := image.Rect(d.Col-half, d.Row-half, d.Col+half, d.Row+half).Add(bounds.Min)
+		faces = append(faces, face.Intersect(bounds))
+	}
+	return mergeDetections(faces)
+}
+
+func saveImageAsWebP(img image.Image, path string, mode os.FileMode) error {
+	return writeAtomic(path, mode, func(w io.Writer) error {
+		if err := nativewebp.Encode(w, img, nil); err != nil {
+			return fmt.Errorf("failed to encode image to WebP: %v", err)
+		}
+		return nil
+	})
+}
+
+func drawOutline(img draw.Image, r image.Rectangle, c color.Color, width int) {
+	r = r.Intersect(img.Bounds())
+	edges := []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+width),
+		image.Rect(r.Min.X, r.Max.Y-width, r.Max.X, r.Max.Y),
+		image.Rect(r.Min.X, r.Min.Y, r.Min.X+width, r.Max.Y),
+		image.Rect(r.Max.X-width, r.Min.Y, r.Max.X, r.Max.Y),
+	}
+	for _, e := range edges {
+		draw.Draw(img, e.Intersect(r), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+}
+
+type subImager interface {
+	SubImage(r image.Rectangle) image.Image
+}
+
+// asSubImager returns img itself when it supports SubImage, or an RGBA copy.
+func asSubImager(img image.Image) subImager {
+	if src, ok := img.(subImager); ok {
+		return src
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+func newPigoClassifier() (*pigo.Pigo, error) {
+	return pigo.NewPigo().Unpack(facefinderCascade)
+}
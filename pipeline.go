@@ -0,0 +1,167 @@
+//go:build !purego
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// stageItem is one input on its way through the pipeline. done is set once
+// result is final, for videos (handled whole by the detect stage) and for
+// inputs that failed in an earlier stage.
+type stageItem struct {
+	path   string
+	names  outputNames
+	opts   *options
+	in     *loadedImage
+	found  sceneDetections
+	result imageResult
+	err    error
+	done   bool
+
+	// mem is the budget the item holds a place in, if any.
+	mem *memoryBudget
+}
+
+// close frees the item's image and its place in the memory budget.
+func (it *stageItem) close() {
+	if it.in != nil {
+		it.in.Close()
+		it.in = nil
+	}
+	if it.mem != nil {
+		it.mem.release()
+		it.mem = nil
+	}
+}
+
+func (it *stageItem) fail(err error) {
+	it.close()
+	it.err = err
+	it.done = true
+}
+
+// runPipeline processes inputs in three stages connected by channels:
+// decode workers read and resize images, a single goroutine runs the
+// detectors (which are not safe for concurrent use), and encode workers
+// write the crops and annotated images. Slow lossless WebP encoding then
+// overlaps with detection of the next images instead of waiting on it.
+// Results are recorded on the calling goroutine as they complete, so their
+// order is not that of inputs. With --max-memory, each image holds a place
+// in the budget from decoding until its result is recorded. On a stop the
+// stages drop what is still queued.
+func (b *batch) runPipeline(ctx context.Context, inputs []string, names map[string]outputNames) error {
+	opts := b.opts
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queued := make(chan *stageItem)
+	go func() {
+		defer close(queued)
+		for _, inputPath := range inputs {
+			if ctx.Err() != nil {
+				return
+			}
+			if !b.preHook(inputPath) {
+				continue
+			}
+			select {
+			case queued <- &stageItem{path: inputPath, names: names[inputPath], opts: b.optsFor(inputPath), result: imageResult{Input: inputPath}}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	decoded := stage(ctx, queued, opts.decodeWorkers, func(it *stageItem) {
+		if err := b.mem.acquire(ctx); err != nil {
+			it.fail(err)
+			return
+		}
+		it.mem = b.mem
+		if isVideo(it.path) {
+			return
+		}
+		in, err := loadImage(it.path, it.opts)
+		if err != nil {
+			it.fail(err)
+			return
+		}
+		it.in = in
+	})
+
+	detected := stage(ctx, decoded, 1, func(it *stageItem) {
+		if it.done {
+			return
+		}
+		if isVideo(it.path) {
+			it.result, it.err = processVideo(it.path, it.names, it.opts, b.det)
+			it.done = true
+			return
+		}
+		found, err := b.det.detectTagged(it.path, it.in.img, it.in.resized, it.opts, nil)
+		if err != nil {
+			it.fail(err)
+			return
+		}
+		it.found = found
+	})
+
+	encoded := stage(ctx, detected, opts.encodeWorkers, func(it *stageItem) {
+		if it.done {
+			return
+		}
+		it.result, it.err = writeOutputs(it.path, it.names, it.opts, it.in, it.found)
+		it.in.Close()
+		it.in = nil
+	})
+
+	var runErr error
+	for it := range encoded {
+		it.close()
+		fmt.Printf("Processing file: %s\n", it.path)
+		if err := b.finish(it.path, it.result, it.err); err != nil && runErr == nil {
+			failed := len(b.failures)
+			if opts.failFast || (opts.maxErrors > 0 && failed >= opts.maxErrors) {
+				runErr = fmt.Errorf("stopping after %d failed image(s)", failed)
+				cancel()
+			}
+		}
+	}
+	if runErr == nil && ctx.Err() != nil {
+		runErr = errInterrupted
+	}
+	return runErr
+}
+
+// stage runs fn over items from in on workers goroutines and passes them on.
+// Once ctx is done, items are closed and dropped instead.
+func stage(ctx context.Context, in <-chan *stageItem, workers int, fn func(*stageItem)) <-chan *stageItem {
+	out := make(chan *stageItem, workers)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for it := range in {
+				if ctx.Err() != nil {
+					it.fail(ctx.Err())
+					continue
+				}
+				fn(it)
+				select {
+				case out <- it:
+				case <-ctx.Done():
+					it.fail(ctx.Err())
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
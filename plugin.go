@@ -0,0 +1,158 @@
+//go:build !purego
+
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// A plugin is a long-running process that speaks newline-delimited JSON-RPC
+// 2.0 on stdin/stdout. For every image it receives a "detect" call:
+//
+//	{"jsonrpc":"2.0","id":1,"method":"detect","params":{"image":"<base64 PNG>","width":640,"height":480}}
+//
+// and must answer with the faces it found, in pixel coordinates:
+//
+//	{"jsonrpc":"2.0","id":1,"result":{"detections":[{"x":10,"y":20,"width":64,"height":64,"confidence":0.9}]}}
+type pluginDetector struct {
+	name   string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	nextID int
+}
+
+type rpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int         `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int             `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *rpcError       `json:"error"`
+}
+
+type pluginDetectParams struct {
+	Image  string `json:"image"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type pluginDetection struct {
+	X          int     `json:"x"`
+	Y          int     `json:"y"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Confidence float64 `json:"confidence"`
+}
+
+type pluginDetectResult struct {
+	Detections []pluginDetection `json:"detections"`
+}
+
+func startPlugin(command string) (*pluginDetector, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty plugin command")
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open plugin stdin: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open plugin stdout: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %v", args[0], err)
+	}
+
+	return &pluginDetector{
+		name:   args[0],
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+	}, nil
+}
+
+func (p *pluginDetector) call(method string, params, result interface{}) error {
+	p.nextID++
+	req, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: p.nextID, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	if _, err := p.stdin.Write(append(req, '\n')); err != nil {
+		return fmt.Errorf("plugin %s: failed to send request: %v", p.name, err)
+	}
+
+	line, err := p.stdout.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("plugin %s: failed to read response: %v", p.name, err)
+	}
+	var resp rpcResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return fmt.Errorf("plugin %s: invalid response: %v", p.name, err)
+	}
+	if resp.ID != p.nextID {
+		return fmt.Errorf("plugin %s: response id %d does not match request id %d", p.name, resp.ID, p.nextID)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("plugin %s: %s (code %d)", p.name, resp.Error.Message, resp.Error.Code)
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+func (p *pluginDetector) Detect(img gocv.Mat) ([]Detection, error) {
+	buf, err := gocv.IMEncode(gocv.PNGFileExt, img)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image for plugin: %v", err)
+	}
+	defer buf.Close()
+
+	params := pluginDetectParams{
+		Image:  base64.StdEncoding.EncodeToString(buf.GetBytes()),
+		Width:  img.Cols(),
+		Height: img.Rows(),
+	}
+	var result pluginDetectResult
+	if err := p.call("detect", params, &result); err != nil {
+		return nil, err
+	}
+
+	bounds := image.Rect(0, 0, img.Cols(), img.Rows())
+	var faces []Detection
+	for _, d := range result.Detections {
+		rect := image.Rect(d.X, d.Y, d.X+d.Width, d.Y+d.Height).Intersect(bounds)
+		if !rect.Empty() {
+			faces = append(faces, Detection{Rect: rect, Confidence: d.Confidence})
+		}
+	}
+	return faces, nil
+}
+
+func (p *pluginDetector) Close() error {
+	p.stdin.Close()
+	return p.cmd.Wait()
+}
@@ -0,0 +1,60 @@
+//go:build !purego
+
+package main
+
+import (
+	"image"
+	"runtime"
+
+	"gocv.io/x/gocv"
+)
+
+// matPool keeps scratch Mats for reuse. Their memory lives on the C heap,
+// out of sight of the garbage collector, so the pool is a bounded channel
+// rather than a sync.Pool, which would drop Mats without closing them.
+// OpenCV reallocates a Mat only when the size or type it is asked to hold
+// changes.
+var matPool = make(chan gocv.Mat, 2*runtime.NumCPU())
+
+func getMat() gocv.Mat {
+	select {
+	case m := <-matPool:
+		return m
+	default:
+		return gocv.NewMat()
+	}
+}
+
+func putMat(m gocv.Mat) {
+	select {
+	case matPool <- m:
+	default:
+		m.Close()
+	}
+}
+
+// matImage views mat as an image.Image for encoding, converting it into buf
+// and sharing buf's memory instead of copying pixel by pixel as Mat.ToImage
+// does. The image is only valid until buf is next written or closed.
+func matImage(mat gocv.Mat, buf *gocv.Mat) (image.Image, error) {
+	switch mat.Type() {
+	case gocv.MatTypeCV8UC1:
+		mat.CopyTo(buf)
+		pix, err := buf.DataPtrUint8()
+		if err != nil {
+			return nil, err
+		}
+		return &image.Gray{Pix: pix, Stride: buf.Cols(), Rect: image.Rect(0, 0, buf.Cols(), buf.Rows())}, nil
+	case gocv.MatTypeCV8UC3:
+		gocv.CvtColor(mat, buf, gocv.ColorBGRToRGBA)
+	case gocv.MatTypeCV8UC4:
+		gocv.CvtColor(mat, buf, gocv.ColorBGRAToRGBA)
+	default:
+		return mat.ToImage()
+	}
+	pix, err := buf.DataPtrUint8()
+	if err != nil {
+		return nil, err
+	}
+	return &image.RGBA{Pix: pix, Stride: 4 * buf.Cols(), Rect: image.Rect(0, 0, buf.Cols(), buf.Rows())}, nil
+}
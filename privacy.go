@@ -0,0 +1,25 @@
+//go:build !purego
+
+package main
+
+import (
+	"errors"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// errPrivate is returned by code paths that would write face imagery while
+// --private is set.
+var errPrivate = errors.New("private mode never writes face images")
+
+// redactTracks redacts every tracked face in img, with the margin of
+// expandFace so that tracker drift does not uncover the edge of a face.
+func redactTracks(img *gocv.Mat, tracks []*track, method string) {
+	bounds := image.Rect(0, 0, img.Cols(), img.Rows())
+	rects := make([]image.Rectangle, len(tracks))
+	for i, t := range tracks {
+		rects[i] = expandFace(t.rect, bounds)
+	}
+	redactRegions(img, rects, method)
+}
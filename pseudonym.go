@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// minPseudonymKey is the shortest --pseudonym-key accepted, in bytes.
+const minPseudonymKey = 16
+
+// pseudonymizer replaces the names of matched identities with stable
+// pseudonyms: prefix and the start of the HMAC-SHA256 of the name under a
+// secret key. The same key gives a person the same pseudonym in every run,
+// so faces can still be grouped and counted by person, while without the
+// key a pseudonym can be neither reversed nor checked against a guess.
+type pseudonymizer struct {
+	key    []byte
+	prefix string
+}
+
+// loadPseudonymizer reads the key from path; surrounding whitespace is
+// ignored, so a key from openssl rand -hex 32 works as is.
+func loadPseudonymizer(path, prefix string) (*pseudonymizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pseudonym key: %v", err)
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) < minPseudonymKey {
+		return nil, fmt.Errorf("pseudonym key %s is shorter than %d bytes", path, minPseudonymKey)
+	}
+	return &pseudonymizer{key: key, prefix: prefix}, nil
+}
+
+func (p *pseudonymizer) pseudonym(name string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(name))
+	return p.prefix + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// apply replaces every name in r, so each output written from it carries
+// the pseudonym instead.
+func (p *pseudonymizer) apply(r *imageResult) {
+	for i, name := range r.People {
+		r.People[i] = p.pseudonym(name)
+	}
+	for i := range r.Boxes {
+		if r.Boxes[i].Name != "" {
+			r.Boxes[i].Name = p.pseudonym(r.Boxes[i].Name)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+//go:build purego && !js
+
+// Building with -tags purego produces a reduced, cgo-free face detector for
+// machines where OpenCV cannot be installed. Detection uses the pigo
+// pixel-intensity-comparison cascade, which finds fewer faces than the OpenCV
+// Haar and DNN detectors, particularly small, rotated or poorly lit ones.
+// Only the core batch pipeline is available: detection, padded or composed
+// face crops and an annotated copy of every input.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+
+	pigo "github.com/esimov/pigo/core"
+)
+
+type pureOptions struct {
+	inputDir      string
+	outputDir     string
+	composition   string
+	faceOrder     string
+	minFaceSize   int
+	minConfidence float64
+	fileMode      os.FileMode
+}
+
+func detectFacePure(classifier *pigo.Pigo, imagePath string, names outputNames, opts *pureOptions) (imageResult, error) {
+	result := imageResult{Input: imagePath}
+
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return result, err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return result, fmt.Errorf("%w: %v", errUnreadable, err)
+	}
+
+	faces := detectPigo(classifier, img, opts.minFaceSize, opts.minConfidence)
+	sortFaces(faces, opts.faceOrder)
+	result.Faces = len(faces)
+	if len(faces) == 0 {
+		return result, nil
+	}
+
+	src := asSubImager(img)
+
+	bounds := img.Bounds()
+	for i, face := range faces {
+		crop := src.SubImage(composeCrop(face, bounds, opts.composition))
+		cropPath := filepath.Join(opts.outputDir, fmt.Sprintf("%s_face_%d.webp", names.stem, i+1))
+		if err := saveImageAsWebP(crop, cropPath, opts.fileMode); err != nil {
+			return result, fmt.Errorf("error saving face image: %v", err)
+		}
+		result.Crops = append(result.Crops, cropPath)
+	}
+
+	annotated := image.NewRGBA(bounds)
+	draw.Draw(annotated, bounds, img, bounds.Min, draw.Src)
+	for _, face := range faces {
+		drawOutline(annotated, expandFace(face, bounds), color.RGBA{255, 0, 0, 255}, 3)
+	}
+	if err := saveImageAsWebP(annotated, names.annotated, opts.fileMode); err != nil {
+		return result, fmt.Errorf("error saving output image in WebP format: %v", err)
+	}
+	result.Annotated = names.annotated
+
+	return result, nil
+}
+
+func main() {
+	opts := &pureOptions{
+		inputDir:  "input_images",
+		outputDir: "output_images",
+	}
+	flag.StringVar(&opts.composition, "composition", compositionPadded, "face crop framing: padded, center or thirds (eye line on the upper third)")
+	flag.StringVar(&opts.faceOrder, "face-order", faceOrderLTR, "order faces are numbered in within an image: ltr (left to right), ttb (top to bottom) or size (largest first)")
+	flag.IntVar(&opts.minFaceSize, "min-face-size", 30, "smallest face to look for, in pixels")
+	flag.Float64Var(&opts.minConfidence, "min-confidence", 5.0, "minimum pigo detection score")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s (pure-Go build, pigo detector with reduced accuracy):\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := validateComposition(opts.composition); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := validateFaceOrder(opts.faceOrder); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	mode, err := outputFileMode("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts.fileMode = mode
+
+	classifier, err := newPigoClassifier()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading pigo cascade: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := os.MkdirAll(opts.outputDir, os.ModePerm); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating output directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	entries, err := os.ReadDir(opts.inputDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to read input directory: %v\n", err)
+		os.Exit(1)
+	}
+	var inputs []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			inputs = append(inputs, filepath.Join(opts.inputDir, e.Name()))
+		}
+	}
+
+	names := planOutputNames(inputs, opts.outputDir)
+	var failures []failure
+	for _, inputPath := range inputs {
+		fmt.Printf("Processing file: %s\n", inputPath)
+		if _, err := detectFacePure(classifier, inputPath, names[inputPath], opts); err != nil {
+			fmt.Printf("Error processing file %s: %v\n", inputPath, err)
+			failures = append(failures, failure{path: inputPath, reason: err.Error()})
+		}
+	}
+
+	failuresFile := filepath.Join(opts.outputDir, "failures.txt")
+	if err := writeFailures(failuresFile, failures, opts.fileMode); err != nil {
+		fmt.Printf("Error writing failure report: %v\n", err)
+	}
+	if len(failures) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: %d of %d images failed, see %s\n", len(failures), len(inputs), failuresFile)
+		os.Exit(1)
+	}
+}
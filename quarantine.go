@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var errUnreadable = errors.New("error reading image")
+
+const (
+	quarantineMove = "move"
+	quarantineCopy = "copy"
+)
+
+func validateQuarantineMode(mode string) error {
+	switch mode {
+	case quarantineMove, quarantineCopy:
+		return nil
+	}
+	return fmt.Errorf("unknown quarantine mode %q (want move or copy)", mode)
+}
+
+// freePath returns path, or path with a numeric suffix before the extension
+// if something already exists there.
+func freePath(path string) string {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	candidate := path
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s_%d%s", stem, i, ext)
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// quarantineFile moves or copies an undecodable input into dir and appends
+// the reason to dir/reasons.tsv.
+func quarantineFile(path, dir, mode string, reason error) (string, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create quarantine directory: %v", err)
+	}
+
+	dst := freePath(filepath.Join(dir, filepath.Base(path)))
+	var err error
+	if mode == quarantineCopy {
+		err = copyFile(path, dst)
+	} else if err = os.Rename(path, dst); err != nil {
+		// Renames fail across filesystems; fall back to copy and delete.
+		if err = copyFile(path, dst); err == nil {
+			err = os.Remove(path)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to quarantine %s: %v", path, err)
+	}
+
+	log, err := os.OpenFile(filepath.Join(dir, "reasons.tsv"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return dst, fmt.Errorf("failed to record quarantine reason: %v", err)
+	}
+	defer log.Close()
+	line := strings.NewReplacer("\t", " ", "\n", " ").Replace(reason.Error())
+	_, err = fmt.Fprintf(log, "%s\t%s\t%s\t%s\n", time.Now().Format(time.RFC3339), path, filepath.Base(dst), line)
+	return dst, err
+}
@@ -0,0 +1,95 @@
+//go:build !purego
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// clientIdle is how long a client's limiter is kept after its last request.
+const clientIdle = 10 * time.Minute
+
+// clientLimiter gives every client its own token bucket. Clients are told
+// apart by the API key they authenticated with, and by remote address
+// otherwise, so made-up keys cannot buy fresh buckets.
+type clientLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	clients map[string]*clientBucket
+	swept   time.Time
+}
+
+type clientBucket struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+func newClientLimiter(perSecond float64, burst int) *clientLimiter {
+	return &clientLimiter{limit: rate.Limit(perSecond), burst: burst, clients: map[string]*clientBucket{}}
+}
+
+func (l *clientLimiter) allow(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.swept) > clientIdle {
+		for k, b := range l.clients {
+			if now.Sub(b.seen) > clientIdle {
+				delete(l.clients, k)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &clientBucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[client] = b
+	}
+	b.seen = now
+	return b.limiter.Allow()
+}
+
+func clientID(r *http.Request) string {
+	if key, ok := authenticatedKey(r); ok {
+		return "key:" + key
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// limitRequests applies the per-client rate (when limiter is not nil) and
+// the cap on requests in flight that slots (when not nil) holds, shared by
+// every route it guards; each request holds a decoded image. Rejected
+// requests get 429 or 503 with Retry-After rather than queueing.
+func limitRequests(limiter *clientLimiter, slots chan struct{}, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limiter != nil && !limiter.allow(clientID(r)) {
+			w.Header().Set("Retry-After", "1")
+			writeJSON(w, http.StatusTooManyRequests, errorResponse{"rate limit exceeded"})
+			return
+		}
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			default:
+				w.Header().Set("Retry-After", "1")
+				writeJSON(w, http.StatusServiceUnavailable, errorResponse{"server busy"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
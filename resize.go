@@ -0,0 +1,65 @@
+//go:build !purego
+
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	resizeStretch = "stretch"
+	resizeFit     = "fit"
+	resizeNone    = "none"
+)
+
+var interpolations = map[string]gocv.InterpolationFlags{
+	"nearest": gocv.InterpolationNearestNeighbor,
+	"linear":  gocv.InterpolationLinear,
+	"cubic":   gocv.InterpolationCubic,
+	"area":    gocv.InterpolationArea,
+	"lanczos": gocv.InterpolationLanczos4,
+}
+
+func parseInterpolation(name string) (gocv.InterpolationFlags, error) {
+	flag, ok := interpolations[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown interpolation %q (want nearest, linear, cubic, area or lanczos)", name)
+	}
+	return flag, nil
+}
+
+func validateResizeMode(mode string) error {
+	switch mode {
+	case resizeStretch, resizeFit, resizeNone:
+		return nil
+	}
+	return fmt.Errorf("unknown resize mode %q (want stretch, fit or none)", mode)
+}
+
+// resizeForDetection writes the image the detectors will see into dst:
+// "stretch" forces it to exactly maxWidth x maxHeight, "fit" shrinks it to fit
+// those bounds keeping the aspect ratio, and "none" keeps full resolution.
+func resizeForDetection(img gocv.Mat, dst *gocv.Mat, opts *options) {
+	size := image.Point{X: int(opts.maxWidth), Y: int(opts.maxHeight)}
+
+	switch opts.resizeMode {
+	case resizeNone:
+		img.CopyTo(dst)
+		return
+	case resizeFit:
+		scale := min(float64(size.X)/float64(img.Cols()), float64(size.Y)/float64(img.Rows()))
+		if scale >= 1 {
+			img.CopyTo(dst)
+			return
+		}
+		size = image.Point{
+			X: max(1, int(float64(img.Cols())*scale)),
+			Y: max(1, int(float64(img.Rows())*scale)),
+		}
+	}
+
+	gocv.Resize(img, dst, size, 0, 0, opts.interpolation)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"image"
+	"time"
+)
+
+// imageResult describes what was written for one input image. It is also the
+// payload handed to --post-hook commands.
+type imageResult struct {
+	Input     string   `json:"input"`
+	Annotated string   `json:"annotated,omitempty"`
+	Crops     []string `json:"crops"`
+	SmartCrop string   `json:"smartcrop,omitempty"`
+	GroupCrop string   `json:"group_crop,omitempty"`
+	Timeline  string   `json:"timeline,omitempty"`
+	Faces     int      `json:"faces"`
+	Plates    int      `json:"plates,omitempty"`
+
+	// Width and Height are the decoded image's size; Boxes are the faces in
+	// its pixel coordinates.
+	Width  int   `json:"width,omitempty"`
+	Height int   `json:"height,omitempty"`
+	Boxes  []box `json:"boxes,omitempty"`
+
+	// Dwell is how long each tracked face stayed in frame, for videos.
+	Dwell []trackDwell `json:"dwell,omitempty"`
+
+	// TakenAt and People come from a Google Takeout sidecar, with --takeout.
+	TakenAt *time.Time `json:"taken_at,omitempty"`
+	People  []string   `json:"people,omitempty"`
+}
+
+// box is a face; Name is set when the person is known.
+type box struct {
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+	W    int    `json:"w"`
+	H    int    `json:"h"`
+	Name string `json:"name,omitempty"`
+}
+
+func boxOf(r image.Rectangle) box {
+	return box{X: r.Min.X, Y: r.Min.Y, W: r.Dx(), H: r.Dy()}
+}
+
+func (b box) rect() image.Rectangle {
+	return image.Rect(b.X, b.Y, b.X+b.W, b.Y+b.H)
+}
+
+// outputs lists every file written for the input.
+func (r imageResult) outputs() []string {
+	return append([]string{r.Annotated, r.SmartCrop, r.GroupCrop, r.Timeline}, r.Crops...)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRetentionSweep is the longest wait between two retention sweeps.
+const maxRetentionSweep = 10 * time.Minute
+
+// parseRetention reads a --retention value: a number of days such as "30d"
+// or a duration such as "12h".
+func parseRetention(s string) (time.Duration, error) {
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid retention %q", s)
+		}
+		d = time.Duration(n * float64(24*time.Hour))
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("invalid retention %q", s)
+		}
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("retention must be positive, got %q", s)
+	}
+	return d, nil
+}
+
+// snapshotPattern matches the names of the snapshots saveSnapshot writes
+// for a camera, encrypted or not.
+func snapshotPattern(camera string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(slugify(camera)) + `_\d{8}-\d{6}_face\d+\.webp(` + regexp.QuoteMeta(encSuffix) + `)?$`)
+}
+
+// pruneSnapshots deletes the snapshots of camera in dir last modified more
+// than maxAge before now, returning how many were removed. Other files,
+// such as the outputs of earlier batch runs, are never touched. A file
+// that cannot be removed is reported and skipped.
+func pruneSnapshots(dir, camera string, maxAge time.Duration, now time.Time) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	pattern := snapshotPattern(camera)
+	cutoff := now.Add(-maxAge)
+	removed := 0
+	var firstErr error
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !pattern.MatchString(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil && !os.IsNotExist(err) {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		removed++
+	}
+	return removed, firstErr
+}
+
+// enforceRetention prunes the snapshots of camera in dir now and then
+// regularly until ctx is done, so a long-running deployment never keeps
+// them longer than maxAge plus one sweep interval.
+func enforceRetention(ctx context.Context, dir, camera string, maxAge time.Duration) {
+	interval := max(min(maxAge/10, maxRetentionSweep), time.Second)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		removed, err := pruneSnapshots(dir, camera, maxAge, time.Now())
+		if removed > 0 {
+			fmt.Printf("Removed %d snapshots older than %s\n", removed, maxAge)
+		}
+		if err != nil {
+			fmt.Printf("Error enforcing retention: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// maxBackoff caps the wait between two attempts.
+const maxBackoff = 30 * time.Second
+
+// retryPolicy is how often and how patiently transient I/O failures are
+// retried: retries more attempts after the first, waiting backoff before
+// the first retry and twice as long before each one after.
+type retryPolicy struct {
+	retries int
+	backoff time.Duration
+}
+
+// ioRetry applies to outbound HTTP and to writing output files, which on
+// network mounts can fail for a moment with EBUSY or ESTALE.
+var ioRetry = retryPolicy{retries: 3, backoff: 500 * time.Millisecond}
+
+// delay is the wait before retry n (from 1), jittered so workers that
+// failed together do not retry together.
+func (p retryPolicy) delay(n int) time.Duration {
+	d := p.backoff << (n - 1)
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func (p retryPolicy) wait(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// do runs fn until it succeeds, fails for good or runs out of retries. The
+// error of a call that never succeeded says how often it was tried.
+func (p retryPolicy) do(ctx context.Context, fn func() error) error {
+	for n := 1; ; n++ {
+		err := fn()
+		if err == nil || !transient(err) {
+			return err
+		}
+		if n > p.retries {
+			return fmt.Errorf("%w (gave up after %d attempts)", err, n)
+		}
+		if werr := p.wait(ctx, p.delay(n)); werr != nil {
+			return err
+		}
+	}
+}
+
+// transient reports whether err is worth retrying: busy or stale files,
+// dropped connections and timeouts, but not cancellation.
+func transient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	for _, errno := range []syscall.Errno{syscall.EBUSY, syscall.EAGAIN, syscall.ESTALE, syscall.ETIMEDOUT, syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.EPIPE} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// retryTransport retries outbound requests that failed transiently or got
+// a 429 or 5xx gateway response. Requests whose body cannot be replayed are
+// sent once.
+type retryTransport struct {
+	base   http.RoundTripper
+	policy retryPolicy
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return t.base.RoundTrip(req)
+	}
+	for n := 1; ; n++ {
+		r := req
+		if n > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+		resp, err := t.base.RoundTrip(r)
+		if err != nil && !transient(err) {
+			return nil, err
+		}
+		if err == nil && !retryStatus(resp.StatusCode) {
+			return resp, nil
+		}
+		if n > t.policy.retries {
+			if err != nil {
+				return nil, fmt.Errorf("%w (gave up after %d attempts)", err, n)
+			}
+			return resp, nil
+		}
+
+		d := t.policy.delay(n)
+		if resp != nil {
+			if s, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil && s > 0 {
+				d = max(d, min(time.Duration(s)*time.Second, maxBackoff))
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		if werr := t.policy.wait(req.Context(), d); werr != nil {
+			return nil, werr
+		}
+	}
+}
+
+func retryStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
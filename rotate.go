@@ -0,0 +1,131 @@
+//go:build !purego
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// rotationList is the --rotations value: the angles in degrees, counter-
+// clockwise, that images are searched at instead of only upright. "step:N"
+// means every N degrees around the circle.
+type rotationList []float64
+
+func (r *rotationList) String() string {
+	parts := make([]string, len(*r))
+	for i, a := range *r {
+		parts[i] = strconv.FormatFloat(a, 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *rotationList) Set(v string) error {
+	var angles rotationList
+	if step, ok := strings.CutPrefix(v, "step:"); ok {
+		n, err := strconv.ParseFloat(step, 64)
+		if err != nil || n <= 0 || n >= 360 {
+			return fmt.Errorf("invalid rotation step %q (want degrees between 0 and 360)", step)
+		}
+		for a := 0.0; a < 360; a += n {
+			angles = append(angles, a)
+		}
+	} else if v != "" {
+		for _, s := range strings.Split(v, ",") {
+			a, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				return fmt.Errorf("invalid rotation %q", s)
+			}
+			angles = append(angles, math.Mod(math.Mod(a, 360)+360, 360))
+		}
+	}
+	*r = angles
+	return nil
+}
+
+// detectRotated is detectFaces over every angle of opts.rotations, with the
+// faces found on rotated copies mapped back and merged. d.mu must be held.
+func (d *detectors) detectRotated(img, resized gocv.Mat, opts *options, batched []Detection) ([]image.Rectangle, error) {
+	if len(opts.rotations) == 0 {
+		return d.detectFaces(img, resized, opts, batched)
+	}
+	var faces []image.Rectangle
+	for _, angle := range opts.rotations {
+		if angle == 0 {
+			found, err := d.detectFaces(img, resized, opts, batched)
+			if err != nil {
+				return nil, err
+			}
+			faces = append(faces, found...)
+			continue
+		}
+
+		rotResized, back := rotateMat(resized, angle)
+		rotImg := rotResized
+		if opts.tileSize > 0 {
+			rotImg, _ = rotateMat(img, angle)
+		}
+		found, err := d.detectFaces(rotImg, rotResized, opts, nil)
+		if opts.tileSize > 0 {
+			rotImg.Close()
+		}
+		rotResized.Close()
+		if err != nil {
+			return nil, err
+		}
+		bounds := image.Rect(0, 0, resized.Cols(), resized.Rows())
+		for _, f := range found {
+			if rect := back(f).Intersect(bounds); !rect.Empty() {
+				faces = append(faces, rect)
+			}
+		}
+	}
+	return mergeDetections(faces), nil
+}
+
+// rotateMat rotates src by angle degrees counter-clockwise onto a canvas
+// large enough to hold all of it, and returns a function mapping boxes on
+// the rotated copy back to src.
+func rotateMat(src gocv.Mat, angle float64) (gocv.Mat, func(image.Rectangle) image.Rectangle) {
+	rad := angle * math.Pi / 180
+	cos, sin := math.Cos(rad), math.Sin(rad)
+	w, h := float64(src.Cols()), float64(src.Rows())
+	nw := math.Abs(w*cos) + math.Abs(h*sin)
+	nh := math.Abs(w*sin) + math.Abs(h*cos)
+	cx, cy, ncx, ncy := w/2, h/2, nw/2, nh/2
+
+	// The affine matrix of getRotationMatrix2D, moved to the new centre.
+	m := gocv.NewMatWithSize(2, 3, gocv.MatTypeCV64F)
+	defer m.Close()
+	m.SetDoubleAt(0, 0, cos)
+	m.SetDoubleAt(0, 1, sin)
+	m.SetDoubleAt(0, 2, ncx-cos*cx-sin*cy)
+	m.SetDoubleAt(1, 0, -sin)
+	m.SetDoubleAt(1, 1, cos)
+	m.SetDoubleAt(1, 2, ncy+sin*cx-cos*cy)
+	dst := gocv.NewMat()
+	gocv.WarpAffine(src, &dst, m, image.Pt(int(math.Round(nw)), int(math.Round(nh))))
+
+	// A rotated box's bounding box is larger than the face by up to
+	// |cos|+|sin|; shrink it back around its centre.
+	shrink := 1 / (math.Abs(cos) + math.Abs(sin))
+	back := func(r image.Rectangle) image.Rectangle {
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for _, p := range []image.Point{r.Min, {r.Max.X, r.Min.Y}, r.Max, {r.Min.X, r.Max.Y}} {
+			dx, dy := float64(p.X)-ncx, float64(p.Y)-ncy
+			x, y := cos*dx-sin*dy+cx, sin*dx+cos*dy+cy
+			minX, maxX = min(minX, x), max(maxX, x)
+			minY, maxY = min(minY, y), max(maxY, y)
+		}
+		mx, my := (minX+maxX)/2, (minY+maxY)/2
+		hw, hh := (maxX-minX)/2*shrink, (maxY-minY)/2*shrink
+		return image.Rect(int(math.Round(mx-hw)), int(math.Round(my-hh)), int(math.Round(mx+hw)), int(math.Round(my+hh)))
+	}
+	return dst, back
+}
@@ -0,0 +1,291 @@
+//go:build !purego
+
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// server exposes detection over HTTP. Requests are queued to a fixed pool
+// of workers so a burst of uploads cannot run unbounded detections at once.
+type server struct {
+	opts    *options
+	det     *detectors
+	jobs    *jobManager
+	keys    []string
+	limiter *clientLimiter
+	slots   chan struct{}
+	queue   chan detectRequest
+	events  *eventStream
+	workers int
+	alive   atomic.Int32
+}
+
+type detectRequest struct {
+	data  []byte
+	reply chan detectReply
+}
+
+type detectReply struct {
+	faces []image.Rectangle
+	err   error
+}
+
+// apiFace is a detection as returned by the HTTP API. Faces from different
+// backends, rotations and tiles are merged, so no score is reported.
+type apiFace struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type detectResponse struct {
+	Faces []apiFace `json:"faces"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func newServer(opts *options, det *detectors, keys []string, store *jobStore) *server {
+	s := &server{opts: opts, det: det, jobs: newJobManager(opts, det, store), keys: keys, queue: make(chan detectRequest), events: newEventStream(opts.allowedOrigins), workers: opts.workers}
+	if opts.rateLimit > 0 {
+		s.limiter = newClientLimiter(opts.rateLimit, opts.rateBurst)
+	}
+	if opts.maxInFlight > 0 {
+		s.slots = make(chan struct{}, opts.maxInFlight)
+	}
+	return s
+}
+
+// protect wraps an API handler with authentication and then rate limiting,
+// keyed on the authenticated client.
+func (s *server) protect(h http.Handler) http.Handler {
+	return requireAPIKey(s.keys, limitRequests(s.limiter, s.slots, h))
+}
+
+func (s *server) work(ctx context.Context) {
+	s.alive.Add(1)
+	defer s.alive.Add(-1)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-s.queue:
+			faces, err := s.det.DetectBytes(req.data, s.opts)
+			req.reply <- detectReply{faces, err}
+		}
+	}
+}
+
+// routes wires the API. The probes stay unauthenticated so orchestrators
+// need no credentials.
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	for pattern, h := range s.handlers() {
+		mux.Handle(pattern, h)
+	}
+	mux.HandleFunc("GET /openapi.json", s.handleOpenAPI)
+	return mux
+}
+
+// handlers are the API routes by pattern. Each is documented in
+// openapi.json; server_test.go checks that the two agree.
+func (s *server) handlers() map[string]http.Handler {
+	return map[string]http.Handler{
+		"POST /detect":                  s.protect(http.HandlerFunc(s.handleDetect)),
+		"POST /jobs":                    s.protect(http.HandlerFunc(s.jobs.handleSubmit)),
+		"GET /jobs/{id}":                requireAPIKey(s.keys, http.HandlerFunc(s.jobs.handleGet)),
+		"GET /jobs/{id}/outputs/{name}": requireAPIKey(s.keys, http.HandlerFunc(s.jobs.handleOutput)),
+		"GET /jobs/{id}/events":         requireAPIKey(s.keys, http.HandlerFunc(s.jobs.handleEvents)),
+		"GET /events":                   requireAPIKey(s.keys, s.events),
+		"GET /healthz":                  http.HandlerFunc(s.handleHealth),
+		"GET /readyz":                   http.HandlerFunc(s.handleReady),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleDetect takes an encoded image as the request body and returns the
+// faces found in it.
+func (s *server) handleDetect(w http.ResponseWriter, r *http.Request) {
+	if s.opts.limits.maxFileSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.opts.limits.maxFileSize)
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeJSON(w, status, errorResponse{err.Error()})
+		return
+	}
+
+	req := detectRequest{data: data, reply: make(chan detectReply, 1)}
+	select {
+	case s.queue <- req:
+	case <-r.Context().Done():
+		return
+	}
+	reply := <-req.reply
+	if reply.err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(reply.err, errLimit):
+			status = http.StatusRequestEntityTooLarge
+		case errors.Is(reply.err, errUnreadable):
+			status = http.StatusUnprocessableEntity
+		}
+		writeJSON(w, status, errorResponse{reply.err.Error()})
+		return
+	}
+
+	resp := detectResponse{Faces: []apiFace{}}
+	event := detectionEvent{Source: "detect", Time: time.Now(), Faces: []eventFace{}}
+	for _, f := range reply.faces {
+		resp.Faces = append(resp.Faces, apiFace{X: f.Min.X, Y: f.Min.Y, Width: f.Dx(), Height: f.Dy()})
+		event.Faces = append(event.Faces, newEventFace(0, f))
+	}
+	s.events.send(event)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleOpenAPI serves the API description, from which clients such as the
+// client package are written.
+func (s *server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// handleHealth is the liveness probe: the process is up and serving HTTP.
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReady is the readiness probe: detectors are loaded, every worker is
+// running and the output directory accepts writes.
+func (s *server) handleReady(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{
+		"models":  "ok",
+		"workers": "ok",
+		"storage": "ok",
+	}
+	if len(s.det.list) == 0 {
+		checks["models"] = "no detectors loaded"
+	}
+	if n := int(s.alive.Load()); n < s.workers {
+		checks["workers"] = fmt.Sprintf("%d of %d workers running", n, s.workers)
+	}
+	if err := checkWritable(s.opts.outputDir); err != nil {
+		checks["storage"] = err.Error()
+	}
+
+	status := http.StatusOK
+	for _, v := range checks {
+		if v != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, map[string]interface{}{"ready": status == http.StatusOK, "checks": checks})
+}
+
+// checkWritable creates and removes a temporary file in dir.
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// runServer serves the HTTP API on --serve, over HTTPS when --tls-cert is
+// given, until ctx is cancelled, then drains in-flight requests.
+func runServer(ctx context.Context, opts *options, det *detectors) error {
+	keys, err := loadAPIKeys(opts.apiKeysFile)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		fmt.Println("Warning: no API keys configured, the API is open to anyone who can reach it")
+	}
+
+	dbPath := opts.jobDB
+	if dbPath == "" {
+		dbPath = filepath.Join(opts.outputDir, "jobs.db")
+	}
+	store, err := openJobStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	s := newServer(opts, det, keys, store)
+	pending, err := s.jobs.restore()
+	if err != nil {
+		return err
+	}
+
+	workCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	for i := 0; i < s.workers; i++ {
+		go s.work(workCtx)
+	}
+	jobCtx, stopJobs := context.WithCancel(ctx)
+	jobsDone := make(chan struct{})
+	go func() {
+		s.jobs.run(jobCtx, pending)
+		close(jobsDone)
+	}()
+	// The job runner must stop before the store is closed.
+	defer func() {
+		stopJobs()
+		<-jobsDone
+	}()
+
+	srv := &http.Server{Addr: opts.serveAddr, Handler: s.routes()}
+	if opts.tlsCert != "" {
+		if srv.TLSConfig, err = serverTLSConfig(opts.tlsCert, opts.tlsKey, opts.tlsClientCA); err != nil {
+			return err
+		}
+	}
+	errc := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errc <- srv.ListenAndServeTLS("", "")
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+	fmt.Printf("Serving on %s\n", opts.serveAddr)
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("server failed: %v", err)
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
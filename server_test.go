@@ -0,0 +1,38 @@
+//go:build !purego
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	documented := map[string]bool{}
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			if method == "parameters" {
+				continue
+			}
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	s := newServer(&options{}, nil, nil, nil)
+	for pattern := range s.handlers() {
+		if !documented[pattern] {
+			t.Errorf("route %s is not in openapi.json", pattern)
+		}
+		delete(documented, pattern)
+	}
+	for pattern := range documented {
+		t.Errorf("openapi.json documents %s, which the server does not route", pattern)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// shard selects a deterministic subset of the inputs, so several machines
+// can split one directory without talking to each other. The zero value
+// selects everything.
+type shard struct {
+	index int // 1-based
+	count int
+}
+
+// parseShard parses "K/N", meaning shard K of N.
+func parseShard(s string) (shard, error) {
+	if s == "" {
+		return shard{}, nil
+	}
+	k, n, ok := strings.Cut(s, "/")
+	index, err1 := strconv.Atoi(k)
+	count, err2 := strconv.Atoi(n)
+	if !ok || err1 != nil || err2 != nil || count < 1 || index < 1 || index > count {
+		return shard{}, fmt.Errorf("invalid shard %q (want K/N with 1 <= K <= N)", s)
+	}
+	return shard{index: index, count: count}, nil
+}
+
+// includes reports whether the input named key belongs to this shard. Keys
+// are hashed, so shards stay balanced and membership does not depend on
+// the listing order or on which other files exist.
+func (s shard) includes(key string) bool {
+	if s.count <= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int(h.Sum64()%uint64(s.count)) == s.index-1
+}
+
+// inputKey names an input by its path relative to the input directory, or
+// by its full path when it lies elsewhere.
+func inputKey(path, inputDir string) string {
+	if rel, err := filepath.Rel(inputDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
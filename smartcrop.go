@@ -0,0 +1,24 @@
+//go:build !purego
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+
+	"gocv.io/x/gocv"
+)
+
+func saveSmartCrop(img gocv.Mat, subjects []image.Rectangle, opts *options, baseFilename string) (string, error) {
+	rect := smartCropRect(image.Rect(0, 0, img.Cols(), img.Rows()), subjects, opts.smartCrop)
+
+	region := img.Region(rect)
+	defer region.Close()
+	resized := gocv.NewMat()
+	defer resized.Close()
+	gocv.Resize(region, &resized, opts.smartCrop, 0, 0, opts.interpolation)
+
+	outputPath := filepath.Join(opts.outputDir, fmt.Sprintf("%s_smartcrop.webp", baseFilename))
+	return saveCrop(resized, outputPath, opts)
+}
@@ -0,0 +1,80 @@
+//go:build !purego
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// snapshotPolicy decides which camera tracks get a saved crop: a track is
+// snapshotted when it starts, unless it starts where another face was seen
+// within the cooldown, which is usually the same person after the tracker
+// briefly lost them.
+type snapshotPolicy struct {
+	cooldown time.Duration
+	lastSeen map[int]seenFace
+}
+
+type seenFace struct {
+	rect image.Rectangle
+	at   time.Time
+}
+
+func newSnapshotPolicy(cooldown time.Duration) *snapshotPolicy {
+	return &snapshotPolicy{cooldown: cooldown, lastSeen: map[int]seenFace{}}
+}
+
+// due returns the tracks to snapshot now and records all of them as seen.
+func (p *snapshotPolicy) due(tracks []*track, now time.Time) []*track {
+	for id, f := range p.lastSeen {
+		if now.Sub(f.at) > p.cooldown {
+			delete(p.lastSeen, id)
+		}
+	}
+
+	var due []*track
+	for _, t := range tracks {
+		if _, ok := p.lastSeen[t.id]; !ok && !p.recent(t) {
+			due = append(due, t)
+		}
+	}
+	for _, t := range tracks {
+		p.lastSeen[t.id] = seenFace{rect: t.rect, at: now}
+	}
+	return due
+}
+
+func (p *snapshotPolicy) recent(t *track) bool {
+	for _, f := range p.lastSeen {
+		if iou(t.rect, f.rect) >= trackIoU {
+			return true
+		}
+	}
+	return false
+}
+
+// saveSnapshot writes the crop of one tracked face, cut from the full
+// resolution frame, named after the camera, time and track as
+// snapshotPattern expects.
+func saveSnapshot(frame, resized gocv.Mat, t *track, now time.Time, opts *options) (string, error) {
+	if opts.private {
+		return "", errPrivate
+	}
+	sx := float64(frame.Cols()) / float64(resized.Cols())
+	sy := float64(frame.Rows()) / float64(resized.Rows())
+	bounds := image.Rect(0, 0, frame.Cols(), frame.Rows())
+	rect := composeCrop(scaleRect(t.rect, sx, sy), bounds, opts.composition)
+
+	region := frame.Region(rect)
+	defer region.Close()
+	crop := prepareCrop(region, opts)
+	defer crop.Close()
+
+	name := fmt.Sprintf("%s_%s_face%d.webp", slugify(opts.cameraName), now.Format("20060102-150405"), t.id)
+	return saveCrop(crop, filepath.Join(opts.outputDir, name), opts)
+}
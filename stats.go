@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"sort"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// faceSizeBuckets are the lower bounds, in pixels of the longer side, of
+// the face size histogram.
+var faceSizeBuckets = []int{0, 32, 64, 128, 256, 512}
+
+// datasetStats aggregates detection results over a batch.
+type datasetStats struct {
+	Images         int          `json:"images"`
+	Faces          int          `json:"faces"`
+	FacesPerImage  float64      `json:"faces_per_image"`
+	ZeroFaceImages int          `json:"zero_face_images"`
+	Distribution   map[int]int  `json:"face_count_distribution"`
+	Sizes          []sizeBucket `json:"face_sizes"`
+	NoFaces        []string     `json:"images_without_faces,omitempty"`
+}
+
+type sizeBucket struct {
+	Min   int `json:"min"`
+	Max   int `json:"max,omitempty"`
+	Count int `json:"count"`
+}
+
+func newDatasetStats() *datasetStats {
+	s := &datasetStats{Distribution: map[int]int{}}
+	for i, lo := range faceSizeBuckets {
+		b := sizeBucket{Min: lo}
+		if i+1 < len(faceSizeBuckets) {
+			b.Max = faceSizeBuckets[i+1] - 1
+		}
+		s.Sizes = append(s.Sizes, b)
+	}
+	return s
+}
+
+func (s *datasetStats) add(r imageResult) {
+	s.Images++
+	s.Faces += r.Faces
+	s.FacesPerImage = float64(s.Faces) / float64(s.Images)
+	s.Distribution[r.Faces]++
+	if r.Faces == 0 {
+		s.ZeroFaceImages++
+		s.NoFaces = append(s.NoFaces, r.Input)
+	}
+	for _, b := range r.Boxes {
+		side := max(b.W, b.H)
+		i := sort.SearchInts(faceSizeBuckets, side+1) - 1
+		s.Sizes[i].Count++
+	}
+}
+
+func (s *datasetStats) write(path string, mode os.FileMode) error {
+	sort.Strings(s.NoFaces)
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), mode)
+}
+
+// writeChart draws the face count distribution and the face size histogram
+// as two bar charts side by side.
+func (s *datasetStats) writeChart(path string, mode os.FileMode) error {
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 420))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	counts := make([]int, 0, len(s.Distribution))
+	for n := range s.Distribution {
+		counts = append(counts, n)
+	}
+	sort.Ints(counts)
+	var labels []string
+	var values []int
+	for _, n := range counts {
+		labels = append(labels, fmt.Sprint(n))
+		values = append(values, s.Distribution[n])
+	}
+	drawBars(img, image.Rect(20, 20, 490, 400), "Images by face count", labels, values)
+
+	labels, values = nil, nil
+	for _, b := range s.Sizes {
+		label := fmt.Sprintf("%d+", b.Min)
+		if b.Max > 0 {
+			label = fmt.Sprintf("<%d", b.Max+1)
+		}
+		labels = append(labels, label)
+		values = append(values, b.Count)
+	}
+	drawBars(img, image.Rect(510, 20, 980, 400), "Faces by size (px)", labels, values)
+
+	return writeAtomic(path, mode, func(w io.Writer) error {
+		return png.Encode(w, img)
+	})
+}
+
+func drawBars(img *image.RGBA, area image.Rectangle, title string, labels []string, values []int) {
+	drawText(img, area.Min.X, area.Min.Y+13, title)
+	if len(values) == 0 {
+		return
+	}
+	peak := 1
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+
+	plot := image.Rect(area.Min.X, area.Min.Y+40, area.Max.X, area.Max.Y-20)
+	slot := plot.Dx() / len(values)
+	bar := image.NewUniform(color.RGBA{70, 110, 200, 255})
+	for i, v := range values {
+		x := plot.Min.X + i*slot
+		h := v * plot.Dy() / peak
+		draw.Draw(img, image.Rect(x+slot/8, plot.Max.Y-h, x+slot-slot/8, plot.Max.Y), bar, image.Point{}, draw.Src)
+		drawText(img, x+slot/8, plot.Max.Y-h-4, fmt.Sprint(v))
+		drawText(img, x+slot/8, area.Max.Y-4, labels[i])
+	}
+}
+
+func drawText(img *image.RGBA, x, y int, text string) {
+	d := font.Drawer{Dst: img, Src: image.Black, Face: basicfont.Face7x13, Dot: fixed.P(x, y)}
+	d.DrawString(text)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// takeoutMetadata is the part of a Google Takeout JSON sidecar used here.
+type takeoutMetadata struct {
+	PhotoTakenTime struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"photoTakenTime"`
+	People []struct {
+		Name string `json:"name"`
+	} `json:"people"`
+}
+
+// takeoutSidecar finds the JSON Google Takeout exported for an image:
+// photo.jpg.supplemental-metadata.json in newer exports, photo.jpg.json in
+// older ones. Edited copies (photo-edited.jpg) share the original's.
+func takeoutSidecar(path string) (string, bool) {
+	dir, name := filepath.Split(path)
+	ext := filepath.Ext(name)
+	original := strings.TrimSuffix(name, ext)
+	original = strings.TrimSuffix(original, "-edited") + ext
+	for _, n := range []string{name, original} {
+		for _, suffix := range []string{".supplemental-metadata.json", ".json"} {
+			p := filepath.Join(dir, n+suffix)
+			if _, err := os.Stat(p); err == nil {
+				return p, true
+			}
+		}
+	}
+	return "", false
+}
+
+// applyTakeout fills in when an image was taken and who Google Photos says
+// is in it. A lone detected face in a photo of one named person is given
+// that name; with more people there is no telling which face is whose.
+func applyTakeout(r *imageResult) error {
+	path, ok := takeoutSidecar(r.Input)
+	if !ok {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var meta takeoutMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	if ts := meta.PhotoTakenTime.Timestamp; ts != "" {
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return errors.New("invalid photoTakenTime in " + path)
+		}
+		taken := time.Unix(sec, 0).UTC()
+		r.TakenAt = &taken
+	}
+	for _, p := range meta.People {
+		if p.Name != "" {
+			r.People = append(r.People, p.Name)
+		}
+	}
+	if len(r.People) == 1 && len(r.Boxes) == 1 {
+		r.Boxes[0].Name = r.People[0]
+	}
+	return nil
+}
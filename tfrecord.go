@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const (
+	compressionNone = "none"
+	compressionGzip = "gzip"
+	compressionZlib = "zlib"
+)
+
+func validateCompression(c string) error {
+	switch c {
+	case compressionNone, compressionGzip, compressionZlib:
+		return nil
+	}
+	return fmt.Errorf("unknown compression %q (want none, gzip or zlib)", c)
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// maskedCRC is the checksum TFRecord stores alongside lengths and data.
+func maskedCRC(data []byte) uint32 {
+	crc := crc32.Checksum(data, crc32c)
+	return (crc>>15 | crc<<17) + 0xa282ead8
+}
+
+// tfrecordWriter writes one TFRecord file, optionally compressed as a whole
+// the way TensorFlow's GZIP and ZLIB record options expect.
+type tfrecordWriter struct {
+	f    *os.File
+	buf  *bufio.Writer
+	comp io.WriteCloser
+	w    io.Writer
+}
+
+func newTFRecordWriter(path, compression string, mode os.FileMode) (*tfrecordWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return nil, err
+	}
+	t := &tfrecordWriter{f: f, buf: bufio.NewWriter(f)}
+	t.w = t.buf
+	switch compression {
+	case compressionGzip:
+		t.comp = gzip.NewWriter(t.buf)
+		t.w = t.comp
+	case compressionZlib:
+		t.comp = zlib.NewWriter(t.buf)
+		t.w = t.comp
+	}
+	return t, nil
+}
+
+func (t *tfrecordWriter) write(record []byte) error {
+	var header [12]byte
+	binary.LittleEndian.PutUint64(header[:8], uint64(len(record)))
+	binary.LittleEndian.PutUint32(header[8:], maskedCRC(header[:8]))
+	var footer [4]byte
+	binary.LittleEndian.PutUint32(footer[:], maskedCRC(record))
+	for _, b := range [][]byte{header[:], record, footer[:]} {
+		if _, err := t.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tfrecordWriter) Close() error {
+	var err error
+	if t.comp != nil {
+		err = t.comp.Close()
+	}
+	if ferr := t.buf.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// tfExample builds a serialized tf.train.Example. Only the protobuf wire
+// format needed for its three list types is implemented.
+type tfExample struct {
+	features []byte
+}
+
+func (e *tfExample) bytes(key string, values ...[]byte) {
+	var list []byte
+	for _, v := range values {
+		list = protoBytes(list, 1, v)
+	}
+	e.feature(key, 1, list)
+}
+
+func (e *tfExample) floats(key string, values ...float32) {
+	var packed []byte
+	for _, v := range values {
+		packed = binary.LittleEndian.AppendUint32(packed, math.Float32bits(v))
+	}
+	e.feature(key, 2, protoBytes(nil, 1, packed))
+}
+
+func (e *tfExample) ints(key string, values ...int64) {
+	var packed []byte
+	for _, v := range values {
+		packed = binary.AppendUvarint(packed, uint64(v))
+	}
+	e.feature(key, 3, protoBytes(nil, 1, packed))
+}
+
+// feature adds one entry of the Features map: key and a Feature holding the
+// list in field kind (1 bytes, 2 float, 3 int64).
+func (e *tfExample) feature(key string, kind int, list []byte) {
+	entry := protoBytes(nil, 1, []byte(key))
+	entry = protoBytes(entry, 2, protoBytes(nil, kind, list))
+	e.features = protoBytes(e.features, 1, entry)
+}
+
+func (e *tfExample) marshal() []byte {
+	return protoBytes(nil, 1, e.features)
+}
+
+// protoBytes appends a length-delimited protobuf field.
+func protoBytes(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// tfrecordExport writes one tf.train.Example per face crop, spread round
+// robin over shards files named PREFIX-00000-of-0000N.tfrecord.
+type tfrecordExport struct {
+	writers  []*tfrecordWriter
+	next     int
+	inputDir string
+}
+
+func newTFRecordExport(prefix string, shards int, compression, inputDir string, mode os.FileMode) (*tfrecordExport, error) {
+	if err := os.MkdirAll(filepath.Dir(prefix), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create TFRecord directory: %v", err)
+	}
+	ext := ".tfrecord"
+	switch compression {
+	case compressionGzip:
+		ext += ".gz"
+	case compressionZlib:
+		ext += ".zz"
+	}
+	t := &tfrecordExport{inputDir: inputDir}
+	for i := range shards {
+		w, err := newTFRecordWriter(fmt.Sprintf("%s-%05d-of-%05d%s", prefix, i, shards, ext), compression, mode)
+		if err != nil {
+			t.Close()
+			return nil, fmt.Errorf("failed to create TFRecord file: %v", err)
+		}
+		t.writers = append(t.writers, w)
+	}
+	return t, nil
+}
+
+// add writes the crops of one image. Each example holds the encoded crop,
+// its source, the label "face" and, for still images, the face box
+// normalized to the source image as image/object/bbox/{xmin,ymin,xmax,ymax}.
+func (t *tfrecordExport) add(r imageResult) {
+	for i, crop := range r.Crops {
+		data, err := os.ReadFile(crop)
+		if err != nil {
+			fmt.Printf("Error exporting %s to TFRecord: %v\n", crop, err)
+			continue
+		}
+		var e tfExample
+		e.bytes("image/encoded", data)
+		e.bytes("image/format", []byte("webp"))
+		e.bytes("image/filename", []byte(filepath.Base(crop)))
+		e.bytes("image/source_id", []byte(inputKey(r.Input, t.inputDir)))
+		e.bytes("image/object/class/text", []byte("face"))
+		e.ints("image/object/class/label", 1)
+		if len(r.Boxes) == len(r.Crops) && r.Width > 0 && r.Height > 0 {
+			b := r.Boxes[i]
+			w, h := float32(r.Width), float32(r.Height)
+			e.ints("image/source_width", int64(r.Width))
+			e.ints("image/source_height", int64(r.Height))
+			e.floats("image/object/bbox/xmin", float32(b.X)/w)
+			e.floats("image/object/bbox/ymin", float32(b.Y)/h)
+			e.floats("image/object/bbox/xmax", float32(b.X+b.W)/w)
+			e.floats("image/object/bbox/ymax", float32(b.Y+b.H)/h)
+		}
+
+		if err := t.writers[t.next].write(e.marshal()); err != nil {
+			fmt.Printf("Error writing TFRecord: %v\n", err)
+		}
+		t.next = (t.next + 1) % len(t.writers)
+	}
+}
+
+func (t *tfrecordExport) Close() error {
+	var err error
+	for _, w := range t.writers {
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
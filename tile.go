@@ -0,0 +1,50 @@
+//go:build !purego
+
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// tileOrigins returns the start offsets of windows of size tile covering
+// length with the given overlap fraction; the last window is aligned to the
+// far edge so nothing is left uncovered.
+func tileOrigins(length, tile int, overlap float64) []int {
+	if length <= tile {
+		return []int{0}
+	}
+	step := max(1, int(float64(tile)*(1-overlap)))
+	var origins []int
+	for pos := 0; pos+tile < length; pos += step {
+		origins = append(origins, pos)
+	}
+	return append(origins, length-tile)
+}
+
+// detectTiled slides a tile x tile window over the full-resolution image so
+// faces too small to survive downscaling are still found.
+func (d *detectors) detectTiled(img gocv.Mat, tile int, overlap float64) ([]image.Rectangle, error) {
+	if overlap < 0 || overlap >= 1 {
+		return nil, fmt.Errorf("tile overlap must be in [0, 1), got %v", overlap)
+	}
+
+	var faces []image.Rectangle
+	for _, y := range tileOrigins(img.Rows(), tile, overlap) {
+		for _, x := range tileOrigins(img.Cols(), tile, overlap) {
+			window := image.Rect(x, y, min(x+tile, img.Cols()), min(y+tile, img.Rows()))
+			region := img.Region(window)
+			found, err := d.detect(region, nil)
+			region.Close()
+			if err != nil {
+				return nil, err
+			}
+			for _, face := range found {
+				faces = append(faces, face.Add(window.Min))
+			}
+		}
+	}
+	return mergeDetections(faces), nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	timelineSRT  = "srt"
+	timelineJSON = "json"
+)
+
+func validateTimelineFormat(format string) error {
+	switch format {
+	case "", timelineSRT, timelineJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown timeline format %q (want srt or json)", format)
+}
+
+// segment is one continuous appearance of a tracked face in a video, with
+// times in seconds from the start.
+type segment struct {
+	Track int     `json:"track"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// writeTimeline writes segments as SRT subtitles (one cue per appearance,
+// overlapping when several faces are on screen) or as a JSON array.
+func writeTimeline(path, format string, segments []segment, mode os.FileMode) error {
+	var data []byte
+	switch format {
+	case timelineJSON:
+		var err error
+		if data, err = json.MarshalIndent(segments, "", "  "); err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	case timelineSRT:
+		var b strings.Builder
+		for i, s := range segments {
+			fmt.Fprintf(&b, "%d\n%s --> %s\nFace %d\n\n", i+1, srtTime(s.Start), srtTime(s.End), s.Track)
+		}
+		data = []byte(b.String())
+	default:
+		return fmt.Errorf("unknown timeline format %q", format)
+	}
+	return writeFileAtomic(path, data, mode)
+}
+
+// srtTime formats seconds as an SRT timestamp, HH:MM:SS,mmm.
+func srtTime(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+	h := d / time.Hour
+	m := d % time.Hour / time.Minute
+	s := d % time.Minute / time.Second
+	ms := d % time.Second / time.Millisecond
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", h, m, s, ms)
+}
@@ -0,0 +1,278 @@
+//go:build !purego
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+var (
+	tuneScaleFactors  = []float64{1.05, 1.1, 1.15, 1.2, 1.3}
+	tuneMinNeighbors  = []int{1, 2, 3, 4, 5, 6, 7, 8}
+	tuneMinConfidence = 0.05
+)
+
+// tuneScore counts matches against the labels for one candidate setting.
+type tuneScore struct {
+	settings                    map[string]string
+	truePos, falsePos, falseNeg int
+}
+
+func (s *tuneScore) add(found, labels []image.Rectangle) {
+	tp := 0
+	for _, j := range matchDetections(found, labels) {
+		if j >= 0 {
+			tp++
+		}
+	}
+	s.truePos += tp
+	s.falsePos += len(found) - tp
+	s.falseNeg += len(labels) - tp
+}
+
+func (s *tuneScore) f1() float64 {
+	if s.truePos == 0 {
+		return 0
+	}
+	return 2 * float64(s.truePos) / float64(2*s.truePos+s.falsePos+s.falseNeg)
+}
+
+// readLabels reads ground truth as a JSON object mapping image paths,
+// relative to the input directory unless absolute, to face boxes in pixels:
+// {"a.jpg": [{"x": 10, "y": 20, "w": 64, "h": 64}]}.
+func readLabels(path, inputDir string) (map[string][]image.Rectangle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read labels: %v", err)
+	}
+	var raw map[string][]box
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse labels: %v", err)
+	}
+	labels := make(map[string][]image.Rectangle, len(raw))
+	for name, boxes := range raw {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(inputDir, name)
+		}
+		rects := make([]image.Rectangle, len(boxes))
+		for i, b := range boxes {
+			rects[i] = b.rect()
+		}
+		labels[name] = rects
+	}
+	return labels, nil
+}
+
+// runTune sweeps the detection thresholds over the labeled images and
+// writes the settings with the best F1 score into the config file. Cascades
+// are tuned on --scale-factor and --min-neighbors, models on
+// --min-confidence; tuning both kinds at once is not supported. With
+// --hard-negatives, the false positives left at the best settings are then
+// exported as negative samples.
+func runTune(ctx context.Context, opts *options, det *detectors, labelsPath, configPath string) error {
+	labels, err := readLabels(labelsPath, opts.inputDir)
+	if err != nil {
+		return err
+	}
+
+	var cascades []*cascadeDetector
+	var nets []*netDetector
+	var onnxs []*onnxDetector
+	for _, fd := range det.list {
+		switch d := fd.(type) {
+		case *cascadeDetector:
+			cascades = append(cascades, d)
+		case *netDetector:
+			nets = append(nets, d)
+		case *onnxDetector:
+			onnxs = append(onnxs, d)
+		default:
+			return errors.New("only cascades and --model detectors can be tuned")
+		}
+	}
+	if len(cascades) > 0 && len(nets)+len(onnxs) > 0 {
+		return errors.New("tune cascades and models separately")
+	}
+
+	var scores []*tuneScore
+	if len(cascades) > 0 {
+		for _, sf := range tuneScaleFactors {
+			for _, mn := range tuneMinNeighbors {
+				scores = append(scores, &tuneScore{settings: map[string]string{
+					"scale-factor":  strconv.FormatFloat(sf, 'g', -1, 64),
+					"min-neighbors": strconv.Itoa(mn),
+				}})
+			}
+		}
+	} else {
+		// Models run once at a low threshold; higher ones filter the scores.
+		for _, d := range nets {
+			d.MinConfidence = tuneMinConfidence
+		}
+		for _, d := range onnxs {
+			d.minConfidence = float32(tuneMinConfidence)
+		}
+		for c := 0.1; c < 0.96; c += 0.05 {
+			scores = append(scores, &tuneScore{settings: map[string]string{
+				"min-confidence": strconv.FormatFloat(c, 'f', 2, 64),
+			}})
+		}
+	}
+
+	paths := make([]string, 0, len(labels))
+	for p := range labels {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if ctx.Err() != nil {
+			return errInterrupted
+		}
+		fmt.Printf("Tuning on %s\n", p)
+		if err := tuneImage(p, labels[p], opts, det, cascades, scores); err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+	}
+
+	best := scores[0]
+	for _, s := range scores[1:] {
+		if s.f1() > best.f1() {
+			best = s
+		}
+	}
+	fmt.Printf("Best F1 %.3f (%d found, %d false positives, %d missed) with %v\n",
+		best.f1(), best.truePos, best.falsePos, best.falseNeg, best.settings)
+
+	if configPath == "" {
+		configPath = filepath.Join(opts.outputDir, "tuned.conf")
+	}
+	if err := updateConfig(configPath, best.settings, opts.fileMode); err != nil {
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+	fmt.Printf("Wrote %s\n", configPath)
+
+	if opts.hardNegatives == "" {
+		return nil
+	}
+	applyTuned(best.settings, cascades, nets, onnxs)
+	sink, err := newNegativeSink(opts.hardNegatives, opts.fileMode)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+	for _, p := range paths {
+		if ctx.Err() != nil {
+			return errInterrupted
+		}
+		if err := exportNegatives(p, labels[p], opts, det, sink); err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+	}
+	fmt.Printf("Exported %d hard negatives to %s\n", sink.count, opts.hardNegatives)
+	return nil
+}
+
+// applyTuned configures the detectors with one candidate's settings.
+func applyTuned(settings map[string]string, cascades []*cascadeDetector, nets []*netDetector, onnxs []*onnxDetector) {
+	if v, ok := settings["scale-factor"]; ok {
+		sf, _ := strconv.ParseFloat(v, 64)
+		mn, _ := strconv.Atoi(settings["min-neighbors"])
+		for _, c := range cascades {
+			c.ScaleFactor, c.MinNeighbors = sf, mn
+		}
+	}
+	if v, ok := settings["min-confidence"]; ok {
+		conf, _ := strconv.ParseFloat(v, 64)
+		for _, d := range nets {
+			d.MinConfidence = conf
+		}
+		for _, d := range onnxs {
+			d.minConfidence = float32(conf)
+		}
+	}
+}
+
+// exportNegatives saves the detections in one image that match no label.
+func exportNegatives(path string, labels []image.Rectangle, opts *options, det *detectors, sink *negativeSink) error {
+	in, err := loadImage(path, opts)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	found, err := det.detect(in.resized, nil)
+	if err != nil {
+		return err
+	}
+	sx := float64(in.img.Cols()) / float64(in.resized.Cols())
+	sy := float64(in.img.Rows()) / float64(in.resized.Rows())
+	for i := range found {
+		found[i] = scaleRect(found[i], sx, sy)
+	}
+	for i, j := range matchDetections(found, labels) {
+		if j >= 0 {
+			continue
+		}
+		if err := sink.save(in.img, found[i], path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tuneImage scores every candidate setting on one image. Labels are in the
+// original image's pixels and are scaled into detection coordinates.
+func tuneImage(path string, labels []image.Rectangle, opts *options, det *detectors, cascades []*cascadeDetector, scores []*tuneScore) error {
+	in, err := loadImage(path, opts)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	sx := float64(in.resized.Cols()) / float64(in.img.Cols())
+	sy := float64(in.resized.Rows()) / float64(in.img.Rows())
+	scaled := make([]image.Rectangle, len(labels))
+	for i, l := range labels {
+		scaled[i] = scaleRect(l, sx, sy)
+	}
+
+	if len(cascades) > 0 {
+		for _, s := range scores {
+			applyTuned(s.settings, cascades, nil, nil)
+			found, err := det.detect(in.resized, nil)
+			if err != nil {
+				return err
+			}
+			s.add(found, scaled)
+		}
+		return nil
+	}
+
+	var all []Detection
+	for _, fd := range det.list {
+		found, err := fd.Detect(in.resized)
+		if err != nil {
+			return err
+		}
+		all = append(all, found...)
+	}
+	for _, s := range scores {
+		threshold, _ := strconv.ParseFloat(s.settings["min-confidence"], 64)
+		var kept []image.Rectangle
+		for _, d := range all {
+			if d.Confidence >= threshold {
+				kept = append(kept, d.Rect)
+			}
+		}
+		s.add(mergeDetections(kept), scaled)
+	}
+	return nil
+}
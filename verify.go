@@ -0,0 +1,148 @@
+//go:build !purego
+
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+const verifyEyes = "eyes"
+
+// faceVerifier takes a second look at a cascade detection before it is
+// kept. Cascades fire on textures and foliage; a real face also has a pair
+// of eyes or convinces a DNN.
+type faceVerifier interface {
+	verify(img gocv.Mat, face image.Rectangle) (bool, error)
+	Close() error
+}
+
+// loadVerifier loads the --verify check: "eyes", or a detector in the
+// --backend-a form that must find a face at the same place.
+func loadVerifier(spec string, base detectorConfig) (faceVerifier, error) {
+	if spec == verifyEyes {
+		path, err := modelPath(base.modelDir, eyeCascade)
+		if err != nil {
+			return nil, err
+		}
+		classifier := gocv.NewCascadeClassifier()
+		if !classifier.Load(path) {
+			classifier.Close()
+			return nil, fmt.Errorf("error loading Haar cascade file %s", path)
+		}
+		return &eyeVerifier{classifier: classifier}, nil
+	}
+
+	cfg, err := parseDetectorSpec(spec, base)
+	if err != nil {
+		return nil, err
+	}
+	cfg.verify, cfg.fallbacks = "", nil
+	det, err := loadDetectors(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &modelVerifier{det: det}, nil
+}
+
+// eyeVerifier wants two eyes side by side in the upper part of the face.
+type eyeVerifier struct {
+	classifier gocv.CascadeClassifier
+}
+
+func (v *eyeVerifier) verify(img gocv.Mat, face image.Rectangle) (bool, error) {
+	upper := image.Rect(face.Min.X, face.Min.Y, face.Max.X, face.Min.Y+face.Dy()*3/5).
+		Intersect(image.Rect(0, 0, img.Cols(), img.Rows()))
+	if upper.Empty() {
+		return false, nil
+	}
+	region := img.Region(upper)
+	defer region.Close()
+	gray := gocv.NewMat()
+	defer gray.Close()
+	gocv.CvtColor(region, &gray, gocv.ColorBGRToGray)
+
+	minEye := max(face.Dx()/10, 5)
+	eyes := v.classifier.DetectMultiScaleWithParams(gray, 1.1, 3, 0, image.Pt(minEye, minEye), image.Pt(face.Dx()/2, face.Dx()/2))
+	return eyePair(eyes), nil
+}
+
+func (v *eyeVerifier) Close() error {
+	return v.classifier.Close()
+}
+
+// eyePair reports whether two of eyes are about level, of about the same
+// size and apart from each other.
+func eyePair(eyes []image.Rectangle) bool {
+	for i, a := range eyes {
+		for _, b := range eyes[i+1:] {
+			size := max(a.Dx(), b.Dx())
+			if a.Overlaps(b) || min(a.Dx(), b.Dx())*2 < size {
+				continue
+			}
+			dx := abs(a.Min.X + a.Max.X - b.Min.X - b.Max.X)
+			dy := abs(a.Min.Y + a.Max.Y - b.Min.Y - b.Max.Y)
+			if dx >= size && dy <= size {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// modelVerifier runs a second detector on the face with some margin and
+// wants it to find a face whose center lies in the box.
+type modelVerifier struct {
+	det *detectors
+}
+
+func (v *modelVerifier) verify(img gocv.Mat, face image.Rectangle) (bool, error) {
+	pad := image.Pt(face.Dx()/4, face.Dy()/4)
+	window := image.Rectangle{Min: face.Min.Sub(pad), Max: face.Max.Add(pad)}.
+		Intersect(image.Rect(0, 0, img.Cols(), img.Rows()))
+	if window.Empty() {
+		return false, nil
+	}
+	region := img.Region(window)
+	defer region.Close()
+	found, err := v.det.detect(region, nil)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range found {
+		f = f.Add(window.Min)
+		if image.Pt((f.Min.X+f.Max.X)/2, (f.Min.Y+f.Max.Y)/2).In(face) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (v *modelVerifier) Close() error {
+	v.det.Close()
+	return nil
+}
+
+// verified drops the detections v rejects.
+func verified(v faceVerifier, img gocv.Mat, faces []Detection) ([]Detection, error) {
+	kept := faces[:0]
+	for _, f := range faces {
+		ok, err := v.verify(img, f.Rect)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify face: %v", err)
+		}
+		if ok {
+			kept = append(kept, f)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,362 @@
+//go:build !purego
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	defaultVideoFPS = 25
+	trackIoU        = 0.3
+)
+
+var videoExtensions = map[string]bool{
+	".mp4":  true,
+	".mov":  true,
+	".avi":  true,
+	".mkv":  true,
+	".webm": true,
+	".m4v":  true,
+}
+
+func isVideo(path string) bool {
+	return videoExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// track follows one face between detection passes. detected is set on
+// frames where rect came from the detector rather than the tracker. shown is
+// rect smoothed over frames for drawing, as x0, y0, x1, y1.
+type track struct {
+	id       int
+	rect     image.Rectangle
+	shown    [4]float64
+	tracker  gocv.Tracker
+	detected bool
+}
+
+func newTrack(id int, frame gocv.Mat, rect image.Rectangle) *track {
+	t := &track{id: id, rect: rect, tracker: gocv.NewTrackerMIL(), detected: true}
+	t.tracker.Init(frame, rect)
+	return t
+}
+
+// smooth updates shown with an exponential moving average: the previously
+// shown box prev keeps weight of it and rect gets the rest, so boxes glide
+// instead of jittering between frames. Without prev, shown is rect.
+func (t *track) smooth(prev *[4]float64, weight float64) {
+	cur := [4]float64{float64(t.rect.Min.X), float64(t.rect.Min.Y), float64(t.rect.Max.X), float64(t.rect.Max.Y)}
+	if prev == nil {
+		t.shown = cur
+		return
+	}
+	for i := range cur {
+		t.shown[i] = weight*prev[i] + (1-weight)*cur[i]
+	}
+}
+
+func (t *track) shownRect() image.Rectangle {
+	return image.Rect(int(math.Round(t.shown[0])), int(math.Round(t.shown[1])), int(math.Round(t.shown[2])), int(math.Round(t.shown[3])))
+}
+
+// videoTracker runs full detection every detectEvery frames and MIL tracking
+// in between, so long videos cost a fraction of per-frame detection while
+// boxes keep their identity from frame to frame.
+type videoTracker struct {
+	det         *detectors
+	opts        *options
+	detectEvery int
+	sceneCut    float64
+	frame       int
+	nextID      int
+	tracks      []*track
+
+	// force makes the next step run detection, after skipped frames.
+	force bool
+
+	// hist is the colour histogram of the previous frame, for cut detection.
+	hist gocv.Mat
+}
+
+func (v *videoTracker) Close() {
+	v.endTracks()
+	v.hist.Close()
+}
+
+func (v *videoTracker) endTracks() {
+	for _, t := range v.tracks {
+		t.tracker.Close()
+	}
+	v.tracks = nil
+}
+
+// step advances by one frame (already resized for detection) and returns
+// the live tracks. A scene cut ends every track and forces a detection pass,
+// so boxes never carry over into an unrelated shot.
+func (v *videoTracker) step(img, resized gocv.Mat) ([]*track, error) {
+	defer func() { v.frame++ }()
+	if v.sceneCut > 0 && v.isCut(resized) {
+		v.endTracks()
+		return v.redetect(img, resized)
+	}
+	if v.force || v.frame%v.detectEvery == 0 {
+		v.force = false
+		return v.redetect(img, resized)
+	}
+
+	live := v.tracks[:0]
+	for _, t := range v.tracks {
+		rect, ok := t.tracker.Update(resized)
+		if !ok {
+			t.tracker.Close()
+			continue
+		}
+		t.rect = rect
+		t.detected = false
+		prev := t.shown
+		t.smooth(&prev, v.opts.boxSmoothing)
+		live = append(live, t)
+	}
+	v.tracks = live
+	return v.tracks, nil
+}
+
+// skip passes over a frame without looking at it, ending any tracks; the
+// next step detects afresh.
+func (v *videoTracker) skip() {
+	v.endTracks()
+	v.force = true
+	v.frame++
+}
+
+// redetect replaces the tracks with fresh detections. A detection that
+// overlaps an existing track keeps that track's id; tracks with no matching
+// detection end.
+func (v *videoTracker) redetect(img, resized gocv.Mat) ([]*track, error) {
+	found, err := v.det.detectAll(img, resized, v.opts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var next []*track
+	used := make([]bool, len(v.tracks))
+	for _, face := range found.faces {
+		match := -1
+		best := trackIoU
+		for i, t := range v.tracks {
+			if overlap := iou(face, t.rect); !used[i] && overlap >= best {
+				match, best = i, overlap
+			}
+		}
+		var t *track
+		if match >= 0 {
+			used[match] = true
+			t = newTrack(v.tracks[match].id, resized, face)
+			t.smooth(&v.tracks[match].shown, v.opts.boxSmoothing)
+		} else {
+			v.nextID++
+			t = newTrack(v.nextID, resized, face)
+			t.smooth(nil, 0)
+		}
+		next = append(next, t)
+	}
+
+	v.endTracks()
+	v.tracks = next
+	return v.tracks, nil
+}
+
+// isCut compares the hue/saturation histogram of frame with the previous
+// frame's; a Bhattacharyya distance above sceneCut marks a new shot.
+func (v *videoTracker) isCut(frame gocv.Mat) bool {
+	hsv := gocv.NewMat()
+	defer hsv.Close()
+	gocv.CvtColor(frame, &hsv, gocv.ColorBGRToHSV)
+
+	mask := gocv.NewMat()
+	defer mask.Close()
+	hist := gocv.NewMat()
+	gocv.CalcHist([]gocv.Mat{hsv}, []int{0, 1}, mask, &hist, []int{50, 60}, []float64{0, 180, 0, 256}, false)
+	gocv.Normalize(hist, &hist, 0, 1, gocv.NormMinMax)
+
+	prev := v.hist
+	v.hist = hist
+	if prev.Empty() {
+		return false
+	}
+	defer prev.Close()
+	return float64(gocv.CompareHist(prev, hist, gocv.HistCmpBhattacharya)) > v.sceneCut
+}
+
+// drawTracks boxes each tracked face and labels it with its track id.
+func drawTracks(img *gocv.Mat, tracks []*track) {
+	for _, t := range tracks {
+		rect := t.shownRect()
+		gocv.Rectangle(img, rect, color.RGBA{255, 0, 0, 0}, 3)
+		gocv.PutText(img, strconv.Itoa(t.id), rect.Min.Add(image.Pt(0, -5)), gocv.FontHersheySimplex, 0.6, color.RGBA{255, 0, 0, 0}, 2)
+	}
+}
+
+// thumbnail is the best view of a track so far: its largest detected crop.
+type thumbnail struct {
+	crop gocv.Mat
+	area int
+	at   float64
+}
+
+// thumbnailName names a track thumbnail after the moment it was taken, e.g.
+// movie_00-12-31_face3.webp.
+func thumbnailName(stem string, seconds float64, id int) string {
+	s := int(seconds)
+	return fmt.Sprintf("%s_%02d-%02d-%02d_face%d.webp", stem, s/3600, s/60%60, s%60, id)
+}
+
+// processVideo detects and tracks faces through a video, writing an
+// annotated copy (MJPEG in AVI) with each face boxed and labelled with its
+// track id, a thumbnail per track, and optionally a timeline of when each
+// track is on screen. Faces in the result counts distinct tracks, and Dwell
+// says how long each stayed.
+func processVideo(videoPath string, names outputNames, opts *options, det *detectors) (imageResult, error) {
+	result := imageResult{Input: videoPath}
+
+	capture, err := gocv.VideoCaptureFile(videoPath)
+	if err != nil {
+		return result, fmt.Errorf("%w: %v", errUnreadable, err)
+	}
+	defer capture.Close()
+
+	fps := capture.Get(gocv.VideoCaptureFPS)
+	if fps <= 0 {
+		fps = defaultVideoFPS
+	}
+
+	frame := gocv.NewMat()
+	defer frame.Close()
+	resized := gocv.NewMat()
+	defer resized.Close()
+
+	tracker := &videoTracker{det: det, opts: opts, detectEvery: opts.detectEvery, sceneCut: opts.sceneCut, hist: gocv.NewMat()}
+	defer tracker.Close()
+
+	outputPath := strings.TrimSuffix(names.annotated, filepath.Ext(names.annotated)) + ".avi"
+	var writer *gocv.VideoWriter
+	defer func() {
+		if writer != nil {
+			writer.Close()
+		}
+	}()
+
+	thumbs := map[int]*thumbnail{}
+	defer func() {
+		for _, t := range thumbs {
+			t.crop.Close()
+		}
+	}()
+
+	var segments []segment
+	open := map[int]int{} // track id -> index in segments
+	for capture.Read(&frame) {
+		if frame.Empty() {
+			continue
+		}
+		resizeForDetection(frame, &resized, opts)
+
+		tracks, err := tracker.step(frame, resized)
+		if err != nil {
+			return result, fmt.Errorf("frame %d: %v", tracker.frame, err)
+		}
+
+		// The frame just processed ends at tracker.frame/fps.
+		end := float64(tracker.frame) / fps
+		seen := make(map[int]int, len(tracks))
+		for _, t := range tracks {
+			i, ok := open[t.id]
+			if !ok {
+				i = len(segments)
+				segments = append(segments, segment{Track: t.id, Start: end - 1/fps})
+			}
+			segments[i].End = end
+			seen[t.id] = i
+		}
+		open = seen
+
+		// Thumbnails come from detection frames only, where boxes are
+		// tight, and are cut from the full-resolution frame.
+		sx := float64(frame.Cols()) / float64(resized.Cols())
+		sy := float64(frame.Rows()) / float64(resized.Rows())
+		bounds := image.Rect(0, 0, frame.Cols(), frame.Rows())
+		for _, t := range tracks {
+			if !t.detected {
+				continue
+			}
+			rect := composeCrop(scaleRect(t.rect, sx, sy), bounds, opts.composition)
+			area := rect.Dx() * rect.Dy()
+			if best, ok := thumbs[t.id]; ok {
+				if area <= best.area {
+					continue
+				}
+				best.crop.Close()
+			}
+			region := frame.Region(rect)
+			thumbs[t.id] = &thumbnail{crop: region.Clone(), area: area, at: end - 1/fps}
+			region.Close()
+		}
+
+		// An annotated video cannot be sealed as it is encoded, so none is
+		// written when crops are encrypted.
+		if opts.sealer != nil {
+			continue
+		}
+		if writer == nil {
+			writer, err = gocv.VideoWriterFile(outputPath, "MJPG", fps, resized.Cols(), resized.Rows(), true)
+			if err != nil {
+				return result, fmt.Errorf("error creating output video: %v", err)
+			}
+			result.Annotated = outputPath
+		}
+		drawTracks(&resized, tracks)
+		if err := writer.Write(resized); err != nil {
+			return result, fmt.Errorf("error writing output video: %v", err)
+		}
+	}
+
+	if tracker.frame == 0 {
+		return result, errUnreadable
+	}
+	result.Faces = tracker.nextID
+	result.Dwell = dwellTimes(segments)
+
+	ids := make([]int, 0, len(thumbs))
+	for id := range thumbs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		t := thumbs[id]
+		crop := prepareCrop(t.crop, opts)
+		path, err := saveCrop(crop, filepath.Join(opts.outputDir, thumbnailName(names.stem, t.at, id)), opts)
+		crop.Close()
+		if err != nil {
+			return result, fmt.Errorf("error saving thumbnail: %v", err)
+		}
+		result.Crops = append(result.Crops, path)
+	}
+
+	if opts.timeline != "" {
+		path := strings.TrimSuffix(outputPath, ".avi") + "." + opts.timeline
+		if err := writeTimeline(path, opts.timeline, segments, opts.fileMode); err != nil {
+			return result, fmt.Errorf("error writing timeline: %v", err)
+		}
+		result.Timeline = path
+	}
+	return result, nil
+}
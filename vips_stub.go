@@ -0,0 +1,15 @@
+//go:build !vips && !purego
+
+package main
+
+import (
+	"errors"
+
+	"gocv.io/x/gocv"
+)
+
+const vipsAvailable = false
+
+func vipsDecode(path string, readFlag gocv.IMReadFlag) (gocv.Mat, error) {
+	return gocv.Mat{}, errors.New("built without libvips (rebuild with -tags vips)")
+}
@@ -0,0 +1,149 @@
+//go:build purego && js && wasm
+
+// The WebAssembly build runs the pure-Go detector in the browser so photos
+// never leave the user's machine. Build it with
+//
+//	GOOS=js GOARCH=wasm go build -tags purego -o face-detector.wasm .
+//
+// and load it next to Go's wasm_exec.js:
+//
+//	const go = new Go();
+//	const { instance } = await WebAssembly.instantiateStreaming(fetch("face-detector.wasm"), go.importObject);
+//	go.run(instance);
+//	const bytes = new Uint8Array(await file.arrayBuffer());
+//	const faces = faceDetector.detect(bytes, { minSize: 30, minConfidence: 5 });
+//	if (faces instanceof Error) throw faces;
+//	const crops = faceDetector.crop(bytes, { composition: "thirds" }); // WebP Uint8Arrays
+//
+// On undecodable input or bad options both functions return an Error
+// instead of a result. They do not throw: a panic in a callback would stop
+// the Go program and every later call with it.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"syscall/js"
+
+	"github.com/HugoSmits86/nativewebp"
+	pigo "github.com/esimov/pigo/core"
+)
+
+type jsOptions struct {
+	minSize       int
+	minConfidence float64
+	composition   string
+}
+
+func readJSOptions(args []js.Value) jsOptions {
+	opts := jsOptions{minSize: 30, minConfidence: 5.0, composition: compositionPadded}
+	if len(args) < 2 || args[1].Type() != js.TypeObject {
+		return opts
+	}
+	o := args[1]
+	if v := o.Get("minSize"); v.Type() == js.TypeNumber {
+		opts.minSize = v.Int()
+	}
+	if v := o.Get("minConfidence"); v.Type() == js.TypeNumber {
+		opts.minConfidence = v.Float()
+	}
+	if v := o.Get("composition"); v.Type() == js.TypeString {
+		opts.composition = v.String()
+	}
+	return opts
+}
+
+func decodeJSImage(args []js.Value) (image.Image, error) {
+	if len(args) < 1 {
+		return nil, errUnreadable
+	}
+	data := make([]byte, args[0].Get("length").Int())
+	js.CopyBytesToGo(data, args[0])
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errUnreadable
+	}
+	return img, nil
+}
+
+// jsError is err as a JavaScript Error value, to return to the caller.
+func jsError(err error) js.Value {
+	return js.Global().Get("Error").New(err.Error())
+}
+
+// jsFunc exposes fn to JavaScript, turning a panic into a returned Error
+// so that the Go program keeps running.
+func jsFunc(fn func(args []js.Value) any) js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) (result any) {
+		defer func() {
+			if r := recover(); r != nil {
+				result = jsError(fmt.Errorf("%v", r))
+			}
+		}()
+		return fn(args)
+	})
+}
+
+func main() {
+	classifier, err := newPigoClassifier()
+	if err != nil {
+		panic(err)
+	}
+
+	detect := jsFunc(func(args []js.Value) any {
+		img, err := decodeJSImage(args)
+		if err != nil {
+			return jsError(err)
+		}
+		opts := readJSOptions(args)
+
+		faces := js.Global().Get("Array").New()
+		for _, f := range detectPigo(classifier, img, opts.minSize, opts.minConfidence) {
+			faces.Call("push", map[string]any{
+				"x":      f.Min.X,
+				"y":      f.Min.Y,
+				"width":  f.Dx(),
+				"height": f.Dy(),
+			})
+		}
+		return faces
+	})
+
+	crop := jsFunc(func(args []js.Value) any {
+		img, err := decodeJSImage(args)
+		if err != nil {
+			return jsError(err)
+		}
+		opts := readJSOptions(args)
+		if err := validateComposition(opts.composition); err != nil {
+			return jsError(err)
+		}
+		return cropsToJS(classifier, img, opts)
+	})
+
+	js.Global().Set("faceDetector", map[string]any{
+		"detect": detect,
+		"crop":   crop,
+	})
+	select {}
+}
+
+func cropsToJS(classifier *pigo.Pigo, img image.Image, opts jsOptions) js.Value {
+	src := asSubImager(img)
+	crops := js.Global().Get("Array").New()
+	for _, face := range detectPigo(classifier, img, opts.minSize, opts.minConfidence) {
+		var buf bytes.Buffer
+		if err := nativewebp.Encode(&buf, src.SubImage(composeCrop(face, img.Bounds(), opts.composition)), nil); err != nil {
+			return jsError(err)
+		}
+		arr := js.Global().Get("Uint8Array").New(buf.Len())
+		js.CopyBytesToJS(arr, buf.Bytes())
+		crops.Call("push", arr)
+	}
+	return crops
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	xmpSidecar = "sidecar"
+	xmpEmbed   = "embed"
+)
+
+func validateXMPMode(mode string) error {
+	switch mode {
+	case "", xmpSidecar, xmpEmbed:
+		return nil
+	}
+	return fmt.Errorf("unknown XMP mode %q (want sidecar or embed)", mode)
+}
+
+// xmpRegions renders the faces of r as a Metadata Working Group regions
+// block, the form Lightroom, digiKam and most DAMs read face rectangles
+// from. MWG areas are normalized and give the centre of the box.
+func xmpRegions(r imageResult) string {
+	var b strings.Builder
+	b.WriteString(`   <mwg-rs:Regions rdf:parseType="Resource">` + "\n")
+	fmt.Fprintf(&b, `    <mwg-rs:AppliedToDimensions stDim:w="%d" stDim:h="%d" stDim:unit="pixel"/>`+"\n", r.Width, r.Height)
+	b.WriteString("    <mwg-rs:RegionList>\n     <rdf:Bag>\n")
+	w, h := float64(r.Width), float64(r.Height)
+	for _, f := range r.Boxes {
+		cx := (float64(f.X) + float64(f.W)/2) / w
+		cy := (float64(f.Y) + float64(f.H)/2) / h
+		b.WriteString(`      <rdf:li>` + "\n")
+		if f.Name != "" {
+			fmt.Fprintf(&b, `       <rdf:Description mwg-rs:Type="Face" mwg-rs:Name="%s">`+"\n", xmlEscape(f.Name))
+		} else {
+			b.WriteString(`       <rdf:Description mwg-rs:Type="Face">` + "\n")
+		}
+		fmt.Fprintf(&b, `        <mwg-rs:Area stArea:x="%.6f" stArea:y="%.6f" stArea:w="%.6f" stArea:h="%.6f" stArea:unit="normalized"/>`+"\n",
+			cx, cy, float64(f.W)/w, float64(f.H)/h)
+		b.WriteString("       </rdf:Description>\n      </rdf:li>\n")
+	}
+	b.WriteString("     </rdf:Bag>\n    </mwg-rs:RegionList>\n   </mwg-rs:Regions>\n")
+	return b.String()
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// xmpPacket wraps the regions in a complete XMP packet.
+func xmpPacket(r imageResult) string {
+	return `<?xpacket begin="` + "\uFEFF" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+` + xmpDescription(r) + ` </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>
+`
+}
+
+func xmpDescription(r imageResult) string {
+	return `  <rdf:Description rdf:about=""
+    xmlns:mwg-rs="http://www.metadataworkinggroup.com/schemas/regions/"
+    xmlns:stDim="http://ns.adobe.com/xap/1.0/sType/Dimensions#"
+    xmlns:stArea="http://ns.adobe.com/xmp/sType/Area#">
+` + xmpRegions(r) + "  </rdf:Description>\n"
+}
+
+// mergeXMP puts the regions of r into an existing packet: an earlier
+// regions block is replaced, otherwise a new description is added. Other
+// metadata, such as ratings and keywords from a DAM, is kept.
+func mergeXMP(packet string, r imageResult) (string, error) {
+	const open, close = "<mwg-rs:Regions", "</mwg-rs:Regions>"
+	if i := strings.Index(packet, open); i >= 0 {
+		j := strings.Index(packet[i:], close)
+		if j < 0 {
+			return "", errors.New("unterminated mwg-rs:Regions")
+		}
+		// Keep the indentation before the block; the new one brings its own.
+		start := strings.LastIndex(packet[:i], "\n") + 1
+		end := i + j + len(close)
+		if end < len(packet) && packet[end] == '\n' {
+			end++
+		}
+		return packet[:start] + xmpRegions(r) + packet[end:], nil
+	}
+	i := strings.Index(packet, "</rdf:RDF>")
+	if i < 0 {
+		return "", errors.New("no rdf:RDF element")
+	}
+	start := strings.LastIndex(packet[:i], "\n") + 1
+	return packet[:start] + xmpDescription(r) + packet[start:], nil
+}
+
+// sidecarPath is where Adobe tools look for the sidecar of an image:
+// photo.jpg -> photo.xmp.
+func sidecarPath(input string) string {
+	return strings.TrimSuffix(input, filepath.Ext(input)) + ".xmp"
+}
+
+// writeXMP records the faces of a still image in the given XMP mode.
+// Images without faces and videos are left alone.
+func writeXMP(r imageResult, mode string, perm os.FileMode) error {
+	if len(r.Boxes) == 0 || r.Width == 0 || r.Height == 0 {
+		return nil
+	}
+	if mode == xmpEmbed {
+		return embedXMP(r)
+	}
+	return writeXMPSidecar(r, perm)
+}
+
+// writeXMPSidecar writes or updates the sidecar of the input of r.
+func writeXMPSidecar(r imageResult, mode os.FileMode) error {
+	path := sidecarPath(r.Input)
+	packet := xmpPacket(r)
+	if data, err := os.ReadFile(path); err == nil {
+		if packet, err = mergeXMP(string(data), r); err != nil {
+			return fmt.Errorf("failed to update %s: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return writeFileAtomic(path, []byte(packet), mode)
+}
+
+var xmpSignature = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+// jpegSegments calls fn for each marker segment of a JPEG before the image
+// data, with the segment's span in data and its body.
+func jpegSegments(data []byte, fn func(marker byte, pos, end int, body []byte) error) error {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return errors.New("not a JPEG file")
+	}
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) {
+			return errors.New("truncated JPEG segment")
+		}
+		if err := fn(marker, pos, end, data[pos+4:end]); err != nil {
+			return err
+		}
+		pos = end
+	}
+	return nil
+}
+
+// embeddedXMP returns the XMP packet of a JPEG, if it has one.
+func embeddedXMP(data []byte) (string, bool) {
+	var packet string
+	jpegSegments(data, func(marker byte, _, _ int, body []byte) error {
+		if marker == 0xE1 && bytes.HasPrefix(body, xmpSignature) {
+			packet = string(body[len(xmpSignature):])
+		}
+		return nil
+	})
+	return packet, packet != ""
+}
+
+// embedXMP writes the regions into the XMP segment of a JPEG in place,
+// replacing and merging with an existing one. The file keeps its mode.
+func embedXMP(r imageResult) error {
+	info, err := os.Stat(r.Input)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(r.Input)
+	if err != nil {
+		return err
+	}
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return errors.New("embedded XMP is only supported for JPEG")
+	}
+
+	packet := xmpPacket(r)
+	insert, cutFrom, cutTo := 2, -1, -1
+	err = jpegSegments(data, func(marker byte, pos, end int, body []byte) error {
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(body, xmpSignature):
+			var err error
+			if packet, err = mergeXMP(string(body[len(xmpSignature):]), r); err != nil {
+				return err
+			}
+			cutFrom, cutTo = pos, end
+		case marker == 0xE0 || marker == 0xE1:
+			// XMP conventionally follows JFIF and Exif.
+			insert = end
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	payload := append(append([]byte{}, xmpSignature...), packet...)
+	if len(payload)+2 > 0xFFFF {
+		return errors.New("XMP packet too large for one JPEG segment")
+	}
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	var out []byte
+	if cutFrom >= 0 {
+		out = append(append(append(out, data[:cutFrom]...), segment...), data[cutTo:]...)
+	} else {
+		out = append(append(append(out, data[:insert]...), segment...), data[insert:]...)
+	}
+	return writeFileAtomic(r.Input, out, info.Mode().Perm())
+}